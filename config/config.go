@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"url-shortener/models"
@@ -24,6 +26,54 @@ type Config struct {
 	Port       string
 	BaseURL    string
 	GinMode    string
+
+	// GeoIP (offline MaxMind databases, optional)
+	GeoIPDBPath    string
+	GeoIPASNDBPath string
+	GeoIPCacheSize int
+
+	// Click ingestion pipeline
+	ClickQueueSize     int
+	ClickWorkers       int
+	ClickBatchSize     int
+	ClickFlushInterval time.Duration
+	ClickWALPath       string
+
+	// Webhook delivery
+	WebhookWorkers      int
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	WebhookBackoffBase  time.Duration
+	WebhookLogRetention time.Duration
+	WebhookAllowPrivate bool // Allow webhook URLs on loopback, link-local and private addresses
+
+	// Redirect lookup cache
+	CacheBackend     string // memory, redis or none
+	CacheSize        int
+	CacheTTL         time.Duration
+	CacheNegativeTTL time.Duration
+	RedisURL         string
+
+	// Rate limiting (requests per minute, 0 disables)
+	APIKeyRateLimit           int
+	AnonymousShortenRateLimit int
+
+	// Proxies (IPs or CIDRs) whose X-Forwarded-For header is believed; none by
+	// default, so clients are identified by the connection's address
+	TrustedProxies []string
+
+	// API key secrets
+	APIKeyHMACSecret    string
+	APIKeyRotationGrace time.Duration
+
+	// Admin users; the username/password pair only seeds the first owner
+	AdminUsername       string
+	AdminPassword       string
+	AdminSessionTTL     time.Duration
+	AdminLoginRateLimit int // Sign-in attempts per minute per IP
+
+	// DNS server (host:port) for custom domain verification; empty uses the system resolver
+	DNSResolver string
 }
 
 func Load() *Config {
@@ -45,6 +95,44 @@ func Load() *Config {
 		Port:       getEnv("PORT", "8080"),
 		BaseURL:    getEnv("BASE_URL", "http://localhost:8080"),
 		GinMode:    getEnv("GIN_MODE", "debug"),
+
+		GeoIPDBPath:    getEnv("GEOIP_DB_PATH", ""),
+		GeoIPASNDBPath: getEnv("GEOIP_ASN_DB_PATH", ""),
+		GeoIPCacheSize: getEnvInt("GEOIP_CACHE_SIZE", 10000),
+
+		ClickQueueSize:     getEnvInt("CLICK_QUEUE_SIZE", 10000),
+		ClickWorkers:       getEnvInt("CLICK_WORKERS", 4),
+		ClickBatchSize:     getEnvInt("CLICK_BATCH_SIZE", 500),
+		ClickFlushInterval: getEnvDuration("CLICK_FLUSH_INTERVAL", time.Second),
+		ClickWALPath:       getEnv("CLICK_WAL_PATH", "data/clicks.wal"),
+
+		WebhookWorkers:      getEnvInt("WEBHOOK_WORKERS", 4),
+		WebhookTimeout:      getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookMaxAttempts:  getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookBackoffBase:  getEnvDuration("WEBHOOK_BACKOFF_BASE", 30*time.Second),
+		WebhookLogRetention: getEnvDuration("WEBHOOK_LOG_RETENTION", 30*24*time.Hour),
+		WebhookAllowPrivate: getEnvBool("WEBHOOK_ALLOW_PRIVATE_NETWORKS", false),
+
+		CacheBackend:     getEnv("CACHE_BACKEND", "memory"),
+		CacheSize:        getEnvInt("CACHE_SIZE", 10000),
+		CacheTTL:         getEnvDuration("CACHE_TTL", 5*time.Minute),
+		CacheNegativeTTL: getEnvDuration("CACHE_NEGATIVE_TTL", 30*time.Second),
+		RedisURL:         getEnv("REDIS_URL", "redis://localhost:6379/0"),
+
+		APIKeyRateLimit:           getEnvInt("API_KEY_RATE_LIMIT", 600),
+		AnonymousShortenRateLimit: getEnvInt("ANONYMOUS_SHORTEN_RATE_LIMIT", 10),
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+
+		APIKeyHMACSecret:    getEnv("API_KEY_HMAC_SECRET", ""),
+		APIKeyRotationGrace: getEnvDuration("API_KEY_ROTATION_GRACE", 24*time.Hour),
+
+		AdminUsername:       getEnv("ADMIN_USERNAME", "admin"),
+		AdminPassword:       getEnv("ADMIN_PASSWORD", ""),
+		AdminSessionTTL:     getEnvDuration("ADMIN_SESSION_TTL", 12*time.Hour),
+		AdminLoginRateLimit: getEnvInt("ADMIN_LOGIN_RATE_LIMIT", 10),
+
+		DNSResolver: getEnv("DNS_RESOLVER", ""),
 	}
 }
 
@@ -55,6 +143,47 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+		log.Printf("Invalid value for %s, using default %d", key, defaultValue)
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+		log.Printf("Invalid value for %s, using default %t", key, defaultValue)
+	}
+	return defaultValue
+}
+
+// getEnvList reads a comma-separated list, nil when the variable is unset
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+		log.Printf("Invalid value for %s, using default %s", key, defaultValue)
+	}
+	return defaultValue
+}
+
 func InitDB(cfg *Config) *gorm.DB {
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBSSLMode)
@@ -86,11 +215,67 @@ func InitDB(cfg *Config) *gorm.DB {
 	sqlDB.SetConnMaxLifetime(time.Hour) // Connection max lifetime
 
 	// Auto migrate
-	err = db.AutoMigrate(&models.URL{}, &models.Click{}, &models.APIKey{})
+	err = db.AutoMigrate(models.Tables()...)
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	if err := migrateWorkspaces(db); err != nil {
+		log.Fatal("Failed to migrate workspaces:", err)
+	}
+
+	if err := protectAuditLog(db); err != nil {
+		log.Fatal("Failed to protect audit log:", err)
+	}
+
 	log.Println("Database connected and migrated successfully")
 	return db
 }
+
+// migrateWorkspaces creates the default workspace, which existing rows join
+// through the workspace_id column default, and drops the unique indexes that
+// became per-workspace or per-domain
+func migrateWorkspaces(db *gorm.DB) error {
+	defaultWorkspace := models.Workspace{ID: models.DefaultWorkspaceID, Slug: "default", Name: "Default"}
+	if err := db.FirstOrCreate(&defaultWorkspace, models.DefaultWorkspaceID).Error; err != nil {
+		return err
+	}
+	// The default workspace was inserted with an explicit ID, which doesn't advance the sequence
+	err := db.Exec("SELECT setval(pg_get_serial_sequence('workspaces', 'id'), GREATEST((SELECT MAX(id) FROM workspaces), 1))").Error
+	if err != nil {
+		return err
+	}
+
+	for index, model := range map[string]interface{}{
+		"idx_urls_url_hash": &models.URL{},
+		"idx_urls_code":     &models.URL{},
+		"idx_qr_logos_name": &models.QRLogo{},
+	} {
+		if db.Migrator().HasIndex(model, index) {
+			if err := db.Migrator().DropIndex(model, index); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// protectAuditLog makes audit_logs append-only by rejecting updates, deletes
+// and truncation at the database level, whatever the client
+func protectAuditLog(db *gorm.DB) error {
+	return db.Exec(`
+		CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_logs is append-only';
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS audit_logs_no_change ON audit_logs;
+		CREATE TRIGGER audit_logs_no_change BEFORE UPDATE OR DELETE ON audit_logs
+			FOR EACH ROW EXECUTE FUNCTION audit_logs_append_only();
+
+		DROP TRIGGER IF EXISTS audit_logs_no_truncate ON audit_logs;
+		CREATE TRIGGER audit_logs_no_truncate BEFORE TRUNCATE ON audit_logs
+			FOR EACH STATEMENT EXECUTE FUNCTION audit_logs_append_only();
+	`).Error
+}
@@ -1,15 +1,20 @@
 package handlers
 
 import (
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"url-shortener/middleware"
 	"url-shortener/models"
 	"url-shortener/services"
+	"url-shortener/utils"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -18,48 +23,69 @@ import (
 type AdminHandler struct {
 	analyticsService *services.AnalyticsService
 	urlService       *services.URLService
+	auditService     *services.AuditService
+	domainService    *services.DomainService
+	webhooks         *services.WebhookDispatcher
+	clickHub         *services.ClickHub
 }
 
-func NewAdminHandler(db *gorm.DB) *AdminHandler {
+// liveHeartbeatInterval keeps idle click streams from being closed by proxies
+const liveHeartbeatInterval = 15 * time.Second
+
+func NewAdminHandler(db *gorm.DB, urlCache services.URLCache, domainService *services.DomainService, webhooks *services.WebhookDispatcher, clickHub *services.ClickHub) *AdminHandler {
 	return &AdminHandler{
 		analyticsService: services.NewAnalyticsService(db),
-		urlService:       services.NewURLService(db),
+		urlService:       services.NewURLService(db, urlCache),
+		auditService:     services.NewAuditService(db),
+		domainService:    domainService,
+		webhooks:         webhooks,
+		clickHub:         clickHub,
 	}
 }
 
+// analytics returns the analytics service limited to the signed-in admin's
+// workspace, counting bots if the request asks for it
+func (h *AdminHandler) analytics(c *gin.Context) *services.AnalyticsService {
+	return h.analyticsService.ForWorkspace(middleware.ContextWorkspaceID(c)).WithBots(includeBots(c))
+}
+
+// urls returns the URL service limited to the signed-in admin's workspace
+func (h *AdminHandler) urls(c *gin.Context) *services.URLService {
+	return h.urlService.ForWorkspace(middleware.ContextWorkspaceID(c))
+}
+
 // Get all URLs with their analytics (enhanced with filtering)
 func (h *AdminHandler) GetAllURLsAnalytics(c *gin.Context) {
 	// Get pagination parameters
-	page := 1
-	if p := c.Query("page"); p != "" {
-		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
-			page = parsed
-		}
+	page, ok := queryInt(c, "page", 1, 1, math.MaxInt32)
+	if !ok {
+		return
 	}
-
-	limit := 25
-	if l := c.Query("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
-			limit = parsed
-		}
+	limit, ok := queryInt(c, "limit", 25, 1, 100)
+	if !ok {
+		return
 	}
 
 	// Get filter parameters
 	source := c.Query("source")     // api, web
 	platform := c.Query("platform") // ios, android, desktop, mac
-	minClicks := c.Query("min_clicks")
+	minClicks, ok := queryInt(c, "min_clicks", 0, 0, math.MaxInt32)
+	if !ok {
+		return
+	}
 	search := c.Query("search")
 
 	offset := (page - 1) * limit
 
-	analytics, total, err := h.analyticsService.GetAllURLsAnalytics(offset, limit)
+	analytics, total, err := h.analytics(c).GetAllURLsAnalytics(offset, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get analytics"})
 		return
 	}
 
 	// Apply client-side filters (in production, move to database query)
-	filteredAnalytics := h.applyFilters(analytics, source, platform, minClicks, search)
+	filteredAnalytics := h.applyFilters(analytics, source, platform, int64(minClicks), search)
+	h.setShortURLs(filteredAnalytics)
 
 	// Calculate total pages
 	totalPages := (total + int64(limit) - 1) / int64(limit)
@@ -75,10 +101,10 @@ func (h *AdminHandler) GetAllURLsAnalytics(c *gin.Context) {
 		"filters": gin.H{
 			"source":     source,
 			"platform":   platform,
-			"min_clicks": minClicks,
+			"min_clicks": c.Query("min_clicks"),
 			"search":     search,
 		},
-		"base_url": getBaseURL(),
+		"base_url": h.domainService.BaseURL(),
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -86,7 +112,7 @@ func (h *AdminHandler) GetAllURLsAnalytics(c *gin.Context) {
 
 // Get enhanced system-wide statistics
 func (h *AdminHandler) GetSystemStats(c *gin.Context) {
-	stats, err := h.analyticsService.GetSystemStats()
+	stats, err := h.analytics(c).GetSystemStats()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get system stats"})
 		return
@@ -97,33 +123,32 @@ func (h *AdminHandler) GetSystemStats(c *gin.Context) {
 
 // Get top performing URLs
 func (h *AdminHandler) GetTopURLs(c *gin.Context) {
-	limitStr := c.DefaultQuery("limit", "10")
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit <= 0 || limit > 100 {
-		limit = 10
+	limit, ok := queryInt(c, "limit", 10, 1, 100)
+	if !ok {
+		return
 	}
 
-	topURLs, err := h.analyticsService.GetTopURLs(limit)
+	topURLs, err := h.analytics(c).GetTopURLs(limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get top URLs"})
 		return
 	}
 
+	h.setShortURLs(topURLs)
 	c.JSON(http.StatusOK, gin.H{
 		"top_urls": topURLs,
-		"base_url": getBaseURL(),
+		"base_url": h.domainService.BaseURL(),
 	})
 }
 
 // Get recent activity
 func (h *AdminHandler) GetRecentActivity(c *gin.Context) {
-	limitStr := c.DefaultQuery("limit", "10")
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit <= 0 || limit > 50 {
-		limit = 10
+	limit, ok := queryInt(c, "limit", 10, 1, 50)
+	if !ok {
+		return
 	}
 
-	activity, err := h.analyticsService.GetRecentActivity(limit)
+	activity, err := h.analytics(c).GetRecentActivity(limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get recent activity"})
 		return
@@ -134,7 +159,7 @@ func (h *AdminHandler) GetRecentActivity(c *gin.Context) {
 
 // Get performance metrics
 func (h *AdminHandler) GetPerformanceMetrics(c *gin.Context) {
-	metrics, err := h.analyticsService.GetPerformanceMetrics()
+	metrics, err := h.analytics(c).GetPerformanceMetrics()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get performance metrics"})
 		return
@@ -145,7 +170,7 @@ func (h *AdminHandler) GetPerformanceMetrics(c *gin.Context) {
 
 // Get API key usage statistics
 func (h *AdminHandler) GetAPIKeyUsage(c *gin.Context) {
-	usage, err := h.analyticsService.GetAPIKeyUsage()
+	usage, err := h.analytics(c).GetAPIKeyUsage()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get API key usage"})
 		return
@@ -159,9 +184,12 @@ func (h *AdminHandler) GetAPIKeyUsage(c *gin.Context) {
 
 // Get geographic analytics
 func (h *AdminHandler) GetGeoAnalytics(c *gin.Context) {
-	code := c.Query("code") // Optional: get geo stats for specific URL
+	code, ok := queryCode(c) // Optional: get geo stats for specific URL
+	if !ok {
+		return
+	}
 
-	geoStats, err := h.analyticsService.GetGeoStats(code)
+	geoStats, err := h.analytics(c).GetGeoStats(code)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get geo analytics"})
 		return
@@ -194,9 +222,12 @@ func (h *AdminHandler) GetGeoAnalytics(c *gin.Context) {
 
 // Get referrer analytics
 func (h *AdminHandler) GetReferrerAnalytics(c *gin.Context) {
-	code := c.Query("code") // Optional: get referrer stats for specific URL
+	code, ok := queryCode(c) // Optional: get referrer stats for specific URL
+	if !ok {
+		return
+	}
 
-	referrerStats, err := h.analyticsService.GetReferrerStats(code)
+	referrerStats, err := h.analytics(c).GetReferrerStats(code)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get referrer analytics"})
 		return
@@ -209,15 +240,16 @@ func (h *AdminHandler) GetReferrerAnalytics(c *gin.Context) {
 
 // Get click trends
 func (h *AdminHandler) GetClickTrends(c *gin.Context) {
-	code := c.Query("code")
-	daysStr := c.DefaultQuery("days", "7")
-
-	days, err := strconv.Atoi(daysStr)
-	if err != nil || days <= 0 || days > 365 {
-		days = 7
+	code, ok := queryCode(c)
+	if !ok {
+		return
+	}
+	days, ok := queryInt(c, "days", 7, 1, 365)
+	if !ok {
+		return
 	}
 
-	trends, err := h.analyticsService.GetClickTrends(code, days)
+	trends, err := h.analytics(c).GetClickTrends(code, days)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get click trends"})
 		return
@@ -233,18 +265,20 @@ func (h *AdminHandler) GetClickTrends(c *gin.Context) {
 // Export analytics data
 func (h *AdminHandler) ExportAnalytics(c *gin.Context) {
 	format := c.DefaultQuery("format", "json") // json, csv
-	timeRange := c.DefaultQuery("range", "30") // days
-
-	days, err := strconv.Atoi(timeRange)
-	if err != nil || days <= 0 {
-		days = 30
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+	days, ok := queryInt(c, "range", 30, 1, 365) // days
+	if !ok {
+		return
 	}
 
 	// Get data for export
 	startTime := time.Now().AddDate(0, 0, -days)
 	endTime := time.Now()
 
-	clicks, err := h.analyticsService.GetClicksByTimeRange("", startTime, endTime)
+	clicks, err := h.analytics(c).GetClicksByTimeRange("", startTime, endTime)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
 		return
@@ -257,7 +291,7 @@ func (h *AdminHandler) ExportAnalytics(c *gin.Context) {
 			Clicks:     clicks,
 			ExportedAt: time.Now(),
 			Filters: map[string]interface{}{
-				"time_range": timeRange,
+				"time_range": days,
 				"format":     format,
 			},
 		}
@@ -273,12 +307,30 @@ func (h *AdminHandler) DeleteURL(c *gin.Context) {
 		return
 	}
 
-	err := h.urlService.SoftDeleteURL(code)
+	urls := h.urls(c)
+	url, err := urls.GetURLByCodeIncludingDeleted(code)
+	if err == nil {
+		err = urls.SoftDeleteURL(code)
+	}
+	if errors.Is(err, services.ErrURLNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete URL"})
 		return
 	}
 
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditURLDelete,
+		ResourceType: "url",
+		ResourceID:   code,
+		Before:       services.AuditSnapshot(url),
+	})
+	if !url.DeletedAt.Valid {
+		h.webhooks.LinkEvent(models.EventLinkDeleted, url)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "URL deleted successfully",
 		"code":    code,
@@ -293,50 +345,139 @@ func (h *AdminHandler) RestoreURL(c *gin.Context) {
 		return
 	}
 
-	err := h.urlService.RestoreURL(code)
+	urls := h.urls(c)
+	url, err := urls.GetURLByCodeIncludingDeleted(code)
+	if err == nil {
+		err = urls.RestoreURL(code)
+	}
+	if errors.Is(err, services.ErrURLNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore URL"})
 		return
 	}
 
+	// Restoring a live URL is a no-op and isn't audited
+	if url.DeletedAt.Valid {
+		restored := *url
+		restored.DeletedAt = gorm.DeletedAt{}
+		changedBefore, changedAfter := services.AuditDiff(url, &restored)
+		recordAudit(c, h.auditService, models.AuditLog{
+			Action:       models.AuditURLRestore,
+			ResourceType: "url",
+			ResourceID:   code,
+			Before:       changedBefore,
+			After:        changedAfter,
+		})
+		h.webhooks.LinkEvent(models.EventLinkUpdated, &restored)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "URL restored successfully",
 		"code":    code,
 	})
 }
 
-// Get real-time statistics
+// GetRealTimeStats returns a snapshot of the last hour's activity. StreamClicks
+// pushes clicks as they are recorded.
 func (h *AdminHandler) GetRealTimeStats(c *gin.Context) {
-	// This would be implemented with WebSocket or Server-Sent Events in production
-	// For now, return recent data
+	now := time.Now()
+	oneHourAgo := now.Add(-1 * time.Hour)
 
-	// Get clicks in last 5 minutes for active users simulation
-	fiveMinutesAgo := time.Now().Add(-5 * time.Minute)
-	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	analytics := h.analytics(c)
+	hourlyClicks, err := analytics.GetClicksByTimeRange("", oneHourAgo, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get real-time stats"})
+		return
+	}
 
-	recentClicks, _ := h.analyticsService.GetClicksByTimeRange("", fiveMinutesAgo, time.Now())
-	hourlyClicks, _ := h.analyticsService.GetClicksByTimeRange("", oneHourAgo, time.Now())
+	urlsLastHour, err := analytics.CountURLsCreatedSince(oneHourAgo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get real-time stats"})
+		return
+	}
 
-	// Get recent activity
-	activity, _ := h.analyticsService.GetRecentActivity(10)
+	// Active users are unique IPs in the last 5 minutes; clicks are newest first
+	fiveMinutesAgo := now.Add(-5 * time.Minute)
+	activeIPs := make(map[string]struct{})
+	topCountries := make(map[string]int64)
+	topReferrers := make(map[string]int64)
+	for _, click := range hourlyClicks {
+		if click.ClickedAt.After(fiveMinutesAgo) {
+			activeIPs[click.IPAddress] = struct{}{}
+		}
+		if click.Country != "" {
+			topCountries[click.Country]++
+		}
+		referrer := click.Referrer
+		if referrer == "" {
+			referrer = "Direct"
+		}
+		topReferrers[referrer]++
+	}
 
-	realTimeStats := models.RealTimeStats{
-		ActiveUsers:    int64(len(recentClicks)), // Simplified - unique IPs in last 5 min
+	c.JSON(http.StatusOK, models.RealTimeStats{
+		ActiveUsers:    int64(len(activeIPs)),
 		ClicksLastHour: int64(len(hourlyClicks)),
-		LiveClicks:     recentClicks[:min(10, len(recentClicks))],
-	}
+		URLsLastHour:   urlsLastHour,
+		TopCountries:   topCountries,
+		TopReferrers:   topReferrers,
+		LiveClicks:     hourlyClicks[:min(10, len(hourlyClicks))],
+	})
+}
 
-	if activity != nil {
-		realTimeStats.URLsLastHour = int64(len(activity.RecentURLs))
+// StreamClicks streams the workspace's clicks, or those of ?code=, as
+// Server-Sent Events once they are stored. Each "click" event holds a
+// models.LiveClick. A client that falls too far behind gets an "evicted" event
+// and is disconnected; EventSource reconnects on its own.
+func (h *AdminHandler) StreamClicks(c *gin.Context) {
+	code, ok := queryCode(c)
+	if !ok {
+		return
 	}
 
-	c.JSON(http.StatusOK, realTimeStats)
+	sub, err := h.clickHub.Subscribe(middleware.ContextWorkspaceID(c), code)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	defer h.clickHub.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Stop nginx from buffering the stream
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(liveHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case click, ok := <-sub.C:
+			if !ok {
+				if sub.Evicted() {
+					c.SSEvent("evicted", gin.H{"error": "Stream fell too far behind"})
+				}
+				return false
+			}
+			c.SSEvent("click", click)
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
 }
 
 // Bulk operations
 func (h *AdminHandler) BulkDeleteURLs(c *gin.Context) {
 	var request struct {
-		Codes []string `json:"codes" binding:"required"`
+		Codes []string `json:"codes" binding:"required,min=1,max=1000"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -344,11 +485,16 @@ func (h *AdminHandler) BulkDeleteURLs(c *gin.Context) {
 		return
 	}
 
-	var results []gin.H
+	results := make([]gin.H, 0, len(request.Codes))
+	audits := make([]models.AuditLog, 0, len(request.Codes))
 	successCount := 0
 
+	urls := h.urls(c)
 	for _, code := range request.Codes {
-		err := h.urlService.SoftDeleteURL(code)
+		url, err := urls.GetURLByCodeIncludingDeleted(code)
+		if err == nil {
+			err = urls.SoftDeleteURL(code)
+		}
 		if err != nil {
 			results = append(results, gin.H{
 				"code":    code,
@@ -360,9 +506,19 @@ func (h *AdminHandler) BulkDeleteURLs(c *gin.Context) {
 				"code":    code,
 				"success": true,
 			})
+			audits = append(audits, models.AuditLog{
+				Action:       models.AuditURLBulkDelete,
+				ResourceType: "url",
+				ResourceID:   code,
+				Before:       services.AuditSnapshot(url),
+			})
+			if !url.DeletedAt.Valid {
+				h.webhooks.LinkEvent(models.EventLinkDeleted, url)
+			}
 			successCount++
 		}
 	}
+	recordAudit(c, h.auditService, audits...)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":       "Bulk delete completed",
@@ -375,15 +531,43 @@ func (h *AdminHandler) BulkDeleteURLs(c *gin.Context) {
 
 // Helper functions
 
-func (h *AdminHandler) applyFilters(analytics []models.URLAnalyticsSummary, source, platform, minClicksStr, search string) []models.URLAnalyticsSummary {
-	filtered := make([]models.URLAnalyticsSummary, 0)
+// queryInt reads an optional integer query parameter within [lo, hi].
+// On invalid input it responds with 400 and returns false.
+func queryInt(c *gin.Context, name string, def, lo, hi int) (int, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return def, true
+	}
 
-	minClicks := int64(0)
-	if minClicksStr != "" {
-		if parsed, err := strconv.ParseInt(minClicksStr, 10, 64); err == nil {
-			minClicks = parsed
-		}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < lo || value > hi {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("%s must be an integer between %d and %d", name, lo, hi),
+		})
+		return 0, false
 	}
+	return value, true
+}
+
+// includeBots reads the optional "include_bots" toggle of analytics endpoints.
+// Anything but a true value leaves bots out.
+func includeBots(c *gin.Context) bool {
+	include, _ := strconv.ParseBool(c.Query("include_bots"))
+	return include
+}
+
+// queryCode reads the optional "code" filter, rejecting values that can't be short codes
+func queryCode(c *gin.Context) (string, bool) {
+	code := c.Query("code")
+	if code != "" && !utils.IsValidShortCode(code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid short code"})
+		return "", false
+	}
+	return code, true
+}
+
+func (h *AdminHandler) applyFilters(analytics []models.URLAnalyticsSummary, source, platform string, minClicks int64, search string) []models.URLAnalyticsSummary {
+	filtered := make([]models.URLAnalyticsSummary, 0)
 
 	for _, url := range analytics {
 		// Source filter
@@ -427,19 +611,24 @@ func (h *AdminHandler) exportAsCSV(c *gin.Context, clicks []models.Click) {
 	c.Header("Content-Type", "text/csv")
 	c.Header("Content-Disposition", "attachment; filename=analytics_export.csv")
 
-	// CSV header
-	csvData := "URL Code,Original URL,IP Address,User Agent,Platform,Browser,OS,Country,City,Referrer,Clicked At\n"
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"URL Code", "Original URL", "IP Address", "User Agent", "Platform", "Browser", "OS", "Country", "City", "Referrer", "Clicked At"})
 
-	// CSV data
+	// Look each URL up once; deleted URLs are still exported
+	urls := h.urls(c)
+	originalURLs := make(map[string]string)
 	for _, click := range clicks {
-		// Get URL info
-		url, _ := h.urlService.GetURLByCode(click.URLCode)
-		originalURL := ""
-		if url != nil {
-			originalURL = url.OriginalURL
+		originalURL, seen := originalURLs[click.URLCode]
+		if !seen {
+			if url, err := urls.GetURLByCodeIncludingDeleted(click.URLCode); err == nil {
+				originalURL = url.OriginalURL
+			}
+			originalURLs[click.URLCode] = originalURL
 		}
 
-		csvData += fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s\n",
+		w.Write([]string{
 			click.URLCode,
 			originalURL,
 			click.IPAddress,
@@ -451,19 +640,16 @@ func (h *AdminHandler) exportAsCSV(c *gin.Context, clicks []models.Click) {
 			click.City,
 			click.Referrer,
 			click.ClickedAt.Format("2006-01-02 15:04:05"),
-		)
+		})
 	}
-
-	c.String(http.StatusOK, csvData)
+	w.Flush()
 }
 
-// Get base URL helper
-func getBaseURL() string {
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = "http://localhost:8080"
+// setShortURLs fills in the short URL of each summary on its link's domain
+func (h *AdminHandler) setShortURLs(summaries []models.URLAnalyticsSummary) {
+	for i := range summaries {
+		summaries[i].ShortURL = h.domainService.ShortURL(summaries[i].DomainID, summaries[i].Code)
 	}
-	return baseURL
 }
 
 // Utility function
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"url-shortener/middleware"
+	"url-shortener/models"
+	"url-shortener/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminAuthHandler struct {
+	userService  *services.AdminUserService
+	auditService *services.AuditService
+	sessionTTL   time.Duration
+	baseURL      string
+	secureCookie bool
+}
+
+// NewAdminAuthHandler handles sign-in and the admin pages. Session cookies are
+// marked Secure when the service is served over HTTPS.
+func NewAdminAuthHandler(userService *services.AdminUserService, auditService *services.AuditService, sessionTTL time.Duration, baseURL string) *AdminAuthHandler {
+	return &AdminAuthHandler{
+		userService:  userService,
+		auditService: auditService,
+		sessionTTL:   sessionTTL,
+		baseURL:      baseURL,
+		secureCookie: strings.HasPrefix(baseURL, "https://"),
+	}
+}
+
+// Page renders an admin HTML page for the signed-in user. Pages send the CSRF
+// token from their csrf-token meta tag with every unsafe request.
+func (h *AdminAuthHandler) Page(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		csrfToken := ""
+		if session := middleware.ContextAdminSession(c); session != nil {
+			csrfToken = session.CSRFToken
+		}
+		c.HTML(http.StatusOK, name, gin.H{
+			"BaseURL":   h.baseURL,
+			"User":      middleware.ContextAdminUser(c),
+			"CSRFToken": csrfToken,
+		})
+	}
+}
+
+// LoginPage shows the sign-in form
+func (h *AdminAuthHandler) LoginPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "login.html", gin.H{
+		"BaseURL": h.baseURL,
+		"Next":    safeNext(c.Query("next")),
+	})
+}
+
+// Login signs an admin in from the HTML form or a JSON body. Form posts are
+// redirected to the page they came from; JSON callers get the user and CSRF token.
+func (h *AdminAuthHandler) Login(c *gin.Context) {
+	isForm := c.ContentType() != "application/json"
+	next := safeNext(c.PostForm("next"))
+
+	var req models.LoginRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.loginFailed(c, isForm, next, http.StatusBadRequest, "Username and password are required")
+		return
+	}
+
+	user, err := h.userService.Authenticate(req.Username, req.Password, c.ClientIP())
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		recordAudit(c, h.auditService, models.AuditLog{
+			Action:    models.AuditAdminLoginFailed,
+			ActorType: models.ActorAdmin,
+			ActorID:   truncate(strings.ToLower(req.Username), 64),
+		})
+		h.loginFailed(c, isForm, next, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+	if errors.Is(err, services.ErrTooManyLoginAttempts) {
+		retryAfter := h.userService.LoginRetryAfter(req.Username, c.ClientIP())
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		h.loginFailed(c, isForm, next, http.StatusTooManyRequests, "Too many failed sign-in attempts. Please try again later.")
+		return
+	}
+	if err != nil {
+		h.loginFailed(c, isForm, next, http.StatusInternalServerError, "Failed to sign in")
+		return
+	}
+
+	token, session, err := h.userService.CreateSession(user, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		h.loginFailed(c, isForm, next, http.StatusInternalServerError, "Failed to sign in")
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(middleware.AdminSessionCookie, token, int(h.sessionTTL.Seconds()), "/", "", h.secureCookie, true)
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		WorkspaceID:  user.WorkspaceID,
+		Action:       models.AuditAdminLogin,
+		ActorType:    models.ActorAdmin,
+		ActorID:      user.Username,
+		ResourceType: "admin_session",
+		ResourceID:   strconv.FormatUint(uint64(session.ID), 10),
+	})
+
+	if isForm {
+		c.Redirect(http.StatusSeeOther, next)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user":       user,
+		"csrf_token": session.CSRFToken,
+		"expires_at": session.ExpiresAt,
+	})
+}
+
+func (h *AdminAuthHandler) loginFailed(c *gin.Context, isForm bool, next string, status int, message string) {
+	if isForm {
+		c.HTML(status, "login.html", gin.H{
+			"BaseURL": h.baseURL,
+			"Next":    next,
+			"Error":   message,
+		})
+		return
+	}
+	c.JSON(status, gin.H{"error": message})
+}
+
+// Logout ends the current session. It must run after AdminAuth, which checks
+// the CSRF token so other sites can't sign admins out.
+func (h *AdminAuthHandler) Logout(c *gin.Context) {
+	if token, err := c.Cookie(middleware.AdminSessionCookie); err == nil {
+		h.userService.DeleteSession(token)
+	}
+
+	entry := models.AuditLog{Action: models.AuditAdminLogout}
+	if session := middleware.ContextAdminSession(c); session != nil {
+		entry.ResourceType = "admin_session"
+		entry.ResourceID = strconv.FormatUint(uint64(session.ID), 10)
+	}
+	recordAudit(c, h.auditService, entry)
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(middleware.AdminSessionCookie, "", -1, "/", "", h.secureCookie, true)
+
+	if c.ContentType() == "application/json" {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/login")
+}
+
+// Me returns the signed-in admin user
+func (h *AdminAuthHandler) Me(c *gin.Context) {
+	c.JSON(http.StatusOK, middleware.ContextAdminUser(c))
+}
+
+// ChangePassword changes the signed-in user's password and signs out their other sessions
+func (h *AdminAuthHandler) ChangePassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var keepSession uint
+	if session := middleware.ContextAdminSession(c); session != nil {
+		keepSession = session.ID
+	}
+
+	user := middleware.ContextAdminUser(c)
+	err := h.userService.ChangePassword(user, req.CurrentPassword, req.NewPassword, keepSession)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditAdminPasswordChange,
+		ResourceType: "admin_user",
+		ResourceID:   user.Username,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+// safeNext only allows redirects to local paths, defaulting to the admin home
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/admin"
+	}
+	return next
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
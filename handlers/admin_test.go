@@ -0,0 +1,490 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"url-shortener/models"
+	"url-shortener/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The tests that read and write rows run against the Postgres database named
+// by TEST_DATABASE_URL, which they empty first, and are skipped without it.
+// The others use a database that refuses connections.
+
+// unreachableDSN points at a port nothing listens on
+const unreachableDSN = "host=127.0.0.1 port=1 user=test dbname=test sslmode=disable connect_timeout=1"
+
+func openTestDB(t *testing.T, dsn string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger:               logger.Default.LogMode(logger.Silent),
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// testDB returns an empty, migrated database or skips the test
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db := openTestDB(t, dsn)
+	if err := db.AutoMigrate(models.Tables()...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	var tables []string
+	for _, model := range models.Tables() {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatalf("parse %T: %v", model, err)
+		}
+		tables = append(tables, stmt.Table)
+	}
+	if err := db.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE").Error; err != nil {
+		t.Fatalf("empty tables: %v", err)
+	}
+	for _, workspace := range []models.Workspace{
+		{ID: models.DefaultWorkspaceID, Slug: "default", Name: "Default"},
+		{ID: 2, Slug: "other", Name: "Other"},
+	} {
+		if err := db.Create(&workspace).Error; err != nil {
+			t.Fatalf("create workspace: %v", err)
+		}
+	}
+	return db
+}
+
+// newAdminRouter serves the admin handler's routes as main.go does, signed in
+// as an editor of workspaceID
+func newAdminRouter(t *testing.T, db *gorm.DB, workspaceID uint) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	domainService := services.NewDomainService(db, nil, "http://localhost:8080")
+	webhooks := services.NewWebhookDispatcher(db, domainService, services.WebhookConfig{})
+	t.Cleanup(webhooks.Close)
+	handler := NewAdminHandler(db, services.NewURLCache("none", 0, 0, 0, ""), domainService, webhooks, services.NewClickHub())
+
+	r := gin.New()
+	api := r.Group("/admin/api/v1")
+	api.Use(func(c *gin.Context) {
+		c.Set("admin_user", &models.AdminUser{Username: "editor", Role: models.RoleEditor, WorkspaceID: workspaceID})
+		c.Set("workspace_id", workspaceID)
+		c.Next()
+	})
+	api.GET("/urls/analytics", handler.GetAllURLsAnalytics)
+	api.GET("/system/stats", handler.GetSystemStats)
+	api.GET("/system/performance", handler.GetPerformanceMetrics)
+	api.GET("/system/realtime", handler.GetRealTimeStats)
+	api.GET("/urls/top", handler.GetTopURLs)
+	api.GET("/activity", handler.GetRecentActivity)
+	api.GET("/api-keys/usage", handler.GetAPIKeyUsage)
+	api.GET("/analytics/geo", handler.GetGeoAnalytics)
+	api.GET("/analytics/referrers", handler.GetReferrerAnalytics)
+	api.GET("/analytics/trends", handler.GetClickTrends)
+	api.GET("/analytics/export", handler.ExportAnalytics)
+	api.POST("/urls/bulk-delete", handler.BulkDeleteURLs)
+	api.DELETE("/urls/:code", handler.DeleteURL)
+	api.POST("/urls/:code/restore", handler.RestoreURL)
+	return r
+}
+
+func serve(r *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/admin/api/v1"+path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// errorMessage returns the error of a {"error": "..."} response
+func errorMessage(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, w.Body)
+	}
+	message, ok := envelope["error"].(string)
+	if !ok || message == "" {
+		t.Fatalf("response has no error message: %s", w.Body)
+	}
+	if len(envelope) != 1 {
+		t.Errorf("error response has fields besides error: %s", w.Body)
+	}
+	return message
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response: %v: %s", err, w.Body)
+	}
+}
+
+func TestAdminValidationErrors(t *testing.T) {
+	r := newAdminRouter(t, openTestDB(t, unreachableDSN), models.DefaultWorkspaceID)
+
+	tests := []struct {
+		method, path, body string
+		want               string // Error message, or the parts of it between "*"s
+	}{
+		{"GET", "/urls/analytics?page=0", "", "page must be an integer between 1 and 2147483647"},
+		{"GET", "/urls/analytics?limit=101", "", "limit must be an integer between 1 and 100"},
+		{"GET", "/urls/analytics?limit=ten", "", "limit must be an integer between 1 and 100"},
+		{"GET", "/urls/analytics?min_clicks=-1", "", "min_clicks must be an integer between 0 and 2147483647"},
+		{"GET", "/urls/top?limit=0", "", "limit must be an integer between 1 and 100"},
+		{"GET", "/urls/top?limit=1.5", "", "limit must be an integer between 1 and 100"},
+		{"GET", "/activity?limit=51", "", "limit must be an integer between 1 and 50"},
+		{"GET", "/analytics/geo?code=no%20spaces", "", "Invalid short code"},
+		{"GET", "/analytics/referrers?code=..%2Fetc", "", "Invalid short code"},
+		{"GET", "/analytics/trends?days=0", "", "days must be an integer between 1 and 365"},
+		{"GET", "/analytics/trends?days=366", "", "days must be an integer between 1 and 365"},
+		{"GET", "/analytics/trends?code=bad!code", "", "Invalid short code"},
+		{"GET", "/analytics/export?format=xml", "", "format must be json or csv"},
+		{"GET", "/analytics/export?range=0", "", "range must be an integer between 1 and 365"},
+		{"GET", "/analytics/export?format=csv&range=week", "", "range must be an integer between 1 and 365"},
+		{"POST", "/urls/bulk-delete", "", "*"},
+		{"POST", "/urls/bulk-delete", `{"codes": []}`, "*Codes*min*"},
+		{"POST", "/urls/bulk-delete", `{"codes": "abc123"}`, "*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path+" "+tt.body, func(t *testing.T) {
+			w := serve(r, tt.method, tt.path, tt.body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", w.Code, w.Body)
+			}
+			message := errorMessage(t, w)
+			if strings.HasPrefix(tt.want, "*") {
+				for _, part := range strings.Split(strings.Trim(tt.want, "*"), "*") {
+					if !strings.Contains(message, part) {
+						t.Errorf("error = %q, want it to mention %q", message, part)
+					}
+				}
+			} else if message != tt.want {
+				t.Errorf("error = %q, want %q", message, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminDatabaseErrors(t *testing.T) {
+	r := newAdminRouter(t, openTestDB(t, unreachableDSN), models.DefaultWorkspaceID)
+
+	tests := []struct {
+		method, path, want string
+	}{
+		{"GET", "/urls/analytics", "Failed to get analytics"},
+		{"GET", "/system/stats", "Failed to get system stats"},
+		{"GET", "/system/realtime", "Failed to get real-time stats"},
+		{"GET", "/urls/top", "Failed to get top URLs"},
+		{"GET", "/activity", "Failed to get recent activity"},
+		{"GET", "/api-keys/usage", "Failed to get API key usage"},
+		{"GET", "/analytics/geo", "Failed to get geo analytics"},
+		{"GET", "/analytics/referrers", "Failed to get referrer analytics"},
+		{"GET", "/analytics/trends?code=abc123", "Failed to get click trends"},
+		{"GET", "/analytics/export?format=csv", "Failed to export data"},
+		{"DELETE", "/urls/abc123", "Failed to delete URL"},
+		{"POST", "/urls/abc123/restore", "Failed to restore URL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := serve(r, tt.method, tt.path, "")
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want 500: %s", w.Code, w.Body)
+			}
+			if message := errorMessage(t, w); message != tt.want {
+				t.Errorf("error = %q, want %q", message, tt.want)
+			}
+		})
+	}
+}
+
+// seedAnalytics creates two links of the default workspace with clicks, one
+// of them from a bot, and a link of workspace 2
+func seedAnalytics(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	now := time.Now()
+	urls := []models.URL{
+		{Code: "abc123", OriginalURL: "https://example.com/a", URLHash: "a", ClickCount: 2, BotClickCount: 1, WorkspaceID: models.DefaultWorkspaceID},
+		{Code: "def456", OriginalURL: "https://example.com/b", URLHash: "b", ClickCount: 1, WorkspaceID: models.DefaultWorkspaceID},
+		{Code: "other1", OriginalURL: "https://example.com/c", URLHash: "c", ClickCount: 5, WorkspaceID: 2},
+	}
+	clicks := []models.Click{
+		{URLCode: "abc123", ClickID: "click1", WorkspaceID: models.DefaultWorkspaceID, IPAddress: "192.0.2.1", Platform: "ios", Country: "Germany", CountryCode: "DE", Referrer: "https://news.example/", ClickedAt: now.Add(-time.Minute)},
+		{URLCode: "abc123", ClickID: "click2", WorkspaceID: models.DefaultWorkspaceID, IPAddress: "192.0.2.2", Platform: "desktop", Country: "France", CountryCode: "FR", ClickedAt: now.Add(-2 * time.Minute)},
+		{URLCode: "abc123", ClickID: "click3", WorkspaceID: models.DefaultWorkspaceID, IPAddress: "192.0.2.3", Platform: "desktop", IsBot: true, BotName: "Googlebot", ClickedAt: now.Add(-3 * time.Minute)},
+		{URLCode: "def456", ClickID: "click4", WorkspaceID: models.DefaultWorkspaceID, IPAddress: "192.0.2.1", Platform: "android", Country: "Germany", CountryCode: "DE", ClickedAt: now.Add(-48 * time.Hour)},
+		{URLCode: "other1", ClickID: "click5", WorkspaceID: 2, IPAddress: "198.51.100.1", Platform: "ios", Country: "Japan", CountryCode: "JP", ClickedAt: now.Add(-time.Minute)},
+	}
+	if err := db.Create(&urls).Error; err != nil {
+		t.Fatalf("create urls: %v", err)
+	}
+	if err := db.Create(&clicks).Error; err != nil {
+		t.Fatalf("create clicks: %v", err)
+	}
+}
+
+func TestAdminAnalyticsRoutes(t *testing.T) {
+	db := testDB(t)
+	seedAnalytics(t, db)
+	r := newAdminRouter(t, db, models.DefaultWorkspaceID)
+
+	t.Run("URL analytics", func(t *testing.T) {
+		w := serve(r, "GET", "/urls/analytics?limit=1&page=2", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var body struct {
+			Data       []models.URLAnalyticsSummary `json:"data"`
+			Pagination struct {
+				Page, Limit, Total int64
+			} `json:"pagination"`
+		}
+		decode(t, w, &body)
+		if body.Pagination.Total != 2 || body.Pagination.Page != 2 || len(body.Data) != 1 {
+			t.Errorf("got %d of %d links on page %d, want 1 of 2 on page 2", len(body.Data), body.Pagination.Total, body.Pagination.Page)
+		}
+	})
+
+	t.Run("system stats", func(t *testing.T) {
+		w := serve(r, "GET", "/system/stats", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var stats models.SystemStats
+		decode(t, w, &stats)
+		if stats.TotalURLs != 2 || stats.TotalClicks != 3 {
+			t.Errorf("got %d URLs and %d clicks, want 2 and 3", stats.TotalURLs, stats.TotalClicks)
+		}
+	})
+
+	t.Run("top URLs", func(t *testing.T) {
+		w := serve(r, "GET", "/urls/top?limit=1", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var body struct {
+			TopURLs []models.URLAnalyticsSummary `json:"top_urls"`
+			BaseURL string                       `json:"base_url"`
+		}
+		decode(t, w, &body)
+		if len(body.TopURLs) != 1 || body.TopURLs[0].Code != "abc123" {
+			t.Errorf("top URLs = %+v, want abc123 alone", body.TopURLs)
+		}
+		if body.BaseURL != "http://localhost:8080" {
+			t.Errorf("base_url = %q", body.BaseURL)
+		}
+	})
+
+	t.Run("recent activity", func(t *testing.T) {
+		w := serve(r, "GET", "/activity?limit=2", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var activity models.RecentActivity
+		decode(t, w, &activity)
+		if len(activity.RecentURLs) != 2 || len(activity.RecentClicks) != 2 {
+			t.Errorf("got %d URLs and %d clicks, want 2 of each", len(activity.RecentURLs), len(activity.RecentClicks))
+		}
+		for _, click := range activity.RecentClicks {
+			if click.IsBot || click.WorkspaceID != models.DefaultWorkspaceID {
+				t.Errorf("recent clicks include %+v", click)
+			}
+		}
+	})
+
+	t.Run("performance", func(t *testing.T) {
+		if w := serve(r, "GET", "/system/performance", ""); w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("API key usage", func(t *testing.T) {
+		w := serve(r, "GET", "/api-keys/usage", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var body struct {
+			TotalKeys int `json:"total_keys"`
+		}
+		decode(t, w, &body)
+		if body.TotalKeys != 0 {
+			t.Errorf("total_keys = %d, want 0", body.TotalKeys)
+		}
+	})
+
+	t.Run("geo", func(t *testing.T) {
+		w := serve(r, "GET", "/analytics/geo", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var body struct {
+			TotalClicks int64 `json:"total_clicks"`
+			Countries   int   `json:"countries"`
+		}
+		decode(t, w, &body)
+		if body.TotalClicks != 3 || body.Countries != 2 {
+			t.Errorf("got %d clicks from %d countries, want 3 from 2", body.TotalClicks, body.Countries)
+		}
+	})
+
+	t.Run("referrers", func(t *testing.T) {
+		if w := serve(r, "GET", "/analytics/referrers?code=abc123", ""); w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("trends", func(t *testing.T) {
+		w := serve(r, "GET", "/analytics/trends?code=abc123&days=3", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var body struct {
+			Days int    `json:"days"`
+			Code string `json:"code"`
+		}
+		decode(t, w, &body)
+		if body.Days != 3 || body.Code != "abc123" {
+			t.Errorf("days = %d, code = %q", body.Days, body.Code)
+		}
+	})
+
+	t.Run("export JSON", func(t *testing.T) {
+		w := serve(r, "GET", "/analytics/export?range=1", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var export models.ExportData
+		decode(t, w, &export)
+		if len(export.Clicks) != 2 {
+			t.Errorf("exported %d clicks of the last day, want 2", len(export.Clicks))
+		}
+	})
+
+	t.Run("export CSV", func(t *testing.T) {
+		w := serve(r, "GET", "/analytics/export?format=csv", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		if lines := strings.Count(strings.TrimSpace(w.Body.String()), "\n"); lines != 3 {
+			t.Errorf("CSV has %d rows after the header, want 3: %s", lines, w.Body)
+		}
+	})
+
+	t.Run("realtime", func(t *testing.T) {
+		w := serve(r, "GET", "/system/realtime", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var stats models.RealTimeStats
+		decode(t, w, &stats)
+		if stats.ClicksLastHour != 2 || stats.ActiveUsers != 2 {
+			t.Errorf("got %d clicks by %d users, want 2 by 2", stats.ClicksLastHour, stats.ActiveUsers)
+		}
+	})
+}
+
+func TestAdminDeleteAndRestore(t *testing.T) {
+	db := testDB(t)
+	seedAnalytics(t, db)
+	r := newAdminRouter(t, db, models.DefaultWorkspaceID)
+
+	if w := serve(r, "DELETE", "/urls/abc123", ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d: %s", w.Code, w.Body)
+	}
+	var url models.URL
+	if err := db.Unscoped().Where("code = ?", "abc123").First(&url).Error; err != nil || !url.DeletedAt.Valid {
+		t.Fatalf("abc123 wasn't soft deleted: %v", err)
+	}
+
+	if w := serve(r, "POST", "/urls/abc123/restore", ""); w.Code != http.StatusOK {
+		t.Fatalf("restore: status = %d: %s", w.Code, w.Body)
+	}
+	if err := db.Where("code = ?", "abc123").First(&url).Error; err != nil {
+		t.Fatalf("abc123 wasn't restored: %v", err)
+	}
+
+	var audits []models.AuditLog
+	db.Order("id").Find(&audits)
+	if len(audits) != 2 || audits[0].Action != models.AuditURLDelete || audits[1].Action != models.AuditURLRestore {
+		t.Errorf("audit log = %+v, want a delete then a restore", audits)
+	}
+
+	// Links of other workspaces and missing links are not found
+	for _, tt := range []struct{ method, path string }{
+		{"DELETE", "/urls/other1"},
+		{"POST", "/urls/other1/restore"},
+		{"DELETE", "/urls/missing"},
+		{"POST", "/urls/missing/restore"},
+	} {
+		w := serve(r, tt.method, tt.path, "")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: status = %d, want 404: %s", tt.method, tt.path, w.Code, w.Body)
+			continue
+		}
+		if message := errorMessage(t, w); message != "URL not found" {
+			t.Errorf("%s %s: error = %q", tt.method, tt.path, message)
+		}
+	}
+}
+
+func TestAdminBulkDelete(t *testing.T) {
+	db := testDB(t)
+	seedAnalytics(t, db)
+	r := newAdminRouter(t, db, models.DefaultWorkspaceID)
+
+	w := serve(r, "POST", "/urls/bulk-delete", `{"codes": ["abc123", "other1", "missing"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Total        int `json:"total"`
+		SuccessCount int `json:"success_count"`
+		FailedCount  int `json:"failed_count"`
+		Results      []struct {
+			Code    string `json:"code"`
+			Success bool   `json:"success"`
+		} `json:"results"`
+	}
+	decode(t, w, &body)
+	if body.Total != 3 || body.SuccessCount != 1 || body.FailedCount != 2 {
+		t.Errorf("got %d of %d deleted and %d failed, want 1 of 3 and 2", body.SuccessCount, body.Total, body.FailedCount)
+	}
+	for _, result := range body.Results {
+		if result.Success != (result.Code == "abc123") {
+			t.Errorf("result of %s: success = %v", result.Code, result.Success)
+		}
+	}
+
+	var live int64
+	db.Model(&models.URL{}).Where("code IN ?", []string{"abc123", "other1"}).Count(&live)
+	if live != 1 {
+		t.Errorf("%d of abc123 and other1 are live, want other1 alone", live)
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"url-shortener/middleware"
+	"url-shortener/models"
+	"url-shortener/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminUserHandler struct {
+	userService  *services.AdminUserService
+	auditService *services.AuditService
+}
+
+func NewAdminUserHandler(userService *services.AdminUserService, auditService *services.AuditService) *AdminUserHandler {
+	return &AdminUserHandler{userService: userService, auditService: auditService}
+}
+
+// users returns the user service limited to the signed-in owner's workspace
+func (h *AdminUserHandler) users(c *gin.Context) *services.AdminUserService {
+	return h.userService.ForWorkspace(middleware.ContextWorkspaceID(c))
+}
+
+func (h *AdminUserHandler) GetAdminUsers(c *gin.Context) {
+	users, err := h.users(c).GetUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get admin users"})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func (h *AdminUserHandler) CreateAdminUser(c *gin.Context) {
+	var req models.AdminUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users(c).CreateUser(req)
+	if errors.Is(err, services.ErrUsernameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create admin user"})
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditAdminUserCreate,
+		ResourceType: "admin_user",
+		ResourceID:   user.Username,
+		After:        services.AuditSnapshot(user),
+	})
+
+	c.JSON(http.StatusCreated, user)
+}
+
+// UpdateAdminUser changes a user's role, status or password
+func (h *AdminUserHandler) UpdateAdminUser(c *gin.Context) {
+	id, ok := adminUserID(c)
+	if !ok {
+		return
+	}
+
+	var req models.AdminUserUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	users := h.users(c)
+	before, err := users.GetUser(id)
+	var user *models.AdminUser
+	if err == nil {
+		user, err = users.UpdateUser(id, req)
+	}
+	if err != nil {
+		respondAdminUserError(c, err, "Failed to update admin user")
+		return
+	}
+
+	changedBefore, changedAfter := services.AuditDiff(before, user)
+	if req.Password != nil {
+		changedAfter["password_reset"] = true
+	}
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditAdminUserUpdate,
+		ResourceType: "admin_user",
+		ResourceID:   user.Username,
+		Before:       changedBefore,
+		After:        changedAfter,
+	})
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *AdminUserHandler) DeleteAdminUser(c *gin.Context) {
+	id, ok := adminUserID(c)
+	if !ok {
+		return
+	}
+
+	users := h.users(c)
+	user, err := users.GetUser(id)
+	if err == nil {
+		err = users.DeleteUser(id)
+	}
+	if err != nil {
+		respondAdminUserError(c, err, "Failed to delete admin user")
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditAdminUserDelete,
+		ResourceType: "admin_user",
+		ResourceID:   user.Username,
+		Before:       services.AuditSnapshot(user),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Admin user deleted"})
+}
+
+func adminUserID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin user ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondAdminUserError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrAdminUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrLastOwner):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
+	"url-shortener/middleware"
 	"url-shortener/services"
 
 	"github.com/gin-gonic/gin"
@@ -21,8 +23,12 @@ func NewAnalyticsHandler(db *gorm.DB) *AnalyticsHandler {
 
 func (h *AnalyticsHandler) GetAnalytics(c *gin.Context) {
 	code := c.Param("code")
+	analyticsService, ok := h.authorize(c, code)
+	if !ok {
+		return
+	}
 
-	analytics, err := h.analyticsService.GetAnalytics(code)
+	analytics, err := analyticsService.GetAnalytics(code)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Analytics not found"})
 		return
@@ -33,8 +39,12 @@ func (h *AnalyticsHandler) GetAnalytics(c *gin.Context) {
 
 func (h *AnalyticsHandler) GetDetailedAnalytics(c *gin.Context) {
 	code := c.Param("code")
+	analyticsService, ok := h.authorize(c, code)
+	if !ok {
+		return
+	}
 
-	analytics, err := h.analyticsService.GetAnalytics(code)
+	analytics, err := analyticsService.GetAnalytics(code)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Analytics not found"})
 		return
@@ -42,3 +52,28 @@ func (h *AnalyticsHandler) GetDetailedAnalytics(c *gin.Context) {
 
 	c.JSON(http.StatusOK, analytics)
 }
+
+// authorize allows analytics of anonymous links to anyone, and of links created
+// with an API key only to that key or an admin of the link's workspace. Codes
+// are looked up in the caller's workspace (the default one for anonymous
+// callers), since the same code can exist in several. It returns the analytics
+// service scoped to that workspace and counting bots if asked to, or responds
+// on failure.
+func (h *AnalyticsHandler) authorize(c *gin.Context, code string) (*services.AnalyticsService, bool) {
+	owner, workspaceID, err := h.analyticsService.ForWorkspace(middleware.ContextWorkspaceID(c)).GetURLOwner(code)
+	if errors.Is(err, services.ErrURLNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Analytics not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get analytics"})
+		return nil, false
+	}
+
+	isWorkspaceAdmin := c.GetBool("is_admin") && middleware.ContextWorkspaceID(c) == workspaceID
+	if owner != "" && owner != c.GetString("api_key_id") && !isWorkspaceAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrURLNotOwned.Error()})
+		return nil, false
+	}
+	return h.analyticsService.ForWorkspace(workspaceID).WithBots(includeBots(c)), true
+}
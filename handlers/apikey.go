@@ -1,25 +1,34 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
+	"url-shortener/middleware"
 	"url-shortener/models"
 	"url-shortener/services"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 type APIKeyHandler struct {
 	apiKeyService *services.APIKeyService
+	auditService  *services.AuditService
 }
 
-func NewAPIKeyHandler(db *gorm.DB) *APIKeyHandler {
+func NewAPIKeyHandler(apiKeyService *services.APIKeyService, auditService *services.AuditService) *APIKeyHandler {
 	return &APIKeyHandler{
-		apiKeyService: services.NewAPIKeyService(db),
+		apiKeyService: apiKeyService,
+		auditService:  auditService,
 	}
 }
 
+// keys returns the API key service limited to the signed-in admin's workspace
+func (h *APIKeyHandler) keys(c *gin.Context) *services.APIKeyService {
+	return h.apiKeyService.ForWorkspace(middleware.ContextWorkspaceID(c))
+}
+
 func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
 	var req models.APIKeyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -27,45 +36,150 @@ func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
 		return
 	}
 
-	apiKey, err := h.apiKeyService.CreateAPIKey(req)
+	apiKey, err := h.keys(c).CreateAPIKey(req)
+	if errors.Is(err, services.ErrAPIKeyExpiryInPast) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
 		return
 	}
 
+	// Never let the one-time secret reach the audit log
+	created := *apiKey
+	created.KeySecret = ""
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditAPIKeyCreate,
+		ResourceType: "api_key",
+		ResourceID:   apiKey.KeyID,
+		After:        services.AuditSnapshot(created),
+	})
+
 	c.JSON(http.StatusCreated, apiKey)
 }
 
 func (h *APIKeyHandler) GetAPIKeys(c *gin.Context) {
-	apiKeys, err := h.apiKeyService.GetAPIKeys()
+	apiKeys, err := h.keys(c).GetAPIKeys()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get API keys"})
 		return
 	}
 
 	// Remove sensitive information
-	var response []models.APIKeyResponse
+	response := make([]models.APIKeyResponse, 0, len(apiKeys))
 	for _, key := range apiKeys {
-		response = append(response, models.APIKeyResponse{
-			KeyID:       key.KeyID,
-			Name:        key.Name,
-			Description: key.Description,
-			IsActive:    key.IsActive,
-			CreatedAt:   key.CreatedAt,
-		})
+		response = append(response, services.ToAPIKeyResponse(key))
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// UpdateAPIKey changes a key's scopes, allowed domains, expiry, rate limit and creation quotas
+func (h *APIKeyHandler) UpdateAPIKey(c *gin.Context) {
+	var req models.APIKeyUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	keys := h.keys(c)
+	before, err := keys.GetAPIKey(c.Param("keyId"))
+	var apiKey *models.APIKey
+	if err == nil {
+		apiKey, err = keys.UpdateAPIKey(c.Param("keyId"), req)
+	}
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if errors.Is(err, services.ErrAPIKeyExpiryInPast) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update API key"})
+		return
+	}
+
+	changedBefore, changedAfter := services.AuditDiff(services.ToAPIKeyResponse(*before), services.ToAPIKeyResponse(*apiKey))
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditAPIKeyUpdate,
+		ResourceType: "api_key",
+		ResourceID:   apiKey.KeyID,
+		Before:       changedBefore,
+		After:        changedAfter,
+	})
+
+	c.JSON(http.StatusOK, services.ToAPIKeyResponse(*apiKey))
+}
+
+// RotateAPIKey issues a new secret; the old one stays valid for the grace period
+func (h *APIKeyHandler) RotateAPIKey(c *gin.Context) {
+	var req models.RotateAPIKeyRequest
+	// The body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var grace *time.Duration
+	if req.GracePeriod != nil {
+		parsed, err := time.ParseDuration(*req.GracePeriod)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidGracePeriod.Error()})
+			return
+		}
+		grace = &parsed
+	}
+
+	apiKey, err := h.keys(c).RotateAPIKey(c.Param("keyId"), grace)
+	switch {
+	case errors.Is(err, services.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+	case errors.Is(err, services.ErrInvalidGracePeriod):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate API key"})
+	default:
+		recordAudit(c, h.auditService, models.AuditLog{
+			Action:       models.AuditAPIKeyRotate,
+			ResourceType: "api_key",
+			ResourceID:   apiKey.KeyID,
+			After:        map[string]interface{}{"previous_secret_expires_at": apiKey.PreviousSecretExpiresAt},
+		})
+		c.JSON(http.StatusOK, apiKey)
+	}
+}
+
 func (h *APIKeyHandler) DeactivateAPIKey(c *gin.Context) {
 	keyID := c.Param("keyId")
 
-	err := h.apiKeyService.DeactivateAPIKey(keyID)
+	keys := h.keys(c)
+	apiKey, err := keys.GetAPIKey(keyID)
+	if err == nil {
+		err = keys.DeactivateAPIKey(keyID)
+	}
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate API key"})
 		return
 	}
 
+	if apiKey.IsActive {
+		recordAudit(c, h.auditService, models.AuditLog{
+			Action:       models.AuditAPIKeyDeactivate,
+			ResourceType: "api_key",
+			ResourceID:   keyID,
+			Before:       map[string]interface{}{"is_active": true},
+			After:        map[string]interface{}{"is_active": false},
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "API key deactivated successfully"})
 }
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"url-shortener/middleware"
+	"url-shortener/models"
+	"url-shortener/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	auditService *services.AuditService
+}
+
+func NewAuditHandler(auditService *services.AuditService) *AuditHandler {
+	return &AuditHandler{auditService: auditService}
+}
+
+// GetAuditLogs lists the audit entries of the caller's workspace, newest first,
+// filtered by action, actor, resource and time range
+func (h *AuditHandler) GetAuditLogs(c *gin.Context) {
+	page, ok := queryInt(c, "page", 1, 1, math.MaxInt32)
+	if !ok {
+		return
+	}
+	limit, ok := queryInt(c, "limit", 50, 1, 200)
+	if !ok {
+		return
+	}
+
+	filter := models.AuditFilter{
+		Action:       c.Query("action"),
+		ActorType:    c.Query("actor_type"),
+		ActorID:      c.Query("actor_id"),
+		ResourceType: c.Query("resource_type"),
+		ResourceID:   c.Query("resource_id"),
+	}
+	for name, target := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if raw := c.Query(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be an RFC 3339 timestamp"})
+				return
+			}
+			*target = &t
+		}
+	}
+
+	entries, total, err := h.auditService.ForWorkspace(middleware.ContextWorkspaceID(c)).GetAuditLogs(filter, (page-1)*limit, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.AuditLogResponse{
+		Entries:    entries,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+	})
+}
+
+// recordAudit stamps entries with the request's actor, workspace and IP and
+// appends them to the audit log. Entries that already name an actor or
+// workspace keep it. The change has been applied by the time this runs, so
+// failures are logged, not returned.
+func recordAudit(c *gin.Context, auditService *services.AuditService, entries ...models.AuditLog) {
+	actorType, actorID := models.ActorAnonymous, ""
+	if user := middleware.ContextAdminUser(c); user != nil {
+		actorType, actorID = models.ActorAdmin, user.Username
+	} else if keyID := c.GetString("api_key_id"); keyID != "" {
+		actorType, actorID = models.ActorAPIKey, keyID
+	}
+
+	for i := range entries {
+		if entries[i].ActorType == "" {
+			entries[i].ActorType, entries[i].ActorID = actorType, actorID
+		}
+		if entries[i].WorkspaceID == 0 {
+			entries[i].WorkspaceID = middleware.ContextWorkspaceID(c)
+		}
+		entries[i].IPAddress = c.ClientIP()
+	}
+
+	if err := auditService.Record(entries...); err != nil {
+		for _, entry := range entries {
+			log.Printf("Failed to record audit entry %s %s/%s by %s %s: %v",
+				entry.Action, entry.ResourceType, entry.ResourceID, entry.ActorType, entry.ActorID, err)
+		}
+	}
+}
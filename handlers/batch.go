@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"url-shortener/models"
+	"url-shortener/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Batch shorten limits
+const (
+	maxBatchItems     = 5000
+	batchChunkSize    = 100      // Items written per transaction
+	maxBatchBodyBytes = 10 << 20 // 10 MB
+)
+
+// csvColumns maps CSV header names to ShortenRequest fields; they match the JSON
+// names, with the utm and preview objects flattened into utm_source,
+// preview_title and so on
+var csvColumns = map[string]func(req *models.ShortenRequest, value string) error{
+	"url":                  func(req *models.ShortenRequest, v string) error { req.URL = v; return nil },
+	"ios_redirect_url":     func(req *models.ShortenRequest, v string) error { req.IOSRedirectURL = v; return nil },
+	"android_redirect_url": func(req *models.ShortenRequest, v string) error { req.AndroidRedirectURL = v; return nil },
+	"desktop_redirect_url": func(req *models.ShortenRequest, v string) error { req.DesktopRedirectURL = v; return nil },
+	"mac_redirect_url":     func(req *models.ShortenRequest, v string) error { req.MacRedirectURL = v; return nil },
+	"alias":                func(req *models.ShortenRequest, v string) error { req.Alias = v; return nil },
+	"expired_redirect_url": func(req *models.ShortenRequest, v string) error { req.ExpiredRedirectURL = v; return nil },
+	"password":             func(req *models.ShortenRequest, v string) error { req.Password = v; return nil },
+	"expires_at": func(req *models.ShortenRequest, v string) error {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return errors.New("expires_at must be an RFC 3339 timestamp")
+		}
+		req.ExpiresAt = &t
+		return nil
+	},
+	"max_clicks": func(req *models.ShortenRequest, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errors.New("max_clicks must be an integer")
+		}
+		req.MaxClicks = n
+		return nil
+	},
+	"utm_source":   func(req *models.ShortenRequest, v string) error { req.UTM.Source = v; return nil },
+	"utm_medium":   func(req *models.ShortenRequest, v string) error { req.UTM.Medium = v; return nil },
+	"utm_campaign": func(req *models.ShortenRequest, v string) error { req.UTM.Campaign = v; return nil },
+	"utm_term":     func(req *models.ShortenRequest, v string) error { req.UTM.Term = v; return nil },
+	"utm_content":  func(req *models.ShortenRequest, v string) error { req.UTM.Content = v; return nil },
+	"campaign_id": func(req *models.ShortenRequest, v string) error {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return errors.New("campaign_id must be a positive integer")
+		}
+		req.CampaignID = uint(n)
+		return nil
+	},
+	"preview_title":       func(req *models.ShortenRequest, v string) error { req.Preview.Title = v; return nil },
+	"preview_description": func(req *models.ShortenRequest, v string) error { req.Preview.Description = v; return nil },
+	"preview_image":       func(req *models.ShortenRequest, v string) error { req.Preview.Image = v; return nil },
+}
+
+// batchItem is one parsed request, or the reason it couldn't be parsed
+type batchItem struct {
+	req models.ShortenRequest
+	err error
+}
+
+// ShortenBatch creates many short URLs from a JSON array of shorten requests or a
+// CSV file (multipart field "file", or a text/csv body). Results are returned in
+// input order; a bad item is reported in its result without failing the batch.
+func (h *URLHandler) ShortenBatch(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchBodyBytes)
+
+	items, err := parseBatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Batch is empty"})
+		return
+	}
+	if len(items) > maxBatchItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Batch exceeds %d items", maxBatchItems)})
+		return
+	}
+
+	// Validate each item on its own so one bad row doesn't reject the batch
+	apiKey := contextAPIKey(c)
+	var reqs []models.ShortenRequest
+	var positions []int
+	for i := range items {
+		if items[i].err == nil {
+			items[i].err = binding.Validator.ValidateStruct(&items[i].req)
+		}
+		if items[i].err == nil {
+			items[i].err = services.CheckDestinations(apiKey, shortenTargets(items[i].req)...)
+		}
+		if items[i].err == nil {
+			reqs = append(reqs, items[i].req)
+			positions = append(positions, i)
+		}
+	}
+
+	// Set by the CreationQuota middleware for keys with a quota
+	if _, limited := c.Get("quota_remaining"); limited {
+		if remaining := c.GetInt64("quota_remaining"); int64(len(reqs)) > remaining {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": fmt.Sprintf("Batch of %d URLs exceeds the remaining creation quota of %d", len(reqs), remaining),
+			})
+			return
+		}
+	}
+
+	created := h.urls(c).CreateShortURLs(reqs, c.GetString("api_key_id"), batchChunkSize)
+
+	var audits []models.AuditLog
+	for _, result := range created {
+		if result.Err == nil && result.IsNew {
+			audits = append(audits, models.AuditLog{
+				Action:       models.AuditURLCreate,
+				ResourceType: "url",
+				ResourceID:   result.URL.Code,
+				After:        services.AuditSnapshot(result.URL),
+			})
+		}
+	}
+	recordAudit(c, h.auditService, audits...)
+	for _, result := range created {
+		if result.Err == nil && result.IsNew {
+			h.webhooks.LinkEvent(models.EventLinkCreated, result.URL)
+		}
+	}
+
+	response := models.BatchShortenResponse{
+		Results: make([]models.BatchShortenResult, len(items)),
+		Total:   len(items),
+	}
+	for i, item := range items {
+		response.Results[i] = models.BatchShortenResult{Index: i}
+		if item.err != nil {
+			response.Results[i].Error = item.err.Error()
+		}
+	}
+	for j, result := range created {
+		i := positions[j]
+		if result.Err != nil {
+			_, message := shortenError(result.Err)
+			response.Results[i].Error = message
+			continue
+		}
+		response.Results[i] = models.BatchShortenResult{
+			Index:       i,
+			Code:        result.URL.Code,
+			ShortURL:    h.domainService.ShortURL(result.URL.DomainID, result.URL.Code),
+			OriginalURL: result.URL.OriginalURL,
+			IsNew:       result.IsNew,
+		}
+	}
+
+	for _, result := range response.Results {
+		switch {
+		case result.Error != "":
+			response.Failed++
+		case result.IsNew:
+			response.Created++
+		default:
+			response.Existing++
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// parseBatch reads the batch from the request body according to its content type
+func parseBatch(c *gin.Context) ([]batchItem, error) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+
+	switch mediaType {
+	case "multipart/form-data":
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return nil, errors.New("file is required")
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return parseBatchCSV(file)
+	case "text/csv":
+		return parseBatchCSV(c.Request.Body)
+	default:
+		return parseBatchJSON(c.Request.Body)
+	}
+}
+
+func parseBatchJSON(r io.Reader) ([]batchItem, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, errors.New("body must be a JSON array of shorten requests")
+	}
+
+	items := make([]batchItem, len(raw))
+	for i, data := range raw {
+		if err := json.Unmarshal(data, &items[i].req); err != nil {
+			items[i].err = fmt.Errorf("invalid item: %v", err)
+		}
+	}
+	return items, nil
+}
+
+// parseBatchCSV reads a CSV with a header row naming ShortenRequest fields
+func parseBatchCSV(r io.Reader) ([]batchItem, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("CSV must start with a header row")
+	}
+
+	setters := make([]func(*models.ShortenRequest, string) error, len(header))
+	hasURL := false
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		setter, ok := csvColumns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		setters[i] = setter
+		hasURL = hasURL || name == "url"
+	}
+	if !hasURL {
+		return nil, errors.New("CSV must have a url column")
+	}
+
+	var items []batchItem
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		if len(items) >= maxBatchItems {
+			return nil, fmt.Errorf("Batch exceeds %d items", maxBatchItems)
+		}
+
+		var item batchItem
+		if len(record) != len(header) {
+			item.err = fmt.Errorf("expected %d fields, got %d", len(header), len(record))
+		}
+		for i, value := range record {
+			if item.err != nil || i >= len(setters) {
+				break
+			}
+			if value = strings.TrimSpace(value); value != "" {
+				item.err = setters[i](&item.req, value)
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"url-shortener/middleware"
+	"url-shortener/models"
+	"url-shortener/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CampaignHandler struct {
+	campaignService  *services.CampaignService
+	analyticsService *services.AnalyticsService
+	domainService    *services.DomainService
+	auditService     *services.AuditService
+}
+
+func NewCampaignHandler(db *gorm.DB, domainService *services.DomainService, auditService *services.AuditService) *CampaignHandler {
+	return &CampaignHandler{
+		campaignService:  services.NewCampaignService(db),
+		analyticsService: services.NewAnalyticsService(db),
+		domainService:    domainService,
+		auditService:     auditService,
+	}
+}
+
+// campaigns returns the campaign service limited to the caller's workspace
+func (h *CampaignHandler) campaigns(c *gin.Context) *services.CampaignService {
+	return h.campaignService.ForWorkspace(middleware.ContextWorkspaceID(c))
+}
+
+func (h *CampaignHandler) GetCampaigns(c *gin.Context) {
+	campaigns, err := h.campaigns(c).GetCampaigns()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get campaigns"})
+		return
+	}
+	c.JSON(http.StatusOK, campaigns)
+}
+
+func (h *CampaignHandler) CreateCampaign(c *gin.Context) {
+	var req models.CampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	campaign, err := h.campaigns(c).CreateCampaign(req)
+	if err != nil {
+		respondCampaignError(c, err, "Failed to create campaign")
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditCampaignCreate,
+		ResourceType: "campaign",
+		ResourceID:   strconv.FormatUint(uint64(campaign.ID), 10),
+		After:        services.AuditSnapshot(campaign),
+	})
+
+	c.JSON(http.StatusCreated, campaign)
+}
+
+func (h *CampaignHandler) UpdateCampaign(c *gin.Context) {
+	id, ok := campaignID(c)
+	if !ok {
+		return
+	}
+
+	var req models.CampaignUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	campaigns := h.campaigns(c)
+	before, err := campaigns.GetCampaign(id)
+	var campaign *models.Campaign
+	if err == nil {
+		campaign, err = campaigns.UpdateCampaign(id, req)
+	}
+	if err != nil {
+		respondCampaignError(c, err, "Failed to update campaign")
+		return
+	}
+
+	changedBefore, changedAfter := services.AuditDiff(before, campaign)
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditCampaignUpdate,
+		ResourceType: "campaign",
+		ResourceID:   strconv.FormatUint(uint64(id), 10),
+		Before:       changedBefore,
+		After:        changedAfter,
+	})
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// DeleteCampaign removes a campaign that no longer has any links
+func (h *CampaignHandler) DeleteCampaign(c *gin.Context) {
+	id, ok := campaignID(c)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.campaigns(c).DeleteCampaign(id)
+	if err != nil {
+		respondCampaignError(c, err, "Failed to delete campaign")
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditCampaignDelete,
+		ResourceType: "campaign",
+		ResourceID:   strconv.FormatUint(uint64(id), 10),
+		Before:       services.AuditSnapshot(campaign),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Campaign deleted"})
+}
+
+// GetCampaignAnalytics reports the combined clicks of a campaign's links
+func (h *CampaignHandler) GetCampaignAnalytics(c *gin.Context) {
+	id, ok := campaignID(c)
+	if !ok {
+		return
+	}
+	days, ok := queryInt(c, "days", 30, 1, 365)
+	if !ok {
+		return
+	}
+
+	workspaceID := middleware.ContextWorkspaceID(c)
+	analytics, err := h.analyticsService.ForWorkspace(workspaceID).WithBots(includeBots(c)).GetCampaignAnalytics(id, days)
+	if err != nil {
+		respondCampaignError(c, err, "Failed to get campaign analytics")
+		return
+	}
+
+	for i := range analytics.TopLinks {
+		link := &analytics.TopLinks[i]
+		link.ShortURL = h.domainService.ShortURL(link.DomainID, link.Code)
+	}
+
+	c.JSON(http.StatusOK, analytics)
+}
+
+func campaignID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid campaign ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondCampaignError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrCampaignNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCampaignTaken),
+		errors.Is(err, services.ErrCampaignInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
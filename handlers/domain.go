@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"url-shortener/middleware"
+	"url-shortener/models"
+	"url-shortener/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DomainHandler struct {
+	domainService *services.DomainService
+	auditService  *services.AuditService
+}
+
+func NewDomainHandler(domainService *services.DomainService, auditService *services.AuditService) *DomainHandler {
+	return &DomainHandler{domainService: domainService, auditService: auditService}
+}
+
+// domains returns the domain service limited to the signed-in admin's workspace
+func (h *DomainHandler) domains(c *gin.Context) *services.DomainService {
+	return h.domainService.ForWorkspace(middleware.ContextWorkspaceID(c))
+}
+
+func (h *DomainHandler) GetDomains(c *gin.Context) {
+	domains, err := h.domains(c).GetDomains()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get domains"})
+		return
+	}
+
+	response := make([]models.DomainResponse, len(domains))
+	for i := range domains {
+		response[i] = toDomainResponse(&domains[i])
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateDomain adds a domain to the workspace. Links can use it once the
+// returned TXT record is published and the domain verified.
+func (h *DomainHandler) CreateDomain(c *gin.Context) {
+	var req models.DomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	domain, err := h.domains(c).CreateDomain(req.Host)
+	if err != nil {
+		respondDomainError(c, err, "Failed to add domain")
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditDomainCreate,
+		ResourceType: "domain",
+		ResourceID:   domain.Host,
+		After:        services.AuditSnapshot(domain),
+	})
+
+	c.JSON(http.StatusCreated, toDomainResponse(domain))
+}
+
+// VerifyDomain checks the domain's TXT record and marks it verified
+func (h *DomainHandler) VerifyDomain(c *gin.Context) {
+	id, ok := domainID(c)
+	if !ok {
+		return
+	}
+
+	domains := h.domains(c)
+	before, err := domains.GetDomain(id)
+	var domain *models.Domain
+	if err == nil {
+		domain, err = domains.VerifyDomain(id)
+	}
+	if errors.Is(err, services.ErrDomainVerificationFailed) {
+		// Repeat the expected record so the caller can see what is missing
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":               err.Error(),
+			"verification_record": toDomainResponse(before).VerificationRecord,
+		})
+		return
+	}
+	if err != nil {
+		respondDomainError(c, err, "Failed to verify domain")
+		return
+	}
+
+	if before.VerifiedAt == nil {
+		recordAudit(c, h.auditService, models.AuditLog{
+			Action:       models.AuditDomainVerify,
+			ResourceType: "domain",
+			ResourceID:   domain.Host,
+		})
+	}
+
+	c.JSON(http.StatusOK, toDomainResponse(domain))
+}
+
+// DeleteDomain removes a domain no link is served from
+func (h *DomainHandler) DeleteDomain(c *gin.Context) {
+	id, ok := domainID(c)
+	if !ok {
+		return
+	}
+
+	domains := h.domains(c)
+	domain, err := domains.GetDomain(id)
+	if err == nil {
+		err = domains.DeleteDomain(id)
+	}
+	if err != nil {
+		respondDomainError(c, err, "Failed to delete domain")
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditDomainDelete,
+		ResourceType: "domain",
+		ResourceID:   domain.Host,
+		Before:       services.AuditSnapshot(domain),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Domain deleted"})
+}
+
+func toDomainResponse(domain *models.Domain) models.DomainResponse {
+	return models.DomainResponse{
+		Domain: *domain,
+		VerificationRecord: models.DNSRecord{
+			Type:  "TXT",
+			Name:  services.VerificationRecordPrefix + domain.Host,
+			Value: domain.VerificationToken,
+		},
+	}
+}
+
+func domainID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondDomainError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrDomainNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDomainTaken),
+		errors.Is(err, services.ErrDomainInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
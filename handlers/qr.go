@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"url-shortener/middleware"
+	"url-shortener/models"
+	"url-shortener/services"
+	"url-shortener/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type QRHandler struct {
+	urlService    *services.URLService
+	qrService     *services.QRService
+	auditService  *services.AuditService
+	domainService *services.DomainService
+}
+
+func NewQRHandler(db *gorm.DB, urlCache services.URLCache, domainService *services.DomainService) *QRHandler {
+	return &QRHandler{
+		urlService:    services.NewURLService(db, urlCache),
+		qrService:     services.NewQRService(db),
+		auditService:  services.NewAuditService(db),
+		domainService: domainService,
+	}
+}
+
+// logos returns the QR service limited to the signed-in admin's workspace
+func (h *QRHandler) logos(c *gin.Context) *services.QRService {
+	return h.qrService.ForWorkspace(middleware.ContextWorkspaceID(c))
+}
+
+// GetQRCode renders the short URL for a code as a PNG or SVG QR code. Like
+// redirects, the code is looked up on the domain the request was made to.
+func (h *QRHandler) GetQRCode(c *gin.Context) {
+	code := c.Param("code")
+
+	url, err := h.urlService.GetURLByCode(h.domainService.ResolveHost(c.Request.Host), code)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "png")
+	if format != "png" && format != "svg" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be png or svg"})
+		return
+	}
+
+	// Logos come from the link's own workspace
+	opts, err := h.parseOptions(c, h.qrService.ForWorkspace(url.WorkspaceID))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = http.StatusNotFound
+			err = errors.New("logo not found")
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Scans go through the regular redirect so they show up in click analytics
+	content := h.domainService.ShortURL(url.DomainID, url.Code)
+
+	var data []byte
+	var contentType string
+	if format == "svg" {
+		data, err = utils.RenderQRSVG(content, opts)
+		contentType = "image/svg+xml"
+	} else {
+		data, err = utils.RenderQRPNG(content, opts)
+		contentType = "image/png"
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Header("Content-Disposition", "inline; filename=\""+url.Code+"."+format+"\"")
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func (h *QRHandler) parseOptions(c *gin.Context, qrService *services.QRService) (utils.QROptions, error) {
+	opts := utils.DefaultQROptions()
+
+	if s := c.Query("size"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < utils.MinQRSize || size > utils.MaxQRSize {
+			return opts, errors.New("size must be between " + strconv.Itoa(utils.MinQRSize) + " and " + strconv.Itoa(utils.MaxQRSize))
+		}
+		opts.Size = size
+	}
+
+	if q := c.Query("margin"); q != "" {
+		margin, err := strconv.Atoi(q)
+		if err != nil || margin < 0 || margin > utils.MaxQuietZone {
+			return opts, errors.New("margin must be between 0 and " + strconv.Itoa(utils.MaxQuietZone))
+		}
+		opts.QuietZone = margin
+	}
+
+	level, err := utils.ParseQRLevel(c.Query("level"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Level = level
+
+	if fg := c.Query("fg"); fg != "" {
+		if opts.Foreground, err = utils.ParseHexColor(fg); err != nil {
+			return opts, err
+		}
+	}
+
+	if bg := c.Query("bg"); bg != "" {
+		if opts.Background, err = utils.ParseHexColor(bg); err != nil {
+			return opts, err
+		}
+	}
+
+	if name := c.Query("logo"); name != "" {
+		logo, img, err := qrService.GetLogoImage(name)
+		if err != nil {
+			return opts, err
+		}
+		opts.Logo = img
+		opts.LogoBytes = logo.Data
+		opts.LogoType = logo.ContentType
+	}
+
+	return opts, nil
+}
+
+// UploadLogo stores a centre logo from a multipart "logo" file field
+func (h *QRHandler) UploadLogo(c *gin.Context) {
+	name := c.PostForm("name")
+
+	file, err := c.FormFile("logo")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "logo file is required"})
+		return
+	}
+	if file.Size > services.MaxQRLogoBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "logo must be at most 512KB"})
+		return
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read logo"})
+		return
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, services.MaxQRLogoBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read logo"})
+		return
+	}
+
+	logo, err := h.logos(c).SaveLogo(name, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditQRLogoUpload,
+		ResourceType: "qr_logo",
+		ResourceID:   logo.Name,
+		After:        map[string]interface{}{"name": logo.Name, "content_type": logo.ContentType, "size": len(data)},
+	})
+
+	c.JSON(http.StatusCreated, logo)
+}
+
+func (h *QRHandler) GetLogos(c *gin.Context) {
+	logos, err := h.logos(c).GetLogos()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get logos"})
+		return
+	}
+
+	c.JSON(http.StatusOK, logos)
+}
+
+func (h *QRHandler) DeleteLogo(c *gin.Context) {
+	name := c.Param("name")
+	if err := h.logos(c).DeleteLogo(name); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditQRLogoDelete,
+		ResourceType: "qr_logo",
+		ResourceID:   name,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logo deleted successfully"})
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"url-shortener/middleware"
 	"url-shortener/models"
 	"url-shortener/services"
 	"url-shortener/utils"
@@ -13,18 +16,53 @@ import (
 	"gorm.io/gorm"
 )
 
+// Wrong password attempts allowed per IP before unlocking is throttled
+const (
+	maxUnlockFailures = 5
+	unlockWindow      = 15 * time.Minute
+	// Wrong passwords tried on one link from any number of addresses
+	maxLinkUnlockFailures = 20
+)
+
+// Cookie remembering a visitor's A/B variant, named after the link's code
+const (
+	variantCookiePrefix = "ab_"
+	variantCookieTTL    = 90 * 24 * time.Hour
+)
+
 type URLHandler struct {
 	urlService       *services.URLService
 	analyticsService *services.AnalyticsService
+	auditService     *services.AuditService
+	workspaceService *services.WorkspaceService
+	domainService    *services.DomainService
+	unlockLimiter    *utils.AttemptLimiter
+	linkUnlocks      *utils.AttemptLimiter
+	clickPipeline    *services.ClickPipeline
+	webhooks         *services.WebhookDispatcher
+	geoResolver      services.GeoResolver
 }
 
-func NewURLHandler(db *gorm.DB) *URLHandler {
+func NewURLHandler(db *gorm.DB, urlCache services.URLCache, clickPipeline *services.ClickPipeline, workspaceService *services.WorkspaceService, domainService *services.DomainService, webhooks *services.WebhookDispatcher, geoResolver services.GeoResolver) *URLHandler {
 	return &URLHandler{
-		urlService:       services.NewURLService(db),
+		urlService:       services.NewURLService(db, urlCache),
 		analyticsService: services.NewAnalyticsService(db),
+		auditService:     services.NewAuditService(db),
+		workspaceService: workspaceService,
+		domainService:    domainService,
+		unlockLimiter:    utils.NewAttemptLimiter(maxUnlockFailures, unlockWindow),
+		linkUnlocks:      utils.NewAttemptLimiter(maxLinkUnlockFailures, unlockWindow),
+		clickPipeline:    clickPipeline,
+		webhooks:         webhooks,
+		geoResolver:      geoResolver,
 	}
 }
 
+// urls returns the URL service limited to the caller's workspace
+func (h *URLHandler) urls(c *gin.Context) *services.URLService {
+	return h.urlService.ForWorkspace(middleware.ContextWorkspaceID(c))
+}
+
 func (h *URLHandler) ShortenURL(c *gin.Context) {
 	var req models.ShortenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -32,25 +70,39 @@ func (h *URLHandler) ShortenURL(c *gin.Context) {
 		return
 	}
 
+	if err := services.CheckDestinations(contextAPIKey(c), shortenTargets(req)...); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Get API key ID from context (empty string if not authenticated)
 	apiKeyID := c.GetString("api_key_id")
 
-	url, isNew, err := h.urlService.CreateShortURL(req, apiKeyID)
+	url, isNew, err := h.urls(c).CreateShortURL(req, apiKeyID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create short URL"})
+		status, message := shortenError(err)
+		c.JSON(status, gin.H{"error": message})
 		return
 	}
 
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = "http://localhost:8080"
+	if isNew {
+		recordAudit(c, h.auditService, models.AuditLog{
+			Action:       models.AuditURLCreate,
+			ResourceType: "url",
+			ResourceID:   url.Code,
+			After:        services.AuditSnapshot(url),
+		})
+		h.webhooks.LinkEvent(models.EventLinkCreated, url)
 	}
 
 	response := models.ShortenResponse{
 		Code:        url.Code,
-		ShortURL:    baseURL + "/" + url.Code,
+		ShortURL:    h.domainService.ShortURL(url.DomainID, url.Code),
 		OriginalURL: url.OriginalURL,
 		IsNew:       isNew,
+		ExpiresAt:   url.ExpiresAt,
+		MaxClicks:   url.MaxClicks,
+		Protected:   url.PasswordHash != "",
 	}
 
 	statusCode := http.StatusCreated
@@ -61,39 +113,267 @@ func (h *URLHandler) ShortenURL(c *gin.Context) {
 	c.JSON(statusCode, response)
 }
 
+// shortenError maps a CreateShortURL error to a status code and client-safe message
+func shortenError(err error) (int, string) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidAlias),
+		errors.Is(err, utils.ErrInvalidVariants),
+		errors.Is(err, utils.ErrInvalidRules),
+		errors.Is(err, services.ErrExpiryInPast),
+		errors.Is(err, services.ErrPasswordTooLong),
+		errors.Is(err, services.ErrDomainNotVerified),
+		errors.Is(err, services.ErrUTMTooLong),
+		errors.Is(err, services.ErrCampaignNotFound):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, services.ErrAliasTaken):
+		return http.StatusConflict, err.Error()
+	default:
+		return http.StatusInternalServerError, "Failed to create short URL"
+	}
+}
+
+// shortenTargets lists every URL a shorten request could redirect to
+func shortenTargets(req models.ShortenRequest) []string {
+	targets := []string{
+		req.URL,
+		req.IOSRedirectURL,
+		req.AndroidRedirectURL,
+		req.DesktopRedirectURL,
+		req.MacRedirectURL,
+		req.ExpiredRedirectURL,
+	}
+	for _, rule := range req.Rules {
+		targets = append(targets, rule.URL)
+	}
+	for _, variant := range req.Variants {
+		targets = append(targets, variant.URL)
+	}
+	return targets
+}
+
+// contextAPIKey returns the API key set by the auth middleware, or nil for anonymous requests
+func contextAPIKey(c *gin.Context) *models.APIKey {
+	value, exists := c.Get("api_key")
+	if !exists {
+		return nil
+	}
+	apiKey, _ := value.(*models.APIKey)
+	return apiKey
+}
+
 func (h *URLHandler) RedirectURL(c *gin.Context) {
-	code := c.Param("code")
+	url, ok := h.findActiveURL(c)
+	if !ok {
+		return
+	}
+
+	// Protected links show the password form instead of redirecting
+	if url.PasswordHash != "" {
+		h.renderPasswordPage(c, http.StatusOK, url, "")
+		return
+	}
+
+	h.redirect(c, url, http.StatusTemporaryRedirect, c.Request.Referer())
+}
+
+// UnlockURL checks the password posted from the interstitial page and redirects on success
+func (h *URLHandler) UnlockURL(c *gin.Context) {
+	url, ok := h.findActiveURL(c)
+	if !ok {
+		return
+	}
+
+	// Guesses are limited per client and, as clients can change address, per
+	// link too. Unlocking doesn't clear the link's count, so a visitor who
+	// knows the password can't reset it for a guesser.
+	ip, link := c.ClientIP(), strconv.FormatUint(uint64(url.ID), 10)
+	if retryAfter := max(h.unlockLimiter.RetryAfter(ip), h.linkUnlocks.RetryAfter(link)); retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		h.renderPasswordPage(c, http.StatusTooManyRequests, url, "Too many incorrect attempts. Please try again later.")
+		return
+	}
+
+	if !h.urlService.CheckPassword(url, c.PostForm("password")) {
+		h.unlockLimiter.Fail(ip)
+		h.linkUnlocks.Fail(link)
+		h.renderPasswordPage(c, http.StatusUnauthorized, url, "Incorrect password.")
+		return
+	}
 
-	url, err := h.urlService.GetURLByCode(code)
+	h.unlockLimiter.Reset(ip)
+
+	// 303 so the browser follows up with a GET to the destination; the
+	// referrer is the one captured when the password page was first shown
+	h.redirect(c, url, http.StatusSeeOther, c.PostForm("referrer"))
+}
+
+// findActiveURL loads the URL for the request's host and code and writes the
+// not-found or expired response itself when it can't be redirected to
+func (h *URLHandler) findActiveURL(c *gin.Context) (*models.URL, bool) {
+	url, err := h.urlService.GetURLByCode(h.domainService.ResolveHost(c.Request.Host), c.Param("code"))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+		return nil, false
+	}
+
+	// Expired links are not counted as clicks
+	if expired, reason := utils.CheckExpiry(url, time.Now()); expired {
+		h.renderExpired(c, url, reason)
+		return nil, false
+	}
+
+	return url, true
+}
+
+// renderExpired sends visitors of an expired link to its fallback, or shows
+// the expired page
+func (h *URLHandler) renderExpired(c *gin.Context, url *models.URL, reason string) {
+	if url.ExpiredRedirectURL != "" {
+		c.Redirect(http.StatusTemporaryRedirect, url.ExpiredRedirectURL)
 		return
 	}
+	c.HTML(http.StatusGone, "expired.html", gin.H{
+		"BaseURL": h.domainService.LinkBase(url.DomainID),
+		"Code":    url.Code,
+		"Reason":  reason,
+		"Brand":   pageBranding(h.workspaceService, url.WorkspaceID),
+	})
+}
 
+// redirect records the click and sends the visitor to the platform-specific destination
+func (h *URLHandler) redirect(c *gin.Context, url *models.URL, status int, referrer string) {
 	// Detect platform
 	platformInfo := utils.DetectPlatform(c.Request)
 
 	// Record click analytics
 	click := models.Click{
-		URLCode:   code,
-		IPAddress: c.ClientIP(),
-		UserAgent: c.Request.UserAgent(),
-		Platform:  platformInfo.Platform,
-		Browser:   platformInfo.Browser,
-		OS:        platformInfo.OS,
-		Referrer:  c.Request.Referer(),
-		ClickedAt: time.Now(),
+		WorkspaceID:    url.WorkspaceID,
+		URLCode:        url.Code,
+		IPAddress:      c.ClientIP(),
+		UserAgent:      c.Request.UserAgent(),
+		Platform:       platformInfo.Platform,
+		Device:         platformInfo.Device,
+		Browser:        platformInfo.Browser,
+		BrowserVersion: platformInfo.BrowserVersion,
+		OS:             platformInfo.OS,
+		OSVersion:      platformInfo.OSVersion,
+		IsBot:          platformInfo.Bot != "",
+		BotName:        platformInfo.Bot,
+		Referrer:       referrer,
+		ClickedAt:      time.Now(),
+	}
+
+	// Get the destination of the first matching rule. Visitors no rule
+	// matches take part in the link's A/B split instead, if it has one.
+	redirectURL, matched := utils.GetRedirectURL(url, h.visitor(c, url, platformInfo, referrer, click.ClickedAt))
+	if !matched {
+		if variant := h.chooseVariant(c, url); variant != nil {
+			redirectURL = variant.URL
+			click.Variant = variant.Name
+		}
+	}
+
+	// Visits to links with a click budget are counted before redirecting, so
+	// the last click can only go to one of several concurrent visitors. Bots
+	// use up the budget too, as anyone can claim to be one.
+	if url.MaxClicks > 0 {
+		reserved, err := h.urlService.ReserveClick(url)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record click"})
+			return
+		}
+		if !reserved {
+			h.renderExpired(c, url, utils.ClickBudgetUsedUp)
+			return
+		}
+	}
+
+	// Queued for batched insert; location is resolved by the pipeline workers
+	h.clickPipeline.Enqueue(click)
+
+	redirectURL = utils.AppendUTM(redirectURL, url.UTM)
+
+	// Link-preview crawlers would show the destination's card; links with a
+	// preview of their own show them that instead
+	if url.Preview.IsSet() && utils.IsPreviewBot(platformInfo.Bot) {
+		h.renderPreviewPage(c, url, redirectURL)
+		return
+	}
+
+	c.Redirect(status, redirectURL)
+}
+
+// renderPreviewPage serves the link's Open Graph tags, with a plain link to the
+// destination for anyone who isn't a crawler after all
+func (h *URLHandler) renderPreviewPage(c *gin.Context, url *models.URL, destination string) {
+	c.Header("Cache-Control", "no-store")
+	c.HTML(http.StatusOK, "preview.html", gin.H{
+		"ShortURL":    h.domainService.ShortURL(url.DomainID, url.Code),
+		"Destination": destination,
+		"Preview":     url.Preview,
+		"Brand":       pageBranding(h.workspaceService, url.WorkspaceID),
+	})
+}
+
+// visitor describes the request for matching the link's redirect rules. The
+// location is only looked up when a rule needs the country.
+func (h *URLHandler) visitor(c *gin.Context, url *models.URL, platformInfo utils.PlatformInfo, referrer string, now time.Time) utils.Visitor {
+	visitor := utils.Visitor{
+		Platform: platformInfo.Platform,
+		Browser:  platformInfo.Browser,
+		Language: utils.PreferredLanguage(c.GetHeader("Accept-Language")),
+		Referrer: referrer,
+		Query:    c.Request.URL.Query(),
+		Time:     now,
+	}
+	if utils.RulesUseCountry(url.Rules) {
+		if location, err := h.geoResolver.Lookup(c.ClientIP()); err == nil {
+			visitor.Country = location.CountryCode
+		}
+	}
+	return visitor
+}
+
+// chooseVariant returns the visitor's variant of a link's A/B split, or nil if
+// the link has none. The variant is remembered in a cookie scoped to the link.
+// Visitors without one are assigned by their IP and user agent, so they mostly
+// keep their variant even when cookies are blocked.
+func (h *URLHandler) chooseVariant(c *gin.Context, url *models.URL) *models.LinkVariant {
+	if len(url.Variants) == 0 {
+		return nil
 	}
 
-	go func() {
-		h.analyticsService.RecordClick(click)
-		h.urlService.IncrementClickCount(code)
-	}()
+	cookie := variantCookiePrefix + url.Code
+	if name, err := c.Cookie(cookie); err == nil {
+		if variant := utils.FindVariant(url.Variants, name); variant != nil {
+			return variant
+		}
+	}
 
-	// Get platform-specific redirect URL
-	redirectURL := utils.GetRedirectURL(url, platformInfo.Platform)
+	variant := utils.PickVariant(url.Variants, url.Code+"|"+c.ClientIP()+"|"+c.Request.UserAgent())
+	if variant != nil {
+		secure := strings.HasPrefix(h.domainService.LinkBase(url.DomainID), "https://")
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(cookie, variant.Name, int(variantCookieTTL.Seconds()), "/"+url.Code, "", secure, true)
+	}
+	return variant
+}
 
-	c.Redirect(http.StatusTemporaryRedirect, redirectURL)
+func (h *URLHandler) renderPasswordPage(c *gin.Context, status int, url *models.URL, errorMessage string) {
+	// Keep the visitor's original referrer across form submissions
+	referrer := c.Request.Referer()
+	if c.Request.Method == http.MethodPost {
+		referrer = c.PostForm("referrer")
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.HTML(status, "password.html", gin.H{
+		"BaseURL":  h.domainService.LinkBase(url.DomainID),
+		"Code":     url.Code,
+		"Error":    errorMessage,
+		"Referrer": referrer,
+		"Brand":    pageBranding(h.workspaceService, url.WorkspaceID),
+	})
 }
 
 func (h *URLHandler) GetMyURLs(c *gin.Context) {
@@ -103,26 +383,174 @@ func (h *URLHandler) GetMyURLs(c *gin.Context) {
 		return
 	}
 
-	urls, err := h.urlService.GetURLsByAPIKey(apiKeyID)
+	urls, err := h.urls(c).GetURLsByAPIKey(apiKeyID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get URLs"})
 		return
 	}
 
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = "http://localhost:8080"
-	}
-
 	var response []models.ShortenResponse
 	for _, url := range urls {
 		response = append(response, models.ShortenResponse{
 			Code:        url.Code,
-			ShortURL:    baseURL + "/" + url.Code,
+			ShortURL:    h.domainService.ShortURL(url.DomainID, url.Code),
 			OriginalURL: url.OriginalURL,
 			IsNew:       false,
+			ExpiresAt:   url.ExpiresAt,
+			MaxClicks:   url.MaxClicks,
+			Protected:   url.PasswordHash != "",
 		})
 	}
 
 	c.JSON(http.StatusOK, response)
 }
+
+// GetMyURL returns a single URL owned by the calling API key
+func (h *URLHandler) GetMyURL(c *gin.Context) {
+	url, err := h.urls(c).GetOwnedURL(c.Param("code"), c.GetString("api_key_id"), false)
+	if err != nil {
+		respondURLError(c, err, "Failed to get URL")
+		return
+	}
+
+	c.JSON(http.StatusOK, services.URLDetail(url, h.domainService.LinkBase(url.DomainID)))
+}
+
+// UpdateMyURL partially updates the redirect targets of a URL owned by the calling API key
+func (h *URLHandler) UpdateMyURL(c *gin.Context) {
+	var req models.UpdateURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var targets []string
+	for _, target := range []*string{req.URL, req.IOSRedirectURL, req.AndroidRedirectURL, req.DesktopRedirectURL, req.MacRedirectURL} {
+		if target != nil {
+			targets = append(targets, *target)
+		}
+	}
+	if req.Rules != nil {
+		for _, rule := range *req.Rules {
+			targets = append(targets, rule.URL)
+		}
+	}
+	if req.Variants != nil {
+		for _, variant := range *req.Variants {
+			targets = append(targets, variant.URL)
+		}
+	}
+	if err := services.CheckDestinations(contextAPIKey(c), targets...); err != nil {
+		respondURLError(c, err, "Failed to update URL")
+		return
+	}
+
+	code := c.Param("code")
+	urls := h.urls(c)
+	before, err := urls.GetOwnedURL(code, c.GetString("api_key_id"), false)
+	if err != nil {
+		respondURLError(c, err, "Failed to update URL")
+		return
+	}
+
+	url, err := urls.PatchURL(code, req)
+	if err != nil {
+		respondURLError(c, err, "Failed to update URL")
+		return
+	}
+
+	changedBefore, changedAfter := services.AuditDiff(before, url)
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditURLUpdate,
+		ResourceType: "url",
+		ResourceID:   code,
+		Before:       changedBefore,
+		After:        changedAfter,
+	})
+	h.webhooks.LinkEvent(models.EventLinkUpdated, url)
+
+	c.JSON(http.StatusOK, services.URLDetail(url, h.domainService.LinkBase(url.DomainID)))
+}
+
+// DeleteMyURL soft deletes a URL owned by the calling API key
+func (h *URLHandler) DeleteMyURL(c *gin.Context) {
+	code := c.Param("code")
+	urls := h.urls(c)
+	url, err := urls.GetOwnedURL(code, c.GetString("api_key_id"), false)
+	if err != nil {
+		respondURLError(c, err, "Failed to delete URL")
+		return
+	}
+
+	if err := urls.SoftDeleteURL(code); err != nil {
+		respondURLError(c, err, "Failed to delete URL")
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditURLDelete,
+		ResourceType: "url",
+		ResourceID:   code,
+		Before:       services.AuditSnapshot(url),
+	})
+	h.webhooks.LinkEvent(models.EventLinkDeleted, url)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "URL deleted successfully",
+		"code":    code,
+	})
+}
+
+// RestoreMyURL restores a soft-deleted URL owned by the calling API key
+func (h *URLHandler) RestoreMyURL(c *gin.Context) {
+	code := c.Param("code")
+	urls := h.urls(c)
+	url, err := urls.GetOwnedURL(code, c.GetString("api_key_id"), true)
+	if err != nil {
+		respondURLError(c, err, "Failed to restore URL")
+		return
+	}
+
+	if !url.DeletedAt.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "URL is not deleted"})
+		return
+	}
+
+	if err := urls.RestoreURL(code); err != nil {
+		respondURLError(c, err, "Failed to restore URL")
+		return
+	}
+
+	restored := *url
+	restored.DeletedAt = gorm.DeletedAt{}
+	changedBefore, changedAfter := services.AuditDiff(url, &restored)
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditURLRestore,
+		ResourceType: "url",
+		ResourceID:   code,
+		Before:       changedBefore,
+		After:        changedAfter,
+	})
+	h.webhooks.LinkEvent(models.EventLinkUpdated, &restored)
+
+	c.JSON(http.StatusOK, services.URLDetail(&restored, h.domainService.LinkBase(url.DomainID)))
+}
+
+// respondURLError maps URL service errors to HTTP responses
+func respondURLError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrURLNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUTMTooLong),
+		errors.Is(err, utils.ErrInvalidVariants),
+		errors.Is(err, utils.ErrInvalidRules):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrURLNotOwned),
+		errors.Is(err, services.ErrDomainNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDuplicateURL):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"url-shortener/models"
+	"url-shortener/services"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// newRedirectRouter serves short links as main.go does
+func newRedirectRouter(t *testing.T, db *gorm.DB) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	pipeline := services.NewClickPipeline(db, services.NoopGeoResolver{}, services.ClickPipelineConfig{
+		WALPath: filepath.Join(t.TempDir(), "clicks.wal"),
+	})
+	t.Cleanup(pipeline.Close)
+	domainService := services.NewDomainService(db, nil, "http://localhost:8080")
+	webhooks := services.NewWebhookDispatcher(db, domainService, services.WebhookConfig{})
+	t.Cleanup(webhooks.Close)
+	handler := NewURLHandler(db, services.NoopURLCache{}, pipeline, services.NewWorkspaceService(db), domainService, webhooks, services.NoopGeoResolver{})
+
+	r := gin.New()
+	r.LoadHTMLGlob("../static/*.html")
+	r.GET("/:code", handler.RedirectURL)
+	r.POST("/:code", handler.UnlockURL)
+	return r
+}
+
+// visit requests a short link with a browser's headers, minus those set to ""
+func visit(r *gin.Engine, method, path, body, ip string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = ip + ":1234"
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("Accept", "text/html")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for name, value := range headers {
+		if value == "" {
+			req.Header.Del(name)
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRedirectClickBudgetCountsBots(t *testing.T) {
+	db := testDB(t)
+	url := models.URL{Code: "budget", OriginalURL: "https://example.com/", URLHash: "budget", MaxClicks: 2, WorkspaceID: models.DefaultWorkspaceID}
+	if err := db.Create(&url).Error; err != nil {
+		t.Fatalf("create url: %v", err)
+	}
+	r := newRedirectRouter(t, db)
+
+	// Leaving out the Accept header, or claiming to be a bot, doesn't get
+	// past the budget
+	if w := visit(r, "GET", "/budget", "", "192.0.2.1", map[string]string{"Accept": ""}); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("first visit status = %d, want %d", w.Code, http.StatusTemporaryRedirect)
+	}
+	if w := visit(r, "GET", "/budget", "", "192.0.2.1", map[string]string{"User-Agent": "Twitterbot/1.0"}); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("second visit status = %d, want %d", w.Code, http.StatusTemporaryRedirect)
+	}
+	for _, headers := range []map[string]string{{"Accept": ""}, {"User-Agent": "curl/8.4.0"}, nil} {
+		if w := visit(r, "GET", "/budget", "", "192.0.2.1", headers); w.Code != http.StatusGone {
+			t.Errorf("visit with %v after the budget is used up: status = %d, want %d", headers, w.Code, http.StatusGone)
+		}
+	}
+
+	if err := db.First(&url, url.ID).Error; err != nil {
+		t.Fatalf("load url: %v", err)
+	}
+	if url.ClickCount != 2 {
+		t.Errorf("click_count = %d, want 2", url.ClickCount)
+	}
+}
+
+func TestUnlockURLLimits(t *testing.T) {
+	db := testDB(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	url := models.URL{Code: "locked", OriginalURL: "https://example.com/", URLHash: "locked", PasswordHash: string(hash), WorkspaceID: models.DefaultWorkspaceID}
+	if err := db.Create(&url).Error; err != nil {
+		t.Fatalf("create url: %v", err)
+	}
+	r := newRedirectRouter(t, db)
+
+	if w := visit(r, "GET", "/locked", "", "192.0.2.1", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "password") {
+		t.Fatalf("GET status = %d, want the password page", w.Code)
+	}
+	if w := visit(r, "POST", "/locked", "password=secret", "192.0.2.1", nil); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "https://example.com/" {
+		t.Fatalf("unlock status = %d to %q, want a redirect to the destination", w.Code, w.Header().Get("Location"))
+	}
+
+	// Each IP gets maxUnlockFailures guesses, after which even the right
+	// password is refused
+	for i := 0; i < maxUnlockFailures; i++ {
+		if w := visit(r, "POST", "/locked", "password=guess", "192.0.2.2", nil); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	}
+	w := visit(r, "POST", "/locked", "password=secret", "192.0.2.2", nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("unlock from blocked IP status = %d, Retry-After %q, want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := visit(r, "POST", "/locked", "password=secret", "192.0.2.3", nil); w.Code != http.StatusSeeOther {
+		t.Errorf("unlock from another IP status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	// Guesses spread over many IPs are limited per link, and unlocking
+	// doesn't reset the link's count
+	for i := maxUnlockFailures; i < maxLinkUnlockFailures; i++ {
+		ip := fmt.Sprintf("198.51.100.%d", i/maxUnlockFailures)
+		visit(r, "POST", "/locked", "password=guess", ip, nil)
+	}
+	if w := visit(r, "POST", "/locked", "password=secret", "203.0.113.1", nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("unlock of a link with too many wrong guesses status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"url-shortener/middleware"
+	"url-shortener/models"
+	"url-shortener/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookHandler struct {
+	webhookService *services.WebhookService
+	auditService   *services.AuditService
+}
+
+func NewWebhookHandler(webhookService *services.WebhookService, auditService *services.AuditService) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService, auditService: auditService}
+}
+
+// webhooks returns the webhook service limited to the signed-in admin's workspace
+func (h *WebhookHandler) webhooks(c *gin.Context) *services.WebhookService {
+	return h.webhookService.ForWorkspace(middleware.ContextWorkspaceID(c))
+}
+
+func (h *WebhookHandler) GetWebhooks(c *gin.Context) {
+	webhooks, err := h.webhooks(c).GetWebhooks(c.Param("keyId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhooks"})
+		return
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// CreateWebhook subscribes a URL to events of an API key's links. The signing
+// secret is only returned here.
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req models.WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	webhook, err := h.webhooks(c).CreateWebhook(c.Param("keyId"), req)
+	if err != nil {
+		respondWebhookError(c, err, "Failed to create webhook")
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditWebhookCreate,
+		ResourceType: "webhook",
+		ResourceID:   strconv.FormatUint(uint64(webhook.ID), 10),
+		After:        services.AuditSnapshot(webhook),
+	})
+
+	c.JSON(http.StatusCreated, models.WebhookCreatedResponse{Webhook: *webhook, Secret: webhook.Secret})
+}
+
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	id, ok := webhookID(c, "id", "webhook")
+	if !ok {
+		return
+	}
+
+	var req models.WebhookUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	webhooks := h.webhooks(c)
+	before, err := webhooks.GetWebhook(id)
+	var webhook *models.Webhook
+	if err == nil {
+		webhook, err = webhooks.UpdateWebhook(id, req)
+	}
+	if err != nil {
+		respondWebhookError(c, err, "Failed to update webhook")
+		return
+	}
+
+	changedBefore, changedAfter := services.AuditDiff(before, webhook)
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditWebhookUpdate,
+		ResourceType: "webhook",
+		ResourceID:   strconv.FormatUint(uint64(id), 10),
+		Before:       changedBefore,
+		After:        changedAfter,
+	})
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+// DeleteWebhook removes a webhook along with its delivery log and dead letters
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	id, ok := webhookID(c, "id", "webhook")
+	if !ok {
+		return
+	}
+
+	webhook, err := h.webhooks(c).DeleteWebhook(id)
+	if err != nil {
+		respondWebhookError(c, err, "Failed to delete webhook")
+		return
+	}
+
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditWebhookDelete,
+		ResourceType: "webhook",
+		ResourceID:   strconv.FormatUint(uint64(id), 10),
+		Before:       services.AuditSnapshot(webhook),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// GetDeliveries pages through a webhook's delivery log, newest first,
+// optionally filtered by status
+func (h *WebhookHandler) GetDeliveries(c *gin.Context) {
+	id, ok := webhookID(c, "id", "webhook")
+	if !ok {
+		return
+	}
+	page, ok := queryInt(c, "page", 1, 1, math.MaxInt32)
+	if !ok {
+		return
+	}
+	limit, ok := queryInt(c, "limit", 50, 1, 200)
+	if !ok {
+		return
+	}
+
+	status := c.Query("status")
+	switch status {
+	case "", models.DeliveryPending, models.DeliverySucceeded, models.DeliveryFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, succeeded or failed"})
+		return
+	}
+
+	deliveries, total, err := h.webhooks(c).GetDeliveries(id, status, (page-1)*limit, limit)
+	if err != nil {
+		respondWebhookError(c, err, "Failed to get deliveries")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.WebhookDeliveriesResponse{
+		Deliveries: deliveries,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+	})
+}
+
+func (h *WebhookHandler) GetDeadLetters(c *gin.Context) {
+	id, ok := webhookID(c, "id", "webhook")
+	if !ok {
+		return
+	}
+
+	letters, err := h.webhooks(c).GetDeadLetters(id)
+	if err != nil {
+		respondWebhookError(c, err, "Failed to get dead letters")
+		return
+	}
+	c.JSON(http.StatusOK, letters)
+}
+
+// RetryDeadLetter queues a dead letter for delivery again
+func (h *WebhookHandler) RetryDeadLetter(c *gin.Context) {
+	id, ok := webhookID(c, "id", "webhook")
+	if !ok {
+		return
+	}
+	letterID, ok := webhookID(c, "letterId", "dead letter")
+	if !ok {
+		return
+	}
+
+	delivery, err := h.webhooks(c).RetryDeadLetter(id, letterID)
+	if err != nil {
+		respondWebhookError(c, err, "Failed to retry dead letter")
+		return
+	}
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+func webhookID(c *gin.Context, param, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(param), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + " ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondWebhookError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrWebhookNotFound),
+		errors.Is(err, services.ErrDeadLetterNotFound),
+		errors.Is(err, services.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"url-shortener/middleware"
+	"url-shortener/models"
+	"url-shortener/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Branding of link pages for workspaces that don't set their own
+const (
+	defaultBrandName    = "Kamero URL Shortener"
+	defaultBrandLogoURL = "https://kamero-public.s3.ap-south-1.amazonaws.com/logos/KAMERO-LOGO-WHITE-WORDMARK.png"
+)
+
+type WorkspaceHandler struct {
+	workspaceService *services.WorkspaceService
+	auditService     *services.AuditService
+}
+
+func NewWorkspaceHandler(workspaceService *services.WorkspaceService, auditService *services.AuditService) *WorkspaceHandler {
+	return &WorkspaceHandler{workspaceService: workspaceService, auditService: auditService}
+}
+
+// GetCurrentWorkspace returns the signed-in admin's workspace
+func (h *WorkspaceHandler) GetCurrentWorkspace(c *gin.Context) {
+	workspace, err := h.workspaceService.GetWorkspace(middleware.ContextWorkspaceID(c))
+	if err != nil {
+		respondWorkspaceError(c, err, "Failed to get workspace")
+		return
+	}
+	c.JSON(http.StatusOK, workspace)
+}
+
+// UpdateCurrentWorkspace changes the name, default domain and branding of the
+// signed-in owner's workspace. The default domain must be one of its domains.
+// Quotas are set by owners of the default workspace.
+func (h *WorkspaceHandler) UpdateCurrentWorkspace(c *gin.Context) {
+	var req models.WorkspaceUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	workspaceID := middleware.ContextWorkspaceID(c)
+	if (req.DailyQuota != nil || req.MonthlyQuota != nil) && workspaceID != models.DefaultWorkspaceID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Workspace quotas can only be changed by owners of the default workspace"})
+		return
+	}
+
+	h.updateWorkspace(c, workspaceID, req)
+}
+
+func (h *WorkspaceHandler) GetWorkspaces(c *gin.Context) {
+	workspaces, err := h.workspaceService.GetWorkspaces()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get workspaces"})
+		return
+	}
+	c.JSON(http.StatusOK, workspaces)
+}
+
+// CreateWorkspace creates a workspace and its first owner
+func (h *WorkspaceHandler) CreateWorkspace(c *gin.Context) {
+	var req models.WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	workspace, owner, err := h.workspaceService.CreateWorkspace(req)
+	if err != nil {
+		respondWorkspaceError(c, err, "Failed to create workspace")
+		return
+	}
+
+	resourceID := strconv.FormatUint(uint64(workspace.ID), 10)
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditWorkspaceCreate,
+		ResourceType: "workspace",
+		ResourceID:   resourceID,
+		After:        services.AuditSnapshot(workspace),
+	}, models.AuditLog{
+		// Recorded in the new workspace too, so its owners can see who set it up
+		WorkspaceID:  workspace.ID,
+		Action:       models.AuditAdminUserCreate,
+		ResourceType: "admin_user",
+		ResourceID:   owner.Username,
+		After:        services.AuditSnapshot(owner),
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"workspace": workspace, "owner": owner})
+}
+
+// UpdateWorkspace changes any workspace's settings, including its quotas
+func (h *WorkspaceHandler) UpdateWorkspace(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+		return
+	}
+
+	var req models.WorkspaceUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.updateWorkspace(c, uint(id), req)
+}
+
+func (h *WorkspaceHandler) updateWorkspace(c *gin.Context, id uint, req models.WorkspaceUpdateRequest) {
+	before, err := h.workspaceService.GetWorkspace(id)
+	var workspace *models.Workspace
+	if err == nil {
+		workspace, err = h.workspaceService.UpdateWorkspace(id, req)
+	}
+	if err != nil {
+		respondWorkspaceError(c, err, "Failed to update workspace")
+		return
+	}
+
+	changedBefore, changedAfter := services.AuditDiff(before, workspace)
+	recordAudit(c, h.auditService, models.AuditLog{
+		Action:       models.AuditWorkspaceUpdate,
+		ResourceType: "workspace",
+		ResourceID:   strconv.FormatUint(uint64(id), 10),
+		Before:       changedBefore,
+		After:        changedAfter,
+	})
+
+	c.JSON(http.StatusOK, workspace)
+}
+
+func respondWorkspaceError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrWorkspaceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidSlug),
+		errors.Is(err, services.ErrUnknownDefaultDomain):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSlugTaken),
+		errors.Is(err, services.ErrUsernameTaken),
+		errors.Is(err, services.ErrDomainTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// pageBranding returns the name, logo and accent colour of a workspace's
+// password and expired link pages
+func pageBranding(workspaceService *services.WorkspaceService, workspaceID uint) gin.H {
+	brand := gin.H{"Name": defaultBrandName, "LogoURL": defaultBrandLogoURL, "Color": ""}
+	workspace, err := workspaceService.GetWorkspace(workspaceID)
+	if err != nil {
+		return brand
+	}
+	if workspace.BrandName != "" {
+		brand["Name"] = workspace.BrandName
+	}
+	if workspace.BrandLogoURL != "" {
+		brand["LogoURL"] = workspace.BrandLogoURL
+	}
+	brand["Color"] = workspace.BrandColor
+	return brand
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"url-shortener/config"
 	"url-shortener/handlers"
 	"url-shortener/middleware"
+	"url-shortener/models"
+	"url-shortener/services"
+	"url-shortener/utils"
 
 	"github.com/gin-gonic/gin"
 )
@@ -25,6 +34,9 @@ func main() {
 
 	// Initialize router
 	r := gin.Default()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
 
 	// Middleware
 	r.Use(middleware.CORS())
@@ -35,78 +47,194 @@ func main() {
 	r.Static("/static", "./static")
 	r.LoadHTMLGlob("static/*.html")
 
+	// Initialize GeoIP resolver (offline MaxMind databases, optional)
+	geoResolver := services.NewGeoResolver(cfg.GeoIPDBPath, cfg.GeoIPASNDBPath, cfg.GeoIPCacheSize)
+
+	// Initialize redirect lookup cache (shared so updates and deletes invalidate it)
+	urlCache := services.NewURLCache(cfg.CacheBackend, cfg.CacheSize, cfg.CacheTTL, cfg.CacheNegativeTTL, cfg.RedisURL)
+
+	// Shared so every API key check uses the same secret hashing configuration
+	apiKeyService := services.NewAPIKeyService(db, services.APIKeyConfig{
+		HMACSecret:    cfg.APIKeyHMACSecret,
+		RotationGrace: cfg.APIKeyRotationGrace,
+	})
+
+	// Admin users sign in with their own accounts; the env pair seeds the first owner
+	adminUserService := services.NewAdminUserService(db, cfg.AdminSessionTTL)
+	if err := adminUserService.Bootstrap(cfg.AdminUsername, cfg.AdminPassword); err != nil {
+		log.Fatal("Failed to create initial admin user:", err)
+	}
+
+	// Audit log of admin and API changes
+	auditService := services.NewAuditService(db)
+
+	// Workspace settings (shared so changes invalidate the settings cache)
+	workspaceService := services.NewWorkspaceService(db)
+
+	// Custom link domains (shared so verifying or deleting one invalidates the host cache)
+	domainService := services.NewDomainService(db, services.NewTXTResolver(cfg.DNSResolver), cfg.BaseURL)
+
+	// Webhook deliveries are queued in the database and sent in the background
+	webhookDispatcher := services.NewWebhookDispatcher(db, domainService, services.WebhookConfig{
+		Workers:              cfg.WebhookWorkers,
+		Timeout:              cfg.WebhookTimeout,
+		MaxAttempts:          cfg.WebhookMaxAttempts,
+		BackoffBase:          cfg.WebhookBackoffBase,
+		LogRetention:         cfg.WebhookLogRetention,
+		AllowPrivateNetworks: cfg.WebhookAllowPrivate,
+	})
+
+	// Live click stream for the dashboard
+	clickHub := services.NewClickHub()
+
+	// Initialize click ingestion pipeline (drained on shutdown); stored clicks feed
+	// click.recorded webhooks and the live stream
+	clickPipeline := services.NewClickPipeline(db, geoResolver, services.ClickPipelineConfig{
+		QueueSize:     cfg.ClickQueueSize,
+		Workers:       cfg.ClickWorkers,
+		BatchSize:     cfg.ClickBatchSize,
+		FlushInterval: cfg.ClickFlushInterval,
+		WALPath:       cfg.ClickWALPath,
+	}, webhookDispatcher, clickHub)
+
 	// Initialize handlers
-	urlHandler := handlers.NewURLHandler(db)
+	urlHandler := handlers.NewURLHandler(db, urlCache, clickPipeline, workspaceService, domainService, webhookDispatcher, geoResolver)
 	analyticsHandler := handlers.NewAnalyticsHandler(db)
-	apiKeyHandler := handlers.NewAPIKeyHandler(db)
-	adminHandler := handlers.NewAdminHandler(db)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService, auditService)
+	adminHandler := handlers.NewAdminHandler(db, urlCache, domainService, webhookDispatcher, clickHub)
+	qrHandler := handlers.NewQRHandler(db, urlCache, domainService)
+	adminAuthHandler := handlers.NewAdminAuthHandler(adminUserService, auditService, cfg.AdminSessionTTL, cfg.BaseURL)
+	adminUserHandler := handlers.NewAdminUserHandler(adminUserService, auditService)
+	auditHandler := handlers.NewAuditHandler(auditService)
+	workspaceHandler := handlers.NewWorkspaceHandler(workspaceService, auditService)
+	domainHandler := handlers.NewDomainHandler(domainService, auditService)
+	webhookHandler := handlers.NewWebhookHandler(services.NewWebhookService(db, webhookDispatcher), auditService)
+	campaignHandler := handlers.NewCampaignHandler(db, domainService, auditService)
+
+	// Token buckets per API key (all API routes) and per IP (anonymous shortening)
+	apiKeyLimiter := utils.NewRateLimiter(time.Minute)
+	anonymousLimiter := utils.NewRateLimiter(time.Minute)
+	apiKeyRateLimit := middleware.APIKeyRateLimit(apiKeyLimiter, cfg.APIKeyRateLimit)
+	anonymousShortenRateLimit := middleware.AnonymousRateLimit(anonymousLimiter, cfg.AnonymousShortenRateLimit)
+	creationQuota := middleware.CreationQuota(apiKeyService, workspaceService)
+	loginRateLimit := middleware.AnonymousRateLimit(utils.NewRateLimiter(time.Minute), cfg.AdminLoginRateLimit)
+	optionalAdminAuth := middleware.OptionalAdminAuth(adminUserService)
 
 	// Public API routes (with optional API key auth)
 	api := r.Group("/api/v1")
-	api.Use(middleware.OptionalAPIKeyAuth(db))
+	api.Use(middleware.OptionalAPIKeyAuth(apiKeyService), apiKeyRateLimit)
 	{
-		api.POST("/shorten", urlHandler.ShortenURL)
-		api.GET("/analytics/:code", analyticsHandler.GetAnalytics)
-		api.GET("/analytics/:code/detailed", analyticsHandler.GetDetailedAnalytics)
+		api.POST("/shorten", middleware.RequireScope(models.ScopeLinksWrite), anonymousShortenRateLimit, creationQuota, urlHandler.ShortenURL)
+		api.GET("/analytics/:code", middleware.RequireScope(models.ScopeAnalyticsRead), optionalAdminAuth, analyticsHandler.GetAnalytics)
+		api.GET("/analytics/:code/detailed", middleware.RequireScope(models.ScopeAnalyticsRead), optionalAdminAuth, analyticsHandler.GetDetailedAnalytics)
+		api.GET("/qr/:code", qrHandler.GetQRCode)
 	}
 
-	// Protected API routes (require API key)
+	// Protected API routes (require API key with the route's scope)
 	protectedAPI := r.Group("/api/v1")
-	protectedAPI.Use(middleware.APIKeyAuth(db))
+	protectedAPI.Use(middleware.APIKeyAuth(apiKeyService), apiKeyRateLimit)
 	{
-		protectedAPI.GET("/my-urls", urlHandler.GetMyURLs)
+		protectedAPI.POST("/shorten/batch", middleware.RequireScope(models.ScopeLinksWrite), creationQuota, urlHandler.ShortenBatch)
+		protectedAPI.GET("/my-urls", middleware.RequireScope(models.ScopeLinksRead), urlHandler.GetMyURLs)
+		protectedAPI.GET("/urls/:code", middleware.RequireScope(models.ScopeLinksRead), urlHandler.GetMyURL)
+		protectedAPI.PATCH("/urls/:code", middleware.RequireScope(models.ScopeLinksWrite), urlHandler.UpdateMyURL)
+		protectedAPI.DELETE("/urls/:code", middleware.RequireScope(models.ScopeLinksDelete), urlHandler.DeleteMyURL)
+		protectedAPI.POST("/urls/:code/restore", middleware.RequireScope(models.ScopeLinksDelete), urlHandler.RestoreMyURL)
+		protectedAPI.GET("/campaigns", middleware.RequireScope(models.ScopeLinksRead), campaignHandler.GetCampaigns)
 	}
 
-	// Protected Admin API routes (require basic auth)
+	// Admin API routes (require an admin session or Basic auth; writes need the editor role).
+	// Everything is limited to the admin's workspace; owners of the default
+	// workspace also manage the other workspaces.
+	editor := middleware.RequireRole(models.RoleEditor)
+	owner := middleware.RequireRole(models.RoleOwner)
+	platformOwner := middleware.RequirePlatformOwner()
 	adminAPI := r.Group("/admin/api/v1")
-	adminAPI.Use(middleware.CustomBasicAuth())
+	adminAPI.Use(middleware.AdminAuth(adminUserService, false))
 	{
-		adminAPI.POST("/api-keys", apiKeyHandler.CreateAPIKey)
+		adminAPI.GET("/me", adminAuthHandler.Me)
+		adminAPI.PUT("/me/password", adminAuthHandler.ChangePassword)
+		adminAPI.GET("/users", owner, adminUserHandler.GetAdminUsers)
+		adminAPI.POST("/users", owner, adminUserHandler.CreateAdminUser)
+		adminAPI.PATCH("/users/:id", owner, adminUserHandler.UpdateAdminUser)
+		adminAPI.DELETE("/users/:id", owner, adminUserHandler.DeleteAdminUser)
+		adminAPI.GET("/audit", owner, auditHandler.GetAuditLogs)
+		adminAPI.GET("/workspace", workspaceHandler.GetCurrentWorkspace)
+		adminAPI.PATCH("/workspace", owner, workspaceHandler.UpdateCurrentWorkspace)
+		adminAPI.GET("/workspaces", platformOwner, workspaceHandler.GetWorkspaces)
+		adminAPI.POST("/workspaces", platformOwner, workspaceHandler.CreateWorkspace)
+		adminAPI.PATCH("/workspaces/:id", platformOwner, workspaceHandler.UpdateWorkspace)
+		adminAPI.GET("/domains", domainHandler.GetDomains)
+		adminAPI.POST("/domains", owner, domainHandler.CreateDomain)
+		adminAPI.POST("/domains/:id/verify", owner, domainHandler.VerifyDomain)
+		adminAPI.DELETE("/domains/:id", owner, domainHandler.DeleteDomain)
+		adminAPI.POST("/api-keys", editor, apiKeyHandler.CreateAPIKey)
 		adminAPI.GET("/api-keys", apiKeyHandler.GetAPIKeys)
-		adminAPI.DELETE("/api-keys/:keyId", apiKeyHandler.DeactivateAPIKey)
+		adminAPI.PATCH("/api-keys/:keyId", editor, apiKeyHandler.UpdateAPIKey)
+		adminAPI.POST("/api-keys/:keyId/rotate", editor, apiKeyHandler.RotateAPIKey)
+		adminAPI.DELETE("/api-keys/:keyId", editor, apiKeyHandler.DeactivateAPIKey)
+		adminAPI.GET("/api-keys/:keyId/webhooks", webhookHandler.GetWebhooks)
+		adminAPI.POST("/api-keys/:keyId/webhooks", editor, webhookHandler.CreateWebhook)
+		adminAPI.PATCH("/webhooks/:id", editor, webhookHandler.UpdateWebhook)
+		adminAPI.DELETE("/webhooks/:id", editor, webhookHandler.DeleteWebhook)
+		adminAPI.GET("/webhooks/:id/deliveries", webhookHandler.GetDeliveries)
+		adminAPI.GET("/webhooks/:id/dead-letters", webhookHandler.GetDeadLetters)
+		adminAPI.POST("/webhooks/:id/dead-letters/:letterId/retry", editor, webhookHandler.RetryDeadLetter)
+		adminAPI.GET("/campaigns", campaignHandler.GetCampaigns)
+		adminAPI.POST("/campaigns", editor, campaignHandler.CreateCampaign)
+		adminAPI.PATCH("/campaigns/:id", editor, campaignHandler.UpdateCampaign)
+		adminAPI.DELETE("/campaigns/:id", editor, campaignHandler.DeleteCampaign)
+		adminAPI.GET("/campaigns/:id/analytics", campaignHandler.GetCampaignAnalytics)
 		adminAPI.GET("/urls/analytics", adminHandler.GetAllURLsAnalytics)
 		adminAPI.GET("/system/stats", adminHandler.GetSystemStats)
+		adminAPI.GET("/system/performance", adminHandler.GetPerformanceMetrics)
+		adminAPI.GET("/system/realtime", adminHandler.GetRealTimeStats)
+		adminAPI.GET("/clicks/stream", adminHandler.StreamClicks)
+		adminAPI.GET("/urls/top", adminHandler.GetTopURLs)
+		adminAPI.GET("/activity", adminHandler.GetRecentActivity)
+		adminAPI.GET("/api-keys/usage", adminHandler.GetAPIKeyUsage)
+		adminAPI.GET("/analytics/geo", adminHandler.GetGeoAnalytics)
+		adminAPI.GET("/analytics/referrers", adminHandler.GetReferrerAnalytics)
+		adminAPI.GET("/analytics/trends", adminHandler.GetClickTrends)
+		adminAPI.GET("/analytics/export", adminHandler.ExportAnalytics)
+		adminAPI.POST("/urls/bulk-delete", editor, adminHandler.BulkDeleteURLs)
+		adminAPI.DELETE("/urls/:code", editor, adminHandler.DeleteURL)
+		adminAPI.POST("/urls/:code/restore", editor, adminHandler.RestoreURL)
+		adminAPI.POST("/qr/logos", editor, qrHandler.UploadLogo)
+		adminAPI.GET("/qr/logos", qrHandler.GetLogos)
+		adminAPI.DELETE("/qr/logos/:name", editor, qrHandler.DeleteLogo)
 	}
 
 	// Public web routes
 	r.GET("/", func(c *gin.Context) {
 		c.HTML(200, "index.html", gin.H{
-			"BaseURL": getBaseURL(),
+			"BaseURL": cfg.BaseURL,
 		})
 	})
 
-	// Protected web routes (require basic auth)
-	// Protected web routes (require basic auth)
+	// Admin sign-in
+	r.GET("/admin/login", adminAuthHandler.LoginPage)
+	r.POST("/admin/login", loginRateLimit, adminAuthHandler.Login)
+	r.POST("/admin/logout", middleware.AdminAuth(adminUserService, false), adminAuthHandler.Logout)
+
+	// Protected web routes (anonymous visitors are sent to the sign-in page)
 	protected := r.Group("/")
-	protected.Use(middleware.CustomBasicAuth())
+	protected.Use(middleware.AdminAuth(adminUserService, true))
 	{
 		// Individual URL analytics dashboard
-		protected.GET("/dashboard", func(c *gin.Context) {
-			c.HTML(200, "dashboard.html", gin.H{
-				"BaseURL": getBaseURL(),
-			})
-		})
+		protected.GET("/dashboard", adminAuthHandler.Page("dashboard.html"))
 
 		// API Keys Management - MAIN admin page
-		protected.GET("/admin", func(c *gin.Context) {
-			c.HTML(200, "admin.html", gin.H{
-				"BaseURL": getBaseURL(),
-			})
-		})
+		protected.GET("/admin", adminAuthHandler.Page("admin.html"))
 
 		// Alternative route for API keys management (same page)
-		protected.GET("/admin/api-keys", func(c *gin.Context) {
-			c.HTML(200, "admin.html", gin.H{
-				"BaseURL": getBaseURL(),
-			})
-		})
+		protected.GET("/admin/api-keys", adminAuthHandler.Page("admin.html"))
 
 		// Admin Analytics Dashboard
-		protected.GET("/admin/analytics", func(c *gin.Context) {
-			c.HTML(200, "admin-analytics.html", gin.H{
-				"BaseURL": getBaseURL(),
-			})
-		})
+		protected.GET("/admin/analytics", adminAuthHandler.Page("admin-analytics.html"))
+
+		// Admin user management (owners only)
+		protected.GET("/admin/users", owner, adminAuthHandler.Page("admin-users.html"))
 	}
 
 	// Health check
@@ -115,23 +243,13 @@ func main() {
 			"status":    "ok",
 			"service":   "kamero-url-shortener",
 			"version":   "1.0.0",
-			"base_url":  getBaseURL(),
+			"base_url":  cfg.BaseURL,
 			"timestamp": cfg.Port,
 		})
 	})
 
 	// 404 handler for web routes
 	r.NoRoute(func(c *gin.Context) {
-		// If it's an API request, return JSON
-		if gin.IsDebugging() || len(c.Request.URL.Path) > 6 || c.Request.Header.Get("Content-Type") == "application/json" {
-			c.JSON(404, gin.H{
-				"error":   "Not Found",
-				"message": "The requested resource was not found",
-				"service": "kamero-url-shortener",
-			})
-			return
-		}
-
 		// Otherwise, try to handle as short URL redirect
 		code := c.Param("code")
 		if code == "" && len(c.Request.URL.Path) > 1 {
@@ -139,37 +257,60 @@ func main() {
 			code = c.Request.URL.Path[1:] // Remove leading slash
 		}
 
-		if len(code) == 6 {
-			// Try to redirect
-			urlHandler.RedirectURL(c)
-		} else {
+		// If it's an API request or not a possible short code, return JSON
+		if gin.IsDebugging() || !utils.IsValidShortCode(code) || c.Request.Header.Get("Content-Type") == "application/json" {
 			c.JSON(404, gin.H{
 				"error":   "Not Found",
-				"message": "Invalid short URL code",
+				"message": "The requested resource was not found",
 				"service": "kamero-url-shortener",
 			})
+			return
 		}
+
+		// Try to redirect
+		c.Params = append(c.Params, gin.Param{Key: "code", Value: code})
+		urlHandler.RedirectURL(c)
 	})
 
 	// Redirect route - this handles the short URLs (must be last)
 	r.GET("/:code", urlHandler.RedirectURL)
+	r.POST("/:code", urlHandler.UnlockURL)
 
 	log.Printf("🚀 Server starting on port %s", cfg.Port)
 	log.Printf("🌐 Base URL: %s", cfg.BaseURL)
 	log.Printf("💾 Database: %s@%s:%s/%s", cfg.DBUser, cfg.DBHost, cfg.DBPort, cfg.DBName)
-	log.Printf("🔐 Admin username: %s", middleware.GetAdminUsername())
+	log.Printf("🔐 Admin sign-in: %s/admin/login", cfg.BaseURL)
 	log.Printf("📊 Admin Dashboard: %s/admin", cfg.BaseURL)
 	log.Printf("🔑 API Keys Management: %s/admin/api-keys", cfg.BaseURL)
 	log.Printf("📈 Analytics Dashboard: %s/admin/analytics", cfg.BaseURL)
 
-	log.Fatal(r.Run(":" + cfg.Port))
-}
+	srv := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: r,
+	}
+	// Shutdown waits for open connections; end the live click streams so it doesn't have to
+	srv.RegisterOnShutdown(clickHub.Close)
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
 
-// getBaseURL returns the base URL from environment or default
-func getBaseURL() string {
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = "http://localhost:8080"
+	// Wait for an interrupt, then stop accepting requests, drain queued clicks
+	// and let in-flight webhook deliveries finish
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Shutting down server...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server forced to shutdown: %v", err)
 	}
-	return baseURL
+
+	clickPipeline.Close()
+	webhookDispatcher.Close()
+	log.Println("Server exited")
 }
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+
+	"url-shortener/models"
+	"url-shortener/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// AdminSessionCookie holds the session token of a signed-in admin
+	AdminSessionCookie = "admin_session"
+	// CSRFHeader must carry the session's CSRF token on unsafe requests
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// AdminAuth requires a signed-in admin user, from the session cookie or from
+// HTTP Basic auth with the user's own credentials (for scripts). Unsafe
+// requests authenticated by cookie must carry the session's CSRF token.
+// Pages set loginRedirect to send anonymous browsers to the login form.
+// The user is stored as "admin_user", their workspace as "workspace_id" and a
+// cookie session as "admin_session".
+func AdminAuth(userService *services.AdminUserService, loginRedirect bool) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		user, session := authenticateAdmin(c, userService)
+		if user == nil {
+			if loginRedirect {
+				c.Redirect(http.StatusSeeOther, "/admin/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+			} else {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Admin sign-in required"})
+			}
+			c.Abort()
+			return
+		}
+
+		if session != nil && !isSafeMethod(c.Request.Method) && !validCSRFToken(c, session) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Missing or invalid CSRF token"})
+			c.Abort()
+			return
+		}
+
+		c.Set("admin_user", user)
+		c.Set("workspace_id", user.WorkspaceID)
+		if session != nil {
+			c.Set("admin_session", session)
+		}
+		c.Next()
+	})
+}
+
+// OptionalAdminAuth marks requests from signed-in admins with "is_admin" without
+// requiring sign-in, and sets "workspace_id" unless an API key already did.
+// It must only guard safe methods since it skips CSRF checks.
+func OptionalAdminAuth(userService *services.AdminUserService) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		if user, _ := authenticateAdmin(c, userService); user != nil {
+			c.Set("is_admin", true)
+			if contextAPIKey(c) == nil {
+				c.Set("workspace_id", user.WorkspaceID)
+			}
+		}
+		c.Next()
+	})
+}
+
+// RequireRole rejects admin users below role. It must run after AdminAuth.
+func RequireRole(role string) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		user := ContextAdminUser(c)
+		if user == nil || !user.HasRole(role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This action requires the " + role + " role"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	})
+}
+
+// RequirePlatformOwner only lets owners of the default workspace through, who
+// manage the other workspaces. It must run after AdminAuth.
+func RequirePlatformOwner() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		user := ContextAdminUser(c)
+		if user == nil || !user.HasRole(models.RoleOwner) || user.WorkspaceID != models.DefaultWorkspaceID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This action requires an owner of the default workspace"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	})
+}
+
+// ContextAdminUser returns the admin user set by AdminAuth, if any
+func ContextAdminUser(c *gin.Context) *models.AdminUser {
+	value, exists := c.Get("admin_user")
+	if !exists {
+		return nil
+	}
+	user, _ := value.(*models.AdminUser)
+	return user
+}
+
+// ContextAdminSession returns the cookie session set by AdminAuth, nil for Basic auth
+func ContextAdminSession(c *gin.Context) *models.AdminSession {
+	value, exists := c.Get("admin_session")
+	if !exists {
+		return nil
+	}
+	session, _ := value.(*models.AdminSession)
+	return session
+}
+
+// authenticateAdmin checks the session cookie first, then Basic auth
+func authenticateAdmin(c *gin.Context, userService *services.AdminUserService) (*models.AdminUser, *models.AdminSession) {
+	if token, err := c.Cookie(AdminSessionCookie); err == nil && token != "" {
+		if user, session, err := userService.ValidateSession(token); err == nil {
+			return user, session
+		}
+	}
+
+	if username, password, ok := c.Request.BasicAuth(); ok {
+		if user, err := userService.Authenticate(username, password, c.ClientIP()); err == nil {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+// validCSRFToken accepts the token from the X-CSRF-Token header or, for plain
+// HTML forms, a csrf_token form field
+func validCSRFToken(c *gin.Context, session *models.AdminSession) bool {
+	token := c.GetHeader(CSRFHeader)
+	if token == "" {
+		token = c.PostForm("csrf_token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -1,18 +1,17 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
+	"url-shortener/models"
 	"url-shortener/services"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
-func APIKeyAuth(db *gorm.DB) gin.HandlerFunc {
-	apiKeyService := services.NewAPIKeyService(db)
-
+func APIKeyAuth(apiKeyService *services.APIKeyService) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -42,6 +41,11 @@ func APIKeyAuth(db *gorm.DB) gin.HandlerFunc {
 
 		// Validate API key
 		apiKey, err := apiKeyService.ValidateAPIKey(keyID, keySecret)
+		if errors.Is(err, services.ErrTooManyAPIKeyFailures) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid API key attempts"})
+			c.Abort()
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
 			c.Abort()
@@ -51,14 +55,13 @@ func APIKeyAuth(db *gorm.DB) gin.HandlerFunc {
 		// Store API key info in context
 		c.Set("api_key", apiKey)
 		c.Set("api_key_id", apiKey.KeyID)
+		c.Set("workspace_id", apiKey.WorkspaceID)
 		c.Next()
 	})
 }
 
 // Optional API key middleware - allows both authenticated and unauthenticated requests
-func OptionalAPIKeyAuth(db *gorm.DB) gin.HandlerFunc {
-	apiKeyService := services.NewAPIKeyService(db)
-
+func OptionalAPIKeyAuth(apiKeyService *services.APIKeyService) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -76,6 +79,7 @@ func OptionalAPIKeyAuth(db *gorm.DB) gin.HandlerFunc {
 				if err == nil {
 					c.Set("api_key", apiKey)
 					c.Set("api_key_id", apiKey.KeyID)
+					c.Set("workspace_id", apiKey.WorkspaceID)
 				}
 			}
 		}
@@ -87,3 +91,26 @@ func OptionalAPIKeyAuth(db *gorm.DB) gin.HandlerFunc {
 		c.Next()
 	})
 }
+
+// ContextWorkspaceID returns the caller's workspace, set by the API key and
+// admin auth middleware. Anonymous requests belong to the default workspace.
+func ContextWorkspaceID(c *gin.Context) uint {
+	if workspaceID := c.GetUint("workspace_id"); workspaceID != 0 {
+		return workspaceID
+	}
+	return models.DefaultWorkspaceID
+}
+
+// RequireScope rejects requests whose API key lacks the scope. It must run after
+// APIKeyAuth or OptionalAPIKeyAuth; anonymous requests on optional routes pass through.
+func RequireScope(scope string) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		apiKey := contextAPIKey(c)
+		if apiKey != nil && !apiKey.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	})
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"url-shortener/models"
+	"url-shortener/services"
+	"url-shortener/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyRateLimit limits requests per API key. It must run after APIKeyAuth or
+// OptionalAPIKeyAuth; anonymous requests pass through. A key's own RateLimit
+// overrides defaultLimit, and a limit of 0 disables limiting.
+func APIKeyRateLimit(limiter *utils.RateLimiter, defaultLimit int) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		apiKey := contextAPIKey(c)
+		if apiKey == nil {
+			c.Next()
+			return
+		}
+
+		limit := defaultLimit
+		if apiKey.RateLimit > 0 {
+			limit = apiKey.RateLimit
+		}
+		applyRateLimit(c, limiter, "key:"+apiKey.KeyID, limit)
+	})
+}
+
+// AnonymousRateLimit limits requests without an API key per client IP
+func AnonymousRateLimit(limiter *utils.RateLimiter, limit int) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		if contextAPIKey(c) != nil {
+			c.Next()
+			return
+		}
+		applyRateLimit(c, limiter, "ip:"+c.ClientIP(), limit)
+	})
+}
+
+// CreationQuota enforces the daily and monthly URL creation quotas of the
+// calling API key and of the caller's workspace, and stores the remaining
+// quota of the most restrictive one as "quota_remaining"
+func CreationQuota(apiKeyService *services.APIKeyService, workspaceService *services.WorkspaceService) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		now := time.Now()
+		var quota *services.QuotaStatus
+		var message string
+
+		if apiKey := contextAPIKey(c); apiKey != nil {
+			keyQuota, err := apiKeyService.CreationQuota(apiKey, now)
+			if err != nil {
+				// Don't block link creation because the quota couldn't be counted
+				log.Printf("Failed to check quota for API key %s: %v", apiKey.KeyID, err)
+			} else if keyQuota != nil {
+				quota, message = keyQuota, "URL creation quota exceeded"
+			}
+		}
+
+		workspaceID := ContextWorkspaceID(c)
+		workspace, err := workspaceService.GetWorkspace(workspaceID)
+		if err == nil {
+			var workspaceQuota *services.QuotaStatus
+			workspaceQuota, err = workspaceService.CreationQuota(workspace, now)
+			if workspaceQuota != nil && (quota == nil || workspaceQuota.Remaining < quota.Remaining) {
+				quota, message = workspaceQuota, "Workspace URL creation quota exceeded"
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to check quota for workspace %d: %v", workspaceID, err)
+		}
+
+		if quota == nil {
+			c.Next()
+			return
+		}
+
+		c.Header("X-Quota-Limit", strconv.FormatInt(quota.Limit, 10))
+		c.Header("X-Quota-Remaining", strconv.FormatInt(quota.Remaining, 10))
+		c.Header("X-Quota-Reset", strconv.FormatInt(quota.Reset.Unix(), 10))
+
+		if quota.Remaining <= 0 {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(time.Until(quota.Reset))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": message})
+			c.Abort()
+			return
+		}
+
+		c.Set("quota_remaining", quota.Remaining)
+		c.Next()
+	})
+}
+
+// applyRateLimit takes a token for key and sets the RateLimit headers, aborting with 429 when empty
+func applyRateLimit(c *gin.Context, limiter *utils.RateLimiter, key string, limit int) {
+	if limit <= 0 {
+		c.Next()
+		return
+	}
+
+	result := limiter.Take(key, limit)
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+	if !result.Allowed {
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+// contextAPIKey returns the API key set by the auth middleware, if any
+func contextAPIKey(c *gin.Context) *models.APIKey {
+	value, exists := c.Get("api_key")
+	if !exists {
+		return nil
+	}
+	apiKey, _ := value.(*models.APIKey)
+	return apiKey
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
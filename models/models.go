@@ -1,6 +1,10 @@
 package models
 
 import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,75 +12,740 @@ import (
 
 type URL struct {
 	ID                 uint           `json:"id" gorm:"primaryKey"`
-	Code               string         `json:"code" gorm:"uniqueIndex;size:6"`
+	WorkspaceID        uint           `json:"workspace_id" gorm:"not null;default:1;uniqueIndex:idx_urls_workspace_hash,priority:1;uniqueIndex:idx_urls_workspace_code,priority:1"`
+	Code               string         `json:"code" gorm:"size:32;uniqueIndex:idx_urls_domain_code,priority:2;uniqueIndex:idx_urls_workspace_code,priority:2"` // Random 6-char code or custom alias, unique per domain and per workspace
+	IsCustomAlias      bool           `json:"is_custom_alias" gorm:"default:false"`
+	DomainID           uint           `json:"domain_id" gorm:"not null;default:0;uniqueIndex:idx_urls_domain_code,priority:1"` // Host the link is served from; 0 is BASE_URL's
 	OriginalURL        string         `json:"original_url" gorm:"not null;index"`
-	URLHash            string         `json:"url_hash" gorm:"uniqueIndex;size:64"` // SHA256 hash of original URL + platform URLs
+	URLHash            string         `json:"url_hash" gorm:"uniqueIndex:idx_urls_workspace_hash,priority:2;size:64"` // SHA256 hash of original URL + platform URLs, unique per workspace
 	IOSRedirectURL     string         `json:"ios_redirect_url"`
 	AndroidRedirectURL string         `json:"android_redirect_url"`
 	DesktopRedirectURL string         `json:"desktop_redirect_url"`
 	MacRedirectURL     string         `json:"mac_redirect_url"`
 	ClickCount         int64          `json:"click_count" gorm:"default:0"`
+	BotClickCount      int64          `json:"bot_click_count" gorm:"default:0"`
+	ExpiresAt          *time.Time     `json:"expires_at" gorm:"index"`     // Link stops redirecting after this time
+	MaxClicks          int64          `json:"max_clicks" gorm:"default:0"` // Click budget, 0 means unlimited
+	ExpiredRedirectURL string         `json:"expired_redirect_url"`        // Optional fallback once expired
+	ExpiryNotifiedAt   *time.Time     `json:"-"`                           // When the link.expired webhook event was sent
+	PasswordHash       string         `json:"-"`                           // bcrypt hash, empty when the link is public
 	CreatedByAPIKey    string         `json:"created_by_api_key" gorm:"index"`
+	UTM                UTMParams      `json:"utm" gorm:"embedded;embeddedPrefix:utm_"`     // Appended to the destination on redirect
+	CampaignID         uint           `json:"campaign_id" gorm:"not null;default:0;index"` // 0 when the link isn't part of a campaign
+	Rules              []RedirectRule `json:"rules,omitempty" gorm:"serializer:json"`      // Conditional destinations, checked in order before the platform URLs
+	Variants           []LinkVariant  `json:"variants,omitempty" gorm:"serializer:json"`   // A/B split destinations replacing OriginalURL
+	Preview            LinkPreview    `json:"preview" gorm:"embedded;embeddedPrefix:og_"`
 	CreatedAt          time.Time      `json:"created_at"`
 	UpdatedAt          time.Time      `json:"updated_at"`
 	DeletedAt          gorm.DeletedAt `json:"deleted_at" gorm:"index"`
 }
 
+// Clone returns a deep copy of the URL, sharing no slices, maps or pointers
+// with it
+func (u *URL) Clone() *URL {
+	clone := *u
+	clone.ExpiresAt = clonePtr(u.ExpiresAt)
+	clone.ExpiryNotifiedAt = clonePtr(u.ExpiryNotifiedAt)
+	clone.Variants = slices.Clone(u.Variants)
+	if u.Rules != nil {
+		clone.Rules = make([]RedirectRule, len(u.Rules))
+		for i, rule := range u.Rules {
+			clone.Rules[i] = rule.clone()
+		}
+	}
+	return &clone
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	clone := *p
+	return &clone
+}
+
 type Click struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	URLCode   string    `json:"url_code" gorm:"index"`
-	IPAddress string    `json:"ip_address"`
-	UserAgent string    `json:"user_agent"`
-	Platform  string    `json:"platform"`
-	Browser   string    `json:"browser"`
-	OS        string    `json:"os"`
-	Country   string    `json:"country"`
-	City      string    `json:"city"`
-	Referrer  string    `json:"referrer"`
-	ClickedAt time.Time `json:"clicked_at"`
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	WorkspaceID    uint      `json:"workspace_id" gorm:"not null;default:1;index"` // Copied from the URL so analytics don't need a join
+	URLCode        string    `json:"url_code" gorm:"index"`
+	IPAddress      string    `json:"ip_address"`
+	UserAgent      string    `json:"user_agent"`
+	Platform       string    `json:"platform"`
+	Device         string    `json:"device" gorm:"size:16"` // phone, tablet, desktop, tv or bot
+	Browser        string    `json:"browser"`
+	BrowserVersion string    `json:"browser_version" gorm:"size:32"`
+	OS             string    `json:"os"`
+	OSVersion      string    `json:"os_version" gorm:"size:32"`
+	CountryCode    string    `json:"country_code" gorm:"size:2"` // ISO 3166-1 alpha-2
+	Country        string    `json:"country"`
+	Region         string    `json:"region"`
+	City           string    `json:"city"`
+	ASN            uint      `json:"asn"`
+	ASOrg          string    `json:"as_org"`
+	Referrer       string    `json:"referrer"`
+	Variant        string    `json:"variant,omitempty" gorm:"size:32"` // Name of the A/B variant the visitor was sent to
+	IsBot          bool      `json:"is_bot" gorm:"not null;default:false;index"`
+	BotName        string    `json:"bot_name,omitempty" gorm:"size:64"`
+	ClickedAt      time.Time `json:"clicked_at"`
+	ClickID        string    `json:"click_id,omitempty" gorm:"size:32;uniqueIndex"` // Random ID given on enqueue, so replaying the WAL can't store a click twice
+}
+
+// GeoLocation is the result of resolving a click's IP address
+type GeoLocation struct {
+	CountryCode string `json:"country_code"`
+	Country     string `json:"country"`
+	Region      string `json:"region"`
+	City        string `json:"city"`
+	ASN         uint   `json:"asn"`
+	ASOrg       string `json:"as_org"`
 }
 
 type APIKey struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	KeyID       string         `json:"key_id" gorm:"uniqueIndex;size:20"`
-	KeySecret   string         `json:"key_secret" gorm:"size:64"` // Hashed
-	Name        string         `json:"name" gorm:"not null"`
-	Description string         `json:"description"`
-	IsActive    bool           `json:"is_active" gorm:"default:true"`
-	LastUsedAt  *time.Time     `json:"last_used_at"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	WorkspaceID  uint       `json:"workspace_id" gorm:"not null;default:1;index"`
+	KeyID        string     `json:"key_id" gorm:"uniqueIndex;size:20"`
+	KeySecret    string     `json:"-" gorm:"size:255"` // Hashed, see utils.SecretHasher
+	Name         string     `json:"name" gorm:"not null"`
+	Description  string     `json:"description"`
+	IsActive     bool       `json:"is_active" gorm:"default:true"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	ExpiresAt    *time.Time `json:"expires_at" gorm:"index"` // Nil never expires
+	RateLimit    int        `json:"rate_limit"`              // Requests per minute; 0 uses the server default
+	DailyQuota   int64      `json:"daily_quota"`             // URLs created per UTC day; 0 is unlimited
+	MonthlyQuota int64      `json:"monthly_quota"`           // URLs created per UTC month; 0 is unlimited
+	// Secret replaced by the last rotation, accepted until PreviousSecretExpiresAt
+	PreviousKeySecret       string     `json:"-" gorm:"size:255"`
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	// Scopes granted to the key; nil (keys created before scopes existed) grants all of them
+	Scopes []string `json:"scopes" gorm:"serializer:json"`
+	// Hosts the key may shorten links to (subdomains included); empty allows any destination
+	AllowedDomains []string       `json:"allowed_domains" gorm:"serializer:json"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// API key scopes
+const (
+	ScopeLinksWrite    = "links:write"    // Create and update links
+	ScopeLinksRead     = "links:read"     // List and view own links
+	ScopeLinksDelete   = "links:delete"   // Delete and restore own links
+	ScopeAnalyticsRead = "analytics:read" // Read analytics of own links
+)
+
+// AllScopes is granted to keys created without an explicit scope list
+var AllScopes = []string{ScopeLinksWrite, ScopeLinksRead, ScopeLinksDelete, ScopeAnalyticsRead}
+
+// HasScope reports whether the key was granted the scope
+func (k *APIKey) HasScope(scope string) bool {
+	if k.Scopes == nil {
+		return true
+	}
+	for _, granted := range k.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsHost reports whether the key may create links pointing at host
+func (k *APIKey) AllowsHost(host string) bool {
+	if len(k.AllowedDomains) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range k.AllowedDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// QRLogo is an admin-uploaded image that can be placed in the centre of QR codes
+type QRLogo struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	WorkspaceID uint      `json:"workspace_id" gorm:"not null;default:1;uniqueIndex:idx_qr_logos_workspace_name,priority:1"`
+	Name        string    `json:"name" gorm:"uniqueIndex:idx_qr_logos_workspace_name,priority:2;size:64"`
+	ContentType string    `json:"content_type"`
+	Data        []byte    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Workspace is a tenant, such as one client brand. Every link, click, API key,
+// QR logo, admin user and audit entry belongs to exactly one workspace and is
+// never visible from another.
+type Workspace struct {
+	ID            uint   `json:"id" gorm:"primaryKey"`
+	Slug          string `json:"slug" gorm:"uniqueIndex;size:64"`
+	Name          string `json:"name" gorm:"not null"`
+	DefaultDomain string `json:"default_domain"` // Host of new links, once verified as one of the workspace's domains; empty uses BASE_URL
+	DailyQuota    int64  `json:"daily_quota"`    // URLs created per UTC day across the workspace; 0 is unlimited
+	MonthlyQuota  int64  `json:"monthly_quota"`  // URLs created per UTC month across the workspace; 0 is unlimited
+	// Branding of the password and expired link pages; empty fields use the defaults
+	BrandName    string    `json:"brand_name"`
+	BrandLogoURL string    `json:"brand_logo_url"`
+	BrandColor   string    `json:"brand_color" gorm:"size:9"` // CSS hex colour, e.g. #6f4898
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// UTMParams are the UTM tags of a link. They are stored apart from the
+// destination and added to its query string when visitors are redirected.
+type UTMParams struct {
+	Source   string `json:"source,omitempty" gorm:"size:255;index:idx_urls_utm_source" binding:"max=255"`
+	Medium   string `json:"medium,omitempty" gorm:"size:255;index:idx_urls_utm_medium" binding:"max=255"`
+	Campaign string `json:"campaign,omitempty" gorm:"size:255;index:idx_urls_utm_campaign" binding:"max=255"`
+	Term     string `json:"term,omitempty" gorm:"size:255" binding:"max=255"`
+	Content  string `json:"content,omitempty" gorm:"size:255" binding:"max=255"`
+}
+
+// LinkPreview is the Open Graph card of a link. Social networks' crawlers are
+// shown these tags instead of following the redirect to the destination's.
+type LinkPreview struct {
+	Title       string `json:"title,omitempty" gorm:"size:300" binding:"max=300"`
+	Description string `json:"description,omitempty" gorm:"size:1000" binding:"max=1000"`
+	Image       string `json:"image,omitempty" gorm:"size:2048" binding:"omitempty,http_url,max=2048"` // Absolute http(s) URL
+}
+
+// IsSet reports whether any of the preview's tags is set
+func (p *LinkPreview) IsSet() bool {
+	return p.Title != "" || p.Description != "" || p.Image != ""
+}
+
+// RedirectRule sends visitors who meet every one of its conditions to URL;
+// conditions left empty match anyone. A link's rules are checked in order and
+// the first match wins. Visitors no rule matches go to the original URL.
+type RedirectRule struct {
+	URL       string            `json:"url" binding:"required,url"`
+	Countries []string          `json:"countries,omitempty" binding:"omitempty,max=250,dive,len=2,alpha"`         // ISO 3166-1 alpha-2 codes; needs GeoIP
+	Languages []string          `json:"languages,omitempty" binding:"omitempty,max=50,dive,min=2,max=35"`         // Tags of the preferred Accept-Language, e.g. "de" or "pt-BR"
+	Devices   []string          `json:"devices,omitempty" binding:"omitempty,dive,oneof=ios android mac desktop"` // Platforms as detected from the user agent
+	Browsers  []string          `json:"browsers,omitempty" binding:"omitempty,max=20,dive,min=1,max=32"`          // Browser names, e.g. "Chrome"
+	Referrers []string          `json:"referrers,omitempty" binding:"omitempty,max=50,dive,fqdn"`                 // Referrer hosts, subdomains included
+	Query     map[string]string `json:"query,omitempty" binding:"omitempty,max=10"`                               // Short URL query parameters; an empty value matches any value
+	Schedule  *RuleSchedule     `json:"schedule,omitempty"`
+}
+
+func (r RedirectRule) clone() RedirectRule {
+	r.Countries = slices.Clone(r.Countries)
+	r.Languages = slices.Clone(r.Languages)
+	r.Devices = slices.Clone(r.Devices)
+	r.Browsers = slices.Clone(r.Browsers)
+	r.Referrers = slices.Clone(r.Referrers)
+	r.Query = maps.Clone(r.Query)
+	if r.Schedule != nil {
+		schedule := *r.Schedule
+		schedule.Days = slices.Clone(schedule.Days)
+		r.Schedule = &schedule
+	}
+	return r
+}
+
+// RuleSchedule is a weekly time window. Windows ending before they start run
+// past midnight and belong to the day they start on.
+type RuleSchedule struct {
+	Days     []string `json:"days,omitempty" binding:"omitempty,dive,oneof=mon tue wed thu fri sat sun"` // Empty means every day
+	Start    string   `json:"start,omitempty" binding:"omitempty,datetime=15:04"`                        // Inclusive, defaults to 00:00
+	End      string   `json:"end,omitempty" binding:"omitempty,datetime=15:04"`                          // Exclusive, defaults to midnight
+	Timezone string   `json:"timezone,omitempty" binding:"omitempty,timezone"`                           // IANA name, defaults to UTC
+}
+
+// LinkVariant is one weighted destination of an A/B split. A link with
+// variants sends each visitor to one of them, in proportion to the weights,
+// and keeps sending returning visitors to the same one.
+type LinkVariant struct {
+	Name   string `json:"name" binding:"required,max=32"` // Letters, digits, '-' and '_', unique within the link
+	URL    string `json:"url" binding:"required,url"`
+	Weight int    `json:"weight" binding:"required,min=1,max=1000"`
+}
+
+// Campaign groups links, typically sharing UTM tags, for combined analytics
+type Campaign struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	WorkspaceID uint      `json:"workspace_id" gorm:"not null;default:1;uniqueIndex:idx_campaigns_workspace_name,priority:1"`
+	Name        string    `json:"name" gorm:"size:255;uniqueIndex:idx_campaigns_workspace_name,priority:2"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Domain is a host a workspace serves short links from, such as go.brand-a.com.
+// It is only used for links and redirects once a DNS TXT record proves the
+// workspace controls it.
+type Domain struct {
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	WorkspaceID       uint       `json:"workspace_id" gorm:"not null;uniqueIndex:idx_domains_workspace_host,priority:1"`
+	Host              string     `json:"host" gorm:"size:253;uniqueIndex:idx_domains_workspace_host,priority:2;uniqueIndex:idx_domains_verified_host,where:verified_at IS NOT NULL"`
+	VerificationToken string     `json:"verification_token" gorm:"size:64"` // Expected value of the TXT record
+	VerifiedAt        *time.Time `json:"verified_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// Webhook receives signed POSTs about the links an API key created and their
+// clicks. Deliveries are queued in webhook_deliveries and retried with
+// exponential backoff; ones that run out of attempts go to webhook_dead_letters.
+type Webhook struct {
+	ID          uint     `json:"id" gorm:"primaryKey"`
+	WorkspaceID uint     `json:"workspace_id" gorm:"not null;default:1;index"`
+	APIKeyID    string   `json:"api_key_id" gorm:"size:20;index"` // Key ID whose links trigger events
+	URL         string   `json:"url" gorm:"not null"`
+	Secret      string   `json:"-" gorm:"size:64"` // HMAC-SHA256 signing key, only shown when created
+	Events      []string `json:"events" gorm:"serializer:json"`
+	// Share of clicks sent as click.recorded events, from 0 (none) to 1 (every click)
+	ClickSampleRate float64   `json:"click_sample_rate"`
+	IncludeBots     bool      `json:"include_bots" gorm:"not null;default:false"` // Also send bot clicks as click.recorded events
+	IsActive        bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Webhook events
+const (
+	EventLinkCreated   = "link.created"
+	EventLinkUpdated   = "link.updated"
+	EventLinkDeleted   = "link.deleted"
+	EventLinkExpired   = "link.expired"
+	EventClickRecorded = "click.recorded"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to
+var WebhookEvents = []string{EventLinkCreated, EventLinkUpdated, EventLinkDeleted, EventLinkExpired, EventClickRecorded}
+
+// Subscribes reports whether the webhook receives event
+func (w *Webhook) Subscribes(event string) bool {
+	for _, subscribed := range w.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event sent to one webhook, and the log of its attempts
+type WebhookDelivery struct {
+	ID             uint            `json:"id" gorm:"primaryKey"`
+	WorkspaceID    uint            `json:"workspace_id" gorm:"not null;default:1;index"`
+	WebhookID      uint            `json:"webhook_id" gorm:"index"`
+	Event          string          `json:"event" gorm:"size:32"`
+	Payload        json.RawMessage `json:"payload" gorm:"type:jsonb"`
+	Status         string          `json:"status" gorm:"size:16;index:idx_webhook_deliveries_due,priority:1"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" gorm:"index:idx_webhook_deliveries_due,priority:2"`
+	LastStatusCode int             `json:"last_status_code"` // 0 when the endpoint couldn't be reached
+	LastError      string          `json:"last_error"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	CreatedAt      time.Time       `json:"created_at" gorm:"index"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed" // Out of attempts, moved to the dead-letter table
+)
+
+// WebhookDeadLetter keeps a delivery that failed every attempt until it is
+// retried or the webhook is deleted
+type WebhookDeadLetter struct {
+	ID             uint            `json:"id" gorm:"primaryKey"`
+	WorkspaceID    uint            `json:"workspace_id" gorm:"not null;default:1;index"`
+	WebhookID      uint            `json:"webhook_id" gorm:"index"`
+	DeliveryID     uint            `json:"delivery_id"`
+	Event          string          `json:"event" gorm:"size:32"`
+	Payload        json.RawMessage `json:"payload" gorm:"type:jsonb"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code"`
+	LastError      string          `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// DefaultWorkspaceID is the workspace of anonymous links and of everything
+// created before workspaces existed. Its owners manage the other workspaces.
+const DefaultWorkspaceID = 1
+
+// AdminUser is a person who can sign in to the admin dashboard and API
+type AdminUser struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	WorkspaceID  uint       `json:"workspace_id" gorm:"not null;default:1;index"`
+	Username     string     `json:"username" gorm:"uniqueIndex;size:64"`
+	PasswordHash string     `json:"-"` // bcrypt
+	Role         string     `json:"role" gorm:"size:16"`
+	IsActive     bool       `json:"is_active" gorm:"default:true"`
+	LastLoginAt  *time.Time `json:"last_login_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// AdminSession is a signed-in browser. Only a hash of the cookie token is stored.
+type AdminSession struct {
+	ID          uint   `gorm:"primaryKey"`
+	TokenHash   string `gorm:"uniqueIndex;size:64"` // SHA-256 hex of the session cookie
+	CSRFToken   string `gorm:"size:64"`             // Sent back in X-CSRF-Token on unsafe requests
+	AdminUserID uint   `gorm:"index"`
+	IPAddress   string
+	UserAgent   string
+	ExpiresAt   time.Time `gorm:"index"`
+	CreatedAt   time.Time
+}
+
+// Admin roles, from least to most privileged
+const (
+	RoleViewer = "viewer" // Read dashboards, analytics and API key lists
+	RoleEditor = "editor" // Also manage links, API keys and QR logos
+	RoleOwner  = "owner"  // Also manage admin users
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+// HasRole reports whether the user's role is role or a more privileged one
+func (u *AdminUser) HasRole(role string) bool {
+	return roleRank[u.Role] >= roleRank[role] && roleRank[role] > 0
+}
+
+// AuditLog records one change made through the admin or public API. Rows are
+// append-only: the table rejects updates and deletes (see config.InitDB).
+type AuditLog struct {
+	ID           uint                   `json:"id" gorm:"primaryKey"`
+	WorkspaceID  uint                   `json:"workspace_id" gorm:"not null;default:1;index"`
+	Action       string                 `json:"action" gorm:"size:32;index"`
+	ActorType    string                 `json:"actor_type" gorm:"size:16;index:idx_audit_logs_actor"` // admin, api_key or anonymous
+	ActorID      string                 `json:"actor_id" gorm:"size:64;index:idx_audit_logs_actor"`   // Admin username or API key ID
+	IPAddress    string                 `json:"ip_address"`
+	ResourceType string                 `json:"resource_type" gorm:"size:32;index:idx_audit_logs_resource"`
+	ResourceID   string                 `json:"resource_id" gorm:"size:64;index:idx_audit_logs_resource"`
+	Before       map[string]interface{} `json:"before,omitempty" gorm:"type:jsonb;serializer:json"` // Changed fields before the change
+	After        map[string]interface{} `json:"after,omitempty" gorm:"type:jsonb;serializer:json"`  // Changed fields after the change
+	CreatedAt    time.Time              `json:"created_at" gorm:"index"`
+}
+
+// Audit actor types
+const (
+	ActorAdmin     = "admin"
+	ActorAPIKey    = "api_key"
+	ActorAnonymous = "anonymous"
+)
+
+// Audit actions
+const (
+	AuditURLCreate           = "url.create"
+	AuditURLUpdate           = "url.update"
+	AuditURLDelete           = "url.delete"
+	AuditURLRestore          = "url.restore"
+	AuditURLBulkDelete       = "url.bulk_delete"
+	AuditAPIKeyCreate        = "api_key.create"
+	AuditAPIKeyUpdate        = "api_key.update"
+	AuditAPIKeyRotate        = "api_key.rotate"
+	AuditAPIKeyDeactivate    = "api_key.deactivate"
+	AuditAdminLogin          = "admin.login"
+	AuditAdminLoginFailed    = "admin.login_failed"
+	AuditAdminLogout         = "admin.logout"
+	AuditAdminPasswordChange = "admin.password_change"
+	AuditAdminUserCreate     = "admin_user.create"
+	AuditAdminUserUpdate     = "admin_user.update"
+	AuditAdminUserDelete     = "admin_user.delete"
+	AuditQRLogoUpload        = "qr_logo.upload"
+	AuditQRLogoDelete        = "qr_logo.delete"
+	AuditWorkspaceCreate     = "workspace.create"
+	AuditWorkspaceUpdate     = "workspace.update"
+	AuditDomainCreate        = "domain.create"
+	AuditDomainVerify        = "domain.verify"
+	AuditDomainDelete        = "domain.delete"
+	AuditWebhookCreate       = "webhook.create"
+	AuditWebhookUpdate       = "webhook.update"
+	AuditWebhookDelete       = "webhook.delete"
+	AuditCampaignCreate      = "campaign.create"
+	AuditCampaignUpdate      = "campaign.update"
+	AuditCampaignDelete      = "campaign.delete"
+)
+
+// Tables returns the models of every database table, in the order they are
+// migrated so that foreign keys can refer to tables created before them
+func Tables() []interface{} {
+	return []interface{}{&Workspace{}, &Domain{}, &Campaign{}, &URL{}, &Click{}, &APIKey{}, &QRLogo{}, &AdminUser{}, &AdminSession{}, &AuditLog{}, &Webhook{}, &WebhookDelivery{}, &WebhookDeadLetter{}}
 }
 
 // Request/Response models
 type ShortenRequest struct {
-	URL                string `json:"url" binding:"required,url"`
-	IOSRedirectURL     string `json:"ios_redirect_url"`
-	AndroidRedirectURL string `json:"android_redirect_url"`
-	DesktopRedirectURL string `json:"desktop_redirect_url"`
-	MacRedirectURL     string `json:"mac_redirect_url"`
+	URL                string     `json:"url" binding:"required,url"`
+	IOSRedirectURL     string     `json:"ios_redirect_url"`
+	AndroidRedirectURL string     `json:"android_redirect_url"`
+	DesktopRedirectURL string     `json:"desktop_redirect_url"`
+	MacRedirectURL     string     `json:"mac_redirect_url"`
+	Alias              string     `json:"alias"` // Optional custom short code, e.g. "spring-sale"
+	ExpiresAt          *time.Time `json:"expires_at"`
+	MaxClicks          int64      `json:"max_clicks" binding:"omitempty,min=0"`
+	ExpiredRedirectURL string     `json:"expired_redirect_url" binding:"omitempty,url"`
+	Password           string     `json:"password" binding:"omitempty,min=4,max=72"` // Visitors must enter it before being redirected
+	Domain             string     `json:"domain" binding:"omitempty,fqdn"`           // Verified workspace domain to serve the link from; defaults to the workspace's default domain
+	// UTM tags added on redirect. utm_* parameters already in url are moved here
+	// (fields set in the request win), so they don't make otherwise equal links distinct.
+	UTM        UTMParams      `json:"utm"`
+	CampaignID uint           `json:"campaign_id"`                              // Campaign of the workspace to group the link under
+	Rules      []RedirectRule `json:"rules" binding:"omitempty,max=20,dive"`    // Conditional destinations, checked in order
+	Variants   []LinkVariant  `json:"variants" binding:"omitempty,max=10,dive"` // A/B split destinations, at least 2
+	Preview    LinkPreview    `json:"preview"`                                  // Open Graph tags shown to social crawlers
 }
 
 type ShortenResponse struct {
-	Code        string `json:"code"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	IsNew       bool   `json:"is_new"` // Indicates if this is a new URL or existing one
+	Code        string     `json:"code"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	IsNew       bool       `json:"is_new"` // Indicates if this is a new URL or existing one
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	MaxClicks   int64      `json:"max_clicks,omitempty"`
+	Protected   bool       `json:"password_protected,omitempty"`
+}
+
+// BatchShortenResult is the outcome of one item of a batch shorten request.
+// Index is the item's position in the request (row number minus one for CSV).
+type BatchShortenResult struct {
+	Index       int    `json:"index"`
+	Code        string `json:"code,omitempty"`
+	ShortURL    string `json:"short_url,omitempty"`
+	OriginalURL string `json:"original_url,omitempty"`
+	IsNew       bool   `json:"is_new"`
+	Error       string `json:"error,omitempty"`
+}
+
+type BatchShortenResponse struct {
+	Results  []BatchShortenResult `json:"results"`
+	Total    int                  `json:"total"`
+	Created  int                  `json:"created"`
+	Existing int                  `json:"existing"`
+	Failed   int                  `json:"failed"`
+}
+
+// UpdateURLRequest is a partial update of a link's redirect targets, rules, UTM
+// tags, A/B variants and preview. Omitted fields are left unchanged; an empty
+// platform URL removes it, and rules, utm, variants and preview replace the
+// whole list or set.
+type UpdateURLRequest struct {
+	URL                *string         `json:"url" binding:"omitempty,url"`
+	IOSRedirectURL     *string         `json:"ios_redirect_url"`
+	AndroidRedirectURL *string         `json:"android_redirect_url"`
+	DesktopRedirectURL *string         `json:"desktop_redirect_url"`
+	MacRedirectURL     *string         `json:"mac_redirect_url"`
+	UTM                *UTMParams      `json:"utm"`
+	Rules              *[]RedirectRule `json:"rules" binding:"omitempty,max=20,dive"`    // An empty list removes every rule
+	Variants           *[]LinkVariant  `json:"variants" binding:"omitempty,max=10,dive"` // An empty list removes the split
+	Preview            *LinkPreview    `json:"preview"`                                  // An empty object removes the preview
+}
+
+// URLDetailResponse describes a link to the API key that owns it
+type URLDetailResponse struct {
+	Code               string         `json:"code"`
+	ShortURL           string         `json:"short_url"`
+	OriginalURL        string         `json:"original_url"`
+	IOSRedirectURL     string         `json:"ios_redirect_url"`
+	AndroidRedirectURL string         `json:"android_redirect_url"`
+	DesktopRedirectURL string         `json:"desktop_redirect_url"`
+	MacRedirectURL     string         `json:"mac_redirect_url"`
+	ClickCount         int64          `json:"click_count"`
+	BotClickCount      int64          `json:"bot_click_count"`
+	ExpiresAt          *time.Time     `json:"expires_at,omitempty"`
+	MaxClicks          int64          `json:"max_clicks,omitempty"`
+	ExpiredRedirectURL string         `json:"expired_redirect_url,omitempty"`
+	Protected          bool           `json:"password_protected"`
+	UTM                UTMParams      `json:"utm"`
+	CampaignID         uint           `json:"campaign_id,omitempty"`
+	Rules              []RedirectRule `json:"rules,omitempty"`
+	Variants           []LinkVariant  `json:"variants,omitempty"`
+	Preview            *LinkPreview   `json:"preview,omitempty"`
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	DeletedAt          *time.Time     `json:"deleted_at,omitempty"`
 }
 
 type APIKeyRequest struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description"`
+	Name         string     `json:"name" binding:"required"`
+	Description  string     `json:"description"`
+	RateLimit    int        `json:"rate_limit" binding:"omitempty,min=0"`
+	DailyQuota   int64      `json:"daily_quota" binding:"omitempty,min=0"`
+	MonthlyQuota int64      `json:"monthly_quota" binding:"omitempty,min=0"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	// Omitted scopes grant all of them
+	Scopes         []string `json:"scopes" binding:"omitempty,min=1,dive,oneof=links:write links:read links:delete analytics:read"`
+	AllowedDomains []string `json:"allowed_domains" binding:"omitempty,dive,fqdn"`
+}
+
+// APIKeyUpdateRequest changes a key's permissions and limits; omitted fields are unchanged
+type APIKeyUpdateRequest struct {
+	RateLimit      *int       `json:"rate_limit" binding:"omitempty,min=0"`
+	DailyQuota     *int64     `json:"daily_quota" binding:"omitempty,min=0"`
+	MonthlyQuota   *int64     `json:"monthly_quota" binding:"omitempty,min=0"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	Scopes         *[]string  `json:"scopes" binding:"omitempty,min=1,dive,oneof=links:write links:read links:delete analytics:read"`
+	AllowedDomains *[]string  `json:"allowed_domains" binding:"omitempty,dive,fqdn"`
 }
 
 type APIKeyResponse struct {
-	KeyID       string    `json:"key_id"`
-	KeySecret   string    `json:"key_secret"` // Only returned on creation
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	IsActive    bool      `json:"is_active"`
-	CreatedAt   time.Time `json:"created_at"`
+	KeyID          string     `json:"key_id"`
+	KeySecret      string     `json:"key_secret,omitempty"` // Only returned on creation and rotation
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	IsActive       bool       `json:"is_active"`
+	RateLimit      int        `json:"rate_limit"`
+	DailyQuota     int64      `json:"daily_quota"`
+	MonthlyQuota   int64      `json:"monthly_quota"`
+	Scopes         []string   `json:"scopes"`
+	AllowedDomains []string   `json:"allowed_domains"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	// Set after a rotation while the old secret is still accepted
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	LastUsedAt              *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt               time.Time  `json:"created_at"`
+}
+
+// RotateAPIKeyRequest issues a new secret. The old one keeps working for
+// GracePeriod (a Go duration such as "24h"); "0s" revokes it immediately.
+type RotateAPIKeyRequest struct {
+	GracePeriod *string `json:"grace_period"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username" form:"username" binding:"required"`
+	Password string `json:"password" form:"password" binding:"required"`
+}
+
+type AdminUserRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=64"`
+	Password string `json:"password" binding:"required,min=10,max=72"`
+	Role     string `json:"role" binding:"required,oneof=owner editor viewer"`
+}
+
+// AdminUserUpdateRequest changes another admin's role, status or password; omitted fields are unchanged
+type AdminUserUpdateRequest struct {
+	Password *string `json:"password" binding:"omitempty,min=10,max=72"`
+	Role     *string `json:"role" binding:"omitempty,oneof=owner editor viewer"`
+	IsActive *bool   `json:"is_active"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=10,max=72"`
+}
+
+// WorkspaceRequest creates a workspace together with its first owner
+type WorkspaceRequest struct {
+	Slug          string `json:"slug" binding:"required,min=2,max=64"`
+	Name          string `json:"name" binding:"required,max=128"`
+	DefaultDomain string `json:"default_domain" binding:"omitempty,fqdn"`
+	DailyQuota    int64  `json:"daily_quota" binding:"omitempty,min=0"`
+	MonthlyQuota  int64  `json:"monthly_quota" binding:"omitempty,min=0"`
+	BrandName     string `json:"brand_name" binding:"omitempty,max=64"`
+	BrandLogoURL  string `json:"brand_logo_url" binding:"omitempty,url"`
+	BrandColor    string `json:"brand_color" binding:"omitempty,hexcolor"`
+	OwnerUsername string `json:"owner_username" binding:"required,min=3,max=64"`
+	OwnerPassword string `json:"owner_password" binding:"required,min=10,max=72"`
+}
+
+// WorkspaceUpdateRequest changes a workspace's settings; omitted fields are
+// unchanged and an empty string clears a domain or branding field
+type WorkspaceUpdateRequest struct {
+	Name          *string `json:"name" binding:"omitempty,min=1,max=128"`
+	DefaultDomain *string `json:"default_domain" binding:"omitempty,len=0|fqdn"`
+	DailyQuota    *int64  `json:"daily_quota" binding:"omitempty,min=0"`
+	MonthlyQuota  *int64  `json:"monthly_quota" binding:"omitempty,min=0"`
+	BrandName     *string `json:"brand_name" binding:"omitempty,max=64"`
+	BrandLogoURL  *string `json:"brand_logo_url" binding:"omitempty,len=0|url"`
+	BrandColor    *string `json:"brand_color" binding:"omitempty,len=0|hexcolor"`
+}
+
+// CampaignRequest creates a campaign
+type CampaignRequest struct {
+	Name        string `json:"name" binding:"required,max=255"`
+	Description string `json:"description" binding:"max=1000"`
+}
+
+// CampaignUpdateRequest changes a campaign; omitted fields are unchanged
+type CampaignUpdateRequest struct {
+	Name        *string `json:"name" binding:"omitempty,min=1,max=255"`
+	Description *string `json:"description" binding:"omitempty,max=1000"`
+}
+
+// DomainRequest adds a custom domain to a workspace
+type DomainRequest struct {
+	Host string `json:"host" binding:"required,fqdn,max=253"`
+}
+
+// DomainResponse is a domain with the DNS record that proves control of it
+type DomainResponse struct {
+	Domain
+	VerificationRecord DNSRecord `json:"verification_record"`
+}
+
+type DNSRecord struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// WebhookRequest subscribes a URL to events of an API key's links
+type WebhookRequest struct {
+	URL             string   `json:"url" binding:"required,url,max=2048"`
+	Events          []string `json:"events" binding:"required,min=1,dive,oneof=link.created link.updated link.deleted link.expired click.recorded"`
+	ClickSampleRate *float64 `json:"click_sample_rate" binding:"omitempty,min=0,max=1"` // Defaults to 1
+	IncludeBots     bool     `json:"include_bots"`
+}
+
+// WebhookUpdateRequest changes a webhook; omitted fields are unchanged
+type WebhookUpdateRequest struct {
+	URL             *string   `json:"url" binding:"omitempty,url,max=2048"`
+	Events          *[]string `json:"events" binding:"omitempty,min=1,dive,oneof=link.created link.updated link.deleted link.expired click.recorded"`
+	ClickSampleRate *float64  `json:"click_sample_rate" binding:"omitempty,min=0,max=1"`
+	IncludeBots     *bool     `json:"include_bots"`
+	IsActive        *bool     `json:"is_active"`
+}
+
+// WebhookCreatedResponse is returned once when a webhook is created, with its signing secret
+type WebhookCreatedResponse struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookEvent is the JSON body POSTed to webhooks
+type WebhookEvent struct {
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// WebhookClicks is the data of a click.recorded event
+type WebhookClicks struct {
+	Clicks []Click `json:"clicks"`
+}
+
+// WebhookDeliveriesResponse is a page of a webhook's delivery log
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Total      int64             `json:"total"`
+	Page       int               `json:"page"`
+	Limit      int               `json:"limit"`
+	TotalPages int               `json:"total_pages"`
+}
+
+// AuditFilter narrows an audit log listing; empty fields match everything
+type AuditFilter struct {
+	Action       string
+	ActorType    string
+	ActorID      string
+	ResourceType string
+	ResourceID   string
+	Since        *time.Time
+	Until        *time.Time
+}
+
+type AuditLogResponse struct {
+	Entries    []AuditLog `json:"entries"`
+	Total      int64      `json:"total"`
+	Page       int        `json:"page"`
+	Limit      int        `json:"limit"`
+	TotalPages int        `json:"total_pages"`
 }
 
 // Analytics models
@@ -84,20 +753,52 @@ type AnalyticsResponse struct {
 	Code          string           `json:"code"`
 	OriginalURL   string           `json:"original_url"`
 	ClickCount    int64            `json:"click_count"`
+	BotClickCount int64            `json:"bot_click_count"`
 	CreatedAt     time.Time        `json:"created_at"`
 	PlatformStats map[string]int64 `json:"platform_stats"`
 	BrowserStats  map[string]int64 `json:"browser_stats"`
 	OSStats       map[string]int64 `json:"os_stats"`
+	DeviceStats   map[string]int64 `json:"device_stats"`
 	RecentClicks  []Click          `json:"recent_clicks"`
+	VariantStats  map[string]int64 `json:"variant_stats,omitempty"` // Clicks per A/B variant
+	BotStats      map[string]int64 `json:"bot_stats"`               // Clicks per bot name, whether or not bots are included
 	GeoStats      map[string]int64 `json:"geo_stats,omitempty"`
 	ReferrerStats map[string]int64 `json:"referrer_stats,omitempty"`
 	HourlyTrends  []HourlyTrend    `json:"hourly_trends,omitempty"`
 	DailyTrends   []DailyTrend     `json:"daily_trends,omitempty"`
 }
 
+// CampaignAnalytics combines the clicks of every link in a campaign, deleted
+// links included
+type CampaignAnalytics struct {
+	Campaign      Campaign         `json:"campaign"`
+	TotalLinks    int64            `json:"total_links"`
+	TotalClicks   int64            `json:"total_clicks"`
+	PlatformStats map[string]int64 `json:"platform_stats"`
+	GeoStats      map[string]int64 `json:"geo_stats"`
+	ReferrerStats map[string]int64 `json:"referrer_stats"` // Top 10
+	SourceStats   map[string]int64 `json:"source_stats"`   // Clicks per utm_source
+	MediumStats   map[string]int64 `json:"medium_stats"`   // Clicks per utm_medium
+	DailyTrends   []DailyTrend     `json:"daily_trends"`
+	TopLinks      []CampaignLink   `json:"top_links"` // 10 most clicked
+}
+
+// CampaignLink is one link of a campaign with its click count
+type CampaignLink struct {
+	Code        string    `json:"code"`
+	DomainID    uint      `json:"domain_id"`
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	UTM         UTMParams `json:"utm"`
+	ClickCount  int64     `json:"click_count"`
+	Deleted     bool      `json:"deleted,omitempty"`
+}
+
 // URLAnalyticsSummary represents analytics data for a single URL
 type URLAnalyticsSummary struct {
 	Code          string           `json:"code"`
+	DomainID      uint             `json:"domain_id"`
+	ShortURL      string           `json:"short_url"`
 	OriginalURL   string           `json:"original_url"`
 	ClickCount    int64            `json:"click_count"`
 	CreatedAt     time.Time        `json:"created_at"`
@@ -202,6 +903,16 @@ type RealTimeStats struct {
 	LiveClicks     []Click          `json:"live_clicks"` // Last 10 clicks
 }
 
+// LiveClick is a click as sent on the live click stream
+type LiveClick struct {
+	Code      string    `json:"code"`
+	Platform  string    `json:"platform"`
+	Browser   string    `json:"browser"`
+	Country   string    `json:"country"`
+	Referrer  string    `json:"referrer"`
+	ClickedAt time.Time `json:"clicked_at"`
+}
+
 // Geographic analytics
 type GeoAnalytics struct {
 	CountryStats map[string]GeoStat `json:"country_stats"`
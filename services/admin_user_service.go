@@ -0,0 +1,364 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"url-shortener/models"
+	"url-shortener/utils"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidCredentials is returned for unknown users, inactive users and wrong passwords
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrInvalidSession is returned for unknown or expired session tokens
+	ErrInvalidSession = errors.New("invalid or expired session")
+	// ErrAdminUserNotFound is returned when no admin user matches an ID
+	ErrAdminUserNotFound = errors.New("admin user not found")
+	// ErrUsernameTaken is returned when creating a user with an existing username
+	ErrUsernameTaken = errors.New("username is already in use")
+	// ErrLastOwner is returned when a change would leave no active owner
+	ErrLastOwner = errors.New("at least one active owner is required")
+	// ErrTooManyLoginAttempts is returned while a username is locked after wrong passwords
+	ErrTooManyLoginAttempts = errors.New("too many failed sign-in attempts")
+)
+
+// Wrong passwords allowed per username from one IP, and per username from all
+// IPs together, before its sign-ins are refused for the rest of the window.
+// An IP refused for a username doesn't add to the username's failures, so a
+// single client can't lock an account for everyone.
+const (
+	maxLoginFailures     = 10
+	maxUserLoginFailures = 100
+	loginWindow          = 15 * time.Minute
+)
+
+// dummyPasswordHash is compared against when a username doesn't exist, so
+// unknown users take as long to reject as wrong passwords
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+type AdminUserService struct {
+	db          *gorm.DB
+	sessionTTL  time.Duration
+	workspaceID uint
+	logins      *utils.AttemptLimiter // Per username and IP
+	userLogins  *utils.AttemptLimiter // Per username
+}
+
+func NewAdminUserService(db *gorm.DB, sessionTTL time.Duration) *AdminUserService {
+	return &AdminUserService{
+		db:         db,
+		sessionTTL: sessionTTL,
+		logins:     utils.NewAttemptLimiter(maxLoginFailures, loginWindow),
+		userLogins: utils.NewAttemptLimiter(maxUserLoginFailures, loginWindow),
+	}
+}
+
+// ForWorkspace returns a copy of the service that only manages the users of
+// one workspace. Sign-in and sessions must use the unscoped service.
+func (s *AdminUserService) ForWorkspace(workspaceID uint) *AdminUserService {
+	scoped := *s
+	scoped.db = workspaceScope(s.db, workspaceID)
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
+// Bootstrap creates an owner from the legacy ADMIN_USERNAME/ADMIN_PASSWORD pair
+// when there are no admin users yet, so existing deployments can still sign in
+func (s *AdminUserService) Bootstrap(username, password string) error {
+	var count int64
+	if err := s.db.Model(&models.AdminUser{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	if password == "" {
+		log.Println("No admin users exist; set ADMIN_PASSWORD to create the first owner")
+		return nil
+	}
+
+	_, err := s.CreateUser(models.AdminUserRequest{Username: username, Password: password, Role: models.RoleOwner})
+	if err == nil {
+		log.Printf("Created admin owner %q from ADMIN_USERNAME/ADMIN_PASSWORD", username)
+	}
+	return err
+}
+
+// Authenticate checks a username and password from a client IP and returns
+// the active user. After too many wrong passwords for the username, from the
+// IP or from all IPs together, it returns ErrTooManyLoginAttempts without
+// checking the password.
+func (s *AdminUserService) Authenticate(username, password, ipAddress string) (*models.AdminUser, error) {
+	username = strings.ToLower(username)
+	if s.LoginRetryAfter(username, ipAddress) > 0 {
+		return nil, ErrTooManyLoginAttempts
+	}
+
+	var user models.AdminUser
+	err := s.db.Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		s.loginFailed(username, ipAddress)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil || !user.IsActive {
+		s.loginFailed(username, ipAddress)
+		return nil, ErrInvalidCredentials
+	}
+	s.logins.Reset(loginKey(username, ipAddress))
+	return &user, nil
+}
+
+// LoginRetryAfter returns how long sign-ins as username from ipAddress are
+// refused for, or 0
+func (s *AdminUserService) LoginRetryAfter(username, ipAddress string) time.Duration {
+	username = strings.ToLower(username)
+	return max(s.logins.RetryAfter(loginKey(username, ipAddress)), s.userLogins.RetryAfter(username))
+}
+
+func (s *AdminUserService) loginFailed(username, ipAddress string) {
+	s.logins.Fail(loginKey(username, ipAddress))
+	s.userLogins.Fail(username)
+}
+
+// loginKey identifies a username's sign-ins from one IP. IPs have no spaces,
+// so no two pairs share a key.
+func loginKey(username, ipAddress string) string {
+	return ipAddress + " " + username
+}
+
+// CreateSession signs a user in and returns the cookie token, which is only
+// stored hashed. Expired sessions are cleaned up on the way.
+func (s *AdminUserService) CreateSession(user *models.AdminUser, ipAddress, userAgent string) (string, *models.AdminSession, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	session := models.AdminSession{
+		TokenHash:   hashToken(token),
+		CSRFToken:   csrfToken,
+		AdminUserID: user.ID,
+		IPAddress:   ipAddress,
+		UserAgent:   userAgent,
+		ExpiresAt:   now.Add(s.sessionTTL),
+	}
+	if err := s.db.Create(&session).Error; err != nil {
+		return "", nil, err
+	}
+
+	s.db.Model(user).Update("last_login_at", &now)
+	s.db.Where("expires_at < ?", now).Delete(&models.AdminSession{})
+
+	return token, &session, nil
+}
+
+// ValidateSession returns the session for a cookie token and its active user
+func (s *AdminUserService) ValidateSession(token string) (*models.AdminUser, *models.AdminSession, error) {
+	var session models.AdminSession
+	err := s.db.Where("token_hash = ? AND expires_at > ?", hashToken(token), time.Now()).First(&session).Error
+	if err != nil {
+		return nil, nil, ErrInvalidSession
+	}
+
+	var user models.AdminUser
+	if err := s.db.Where("id = ? AND is_active = ?", session.AdminUserID, true).First(&user).Error; err != nil {
+		return nil, nil, ErrInvalidSession
+	}
+	return &user, &session, nil
+}
+
+// DeleteSession signs out the session with the given cookie token
+func (s *AdminUserService) DeleteSession(token string) error {
+	return s.db.Where("token_hash = ?", hashToken(token)).Delete(&models.AdminSession{}).Error
+}
+
+func (s *AdminUserService) GetUser(id uint) (*models.AdminUser, error) {
+	var user models.AdminUser
+	if err := s.db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAdminUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *AdminUserService) GetUsers() ([]models.AdminUser, error) {
+	var users []models.AdminUser
+	err := s.db.Order("username").Find(&users).Error
+	return users, err
+}
+
+func (s *AdminUserService) CreateUser(req models.AdminUserRequest) (*models.AdminUser, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.AdminUser{
+		WorkspaceID:  s.workspaceID,
+		Username:     strings.ToLower(strings.TrimSpace(req.Username)),
+		PasswordHash: string(hashed),
+		Role:         req.Role,
+		IsActive:     true,
+	}
+
+	// Usernames are unique across workspaces, since sign-in is by username alone
+	var existing int64
+	if err := unscopedDB(s.db).Model(&models.AdminUser{}).Where("username = ?", user.Username).Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, ErrUsernameTaken
+	}
+
+	if err := s.db.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateUser changes a user's role, status or password. Deactivating a user or
+// changing their password signs them out everywhere.
+func (s *AdminUserService) UpdateUser(id uint, req models.AdminUserUpdateRequest) (*models.AdminUser, error) {
+	var user models.AdminUser
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrAdminUserNotFound
+			}
+			return err
+		}
+
+		wasOwner := user.IsActive && user.Role == models.RoleOwner
+		columns := []string{}
+		if req.Role != nil {
+			user.Role = *req.Role
+			columns = append(columns, "role")
+		}
+		if req.IsActive != nil {
+			user.IsActive = *req.IsActive
+			columns = append(columns, "is_active")
+		}
+		if req.Password != nil {
+			hashed, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+			user.PasswordHash = string(hashed)
+			columns = append(columns, "password_hash")
+		}
+		if len(columns) == 0 {
+			return nil
+		}
+
+		if wasOwner && !(user.IsActive && user.Role == models.RoleOwner) {
+			if err := ensureAnotherOwner(tx, user.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&user).Select(columns).Updates(&user).Error; err != nil {
+			return err
+		}
+		if !user.IsActive || req.Password != nil {
+			return unscopedDB(tx).Where("admin_user_id = ?", user.ID).Delete(&models.AdminSession{}).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ChangePassword sets a user's own password after checking the current one.
+// Other sessions are signed out; keepSession stays valid.
+func (s *AdminUserService) ChangePassword(user *models.AdminUser, current, next string, keepSession uint) error {
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(current)) != nil {
+		return ErrInvalidCredentials
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(next), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password_hash", string(hashed)).Error; err != nil {
+			return err
+		}
+		return tx.Where("admin_user_id = ? AND id <> ?", user.ID, keepSession).Delete(&models.AdminSession{}).Error
+	})
+}
+
+// DeleteUser removes a user and their sessions
+func (s *AdminUserService) DeleteUser(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var user models.AdminUser
+		if err := tx.First(&user, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrAdminUserNotFound
+			}
+			return err
+		}
+
+		if user.IsActive && user.Role == models.RoleOwner {
+			if err := ensureAnotherOwner(tx, user.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := unscopedDB(tx).Where("admin_user_id = ?", user.ID).Delete(&models.AdminSession{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+}
+
+// ensureAnotherOwner returns ErrLastOwner unless an active owner other than id
+// exists (in the workspace, when tx is scoped to one)
+func ensureAnotherOwner(tx *gorm.DB, id uint) error {
+	var owners int64
+	err := tx.Model(&models.AdminUser{}).
+		Where("role = ? AND is_active = ? AND id <> ?", models.RoleOwner, true, id).
+		Count(&owners).Error
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+func randomToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func hashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
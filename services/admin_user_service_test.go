@@ -0,0 +1,45 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLoginLockout(t *testing.T) {
+	// Sign-ins refused by the limits never reach the database
+	s := NewAdminUserService(openTestDB(t, unreachableDSN), time.Hour)
+
+	for i := 0; i < maxLoginFailures; i++ {
+		s.loginFailed("owner", "192.0.2.1")
+	}
+	if s.LoginRetryAfter("Owner", "192.0.2.1") <= 0 {
+		t.Error("IP with too many wrong passwords isn't refused")
+	}
+	if _, err := s.Authenticate("owner", "secret", "192.0.2.1"); !errors.Is(err, ErrTooManyLoginAttempts) {
+		t.Errorf("Authenticate() from refused IP error = %v, want ErrTooManyLoginAttempts", err)
+	}
+	if d := s.LoginRetryAfter("owner", "192.0.2.2"); d != 0 {
+		t.Errorf("other IP refused for %v, want 0", d)
+	}
+	if d := s.LoginRetryAfter("admin", "192.0.2.1"); d != 0 {
+		t.Errorf("other username refused for %v, want 0", d)
+	}
+
+	// A refused IP keeps trying without adding to the username's failures
+	for i := 0; i < maxUserLoginFailures; i++ {
+		s.Authenticate("owner", "guess", "192.0.2.1")
+	}
+	if d := s.LoginRetryAfter("owner", "192.0.2.2"); d != 0 {
+		t.Errorf("refused IP locked the username for %v", d)
+	}
+
+	// Wrong passwords from many IPs together lock the username for all
+	for i := maxLoginFailures; i < maxUserLoginFailures; i++ {
+		s.loginFailed("owner", fmt.Sprintf("198.51.100.%d", i/maxLoginFailures))
+	}
+	if s.LoginRetryAfter("owner", "203.0.113.1") <= 0 {
+		t.Error("username isn't refused after too many wrong passwords from all IPs")
+	}
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"time"
 
 	"url-shortener/models"
@@ -9,13 +10,51 @@ import (
 )
 
 type AnalyticsService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	includeBots bool
 }
 
 func NewAnalyticsService(db *gorm.DB) *AnalyticsService {
 	return &AnalyticsService{db: db}
 }
 
+// ForWorkspace returns a copy of the service whose statistics only cover the
+// links, clicks and API keys of one workspace
+func (s *AnalyticsService) ForWorkspace(workspaceID uint) *AnalyticsService {
+	return &AnalyticsService{db: workspaceScope(s.db, workspaceID), includeBots: s.includeBots}
+}
+
+// WithBots returns a copy of the service whose statistics count the clicks of
+// crawlers, link previews and monitors too, or only people's when include is false
+func (s *AnalyticsService) WithBots(include bool) *AnalyticsService {
+	return &AnalyticsService{db: s.db, includeBots: include}
+}
+
+// clicks starts a query on the clicks the statistics count
+func (s *AnalyticsService) clicks() *gorm.DB {
+	query := s.db.Model(&models.Click{})
+	if !s.includeBots {
+		query = query.Where("is_bot = ?", false)
+	}
+	return query
+}
+
+// clickCount returns a link's clicks as the statistics count them
+func (s *AnalyticsService) clickCount(url *models.URL) int64 {
+	if s.includeBots {
+		return url.ClickCount + url.BotClickCount
+	}
+	return url.ClickCount
+}
+
+// clickCountColumn is clickCount as an SQL expression on urls
+func (s *AnalyticsService) clickCountColumn() string {
+	if s.includeBots {
+		return "(click_count + bot_click_count)"
+	}
+	return "click_count"
+}
+
 func (s *AnalyticsService) RecordClick(click models.Click) error {
 	return s.db.Create(&click).Error
 }
@@ -27,7 +66,7 @@ func (s *AnalyticsService) GetAnalytics(code string) (*models.AnalyticsResponse,
 	}
 
 	var clicks []models.Click
-	s.db.Where("url_code = ?", code).Order("clicked_at desc").Limit(10).Find(&clicks)
+	s.clicks().Where("url_code = ?", code).Order("clicked_at desc").Limit(10).Find(&clicks)
 
 	// Platform stats
 	platformStats := make(map[string]int64)
@@ -35,7 +74,7 @@ func (s *AnalyticsService) GetAnalytics(code string) (*models.AnalyticsResponse,
 		Platform string
 		Count    int64
 	}
-	s.db.Model(&models.Click{}).Select("platform, count(*) as count").
+	s.clicks().Select("platform, count(*) as count").
 		Where("url_code = ?", code).Group("platform").Scan(&platformResults)
 	for _, result := range platformResults {
 		platformStats[result.Platform] = result.Count
@@ -47,7 +86,7 @@ func (s *AnalyticsService) GetAnalytics(code string) (*models.AnalyticsResponse,
 		Browser string
 		Count   int64
 	}
-	s.db.Model(&models.Click{}).Select("browser, count(*) as count").
+	s.clicks().Select("browser, count(*) as count").
 		Where("url_code = ?", code).Group("browser").Scan(&browserResults)
 	for _, result := range browserResults {
 		browserStats[result.Browser] = result.Count
@@ -59,24 +98,85 @@ func (s *AnalyticsService) GetAnalytics(code string) (*models.AnalyticsResponse,
 		OS    string
 		Count int64
 	}
-	s.db.Model(&models.Click{}).Select("os, count(*) as count").
+	s.clicks().Select("os, count(*) as count").
 		Where("url_code = ?", code).Group("os").Scan(&osResults)
 	for _, result := range osResults {
 		osStats[result.OS] = result.Count
 	}
 
+	// Device stats; clicks recorded before devices were classified have none
+	deviceStats := make(map[string]int64)
+	var deviceResults []struct {
+		Device string
+		Count  int64
+	}
+	s.clicks().Select("device, count(*) as count").
+		Where("url_code = ? AND device <> ''", code).Group("device").Scan(&deviceResults)
+	for _, result := range deviceResults {
+		deviceStats[result.Device] = result.Count
+	}
+
+	// Bot stats are reported apart from the other stats, so the clicks of
+	// link previews and monitors can be told apart even when they're excluded
+	botStats := make(map[string]int64)
+	var botResults []struct {
+		BotName string
+		Count   int64
+	}
+	s.db.Model(&models.Click{}).Select("bot_name, count(*) as count").
+		Where("url_code = ? AND is_bot = ?", code, true).Group("bot_name").Scan(&botResults)
+	for _, result := range botResults {
+		botStats[result.BotName] = result.Count
+	}
+
+	// Variant stats, for links with an A/B split; variants without clicks yet
+	// are listed with 0, and removed ones keep their clicks
+	var variantResults []struct {
+		Variant string
+		Count   int64
+	}
+	s.clicks().Select("variant, count(*) as count").
+		Where("url_code = ? AND variant <> ''", code).Group("variant").Scan(&variantResults)
+	var variantStats map[string]int64
+	if len(url.Variants) > 0 || len(variantResults) > 0 {
+		variantStats = make(map[string]int64)
+		for _, variant := range url.Variants {
+			variantStats[variant.Name] = 0
+		}
+		for _, result := range variantResults {
+			variantStats[result.Variant] = result.Count
+		}
+	}
+
 	return &models.AnalyticsResponse{
 		Code:          url.Code,
 		OriginalURL:   url.OriginalURL,
-		ClickCount:    url.ClickCount,
+		ClickCount:    s.clickCount(&url),
+		BotClickCount: url.BotClickCount,
 		CreatedAt:     url.CreatedAt,
 		PlatformStats: platformStats,
 		BrowserStats:  browserStats,
 		OSStats:       osStats,
+		DeviceStats:   deviceStats,
 		RecentClicks:  clicks,
+		VariantStats:  variantStats,
+		BotStats:      botStats,
 	}, nil
 }
 
+// GetURLOwner returns the ID of the API key that created a URL ("" for
+// anonymous links) and the workspace the URL belongs to
+func (s *AnalyticsService) GetURLOwner(code string) (string, uint, error) {
+	var url models.URL
+	if err := s.db.Select("created_by_api_key", "workspace_id").Where("code = ?", code).First(&url).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", 0, ErrURLNotFound
+		}
+		return "", 0, err
+	}
+	return url.CreatedByAPIKey, url.WorkspaceID, nil
+}
+
 // GetAllURLsAnalytics returns paginated analytics for all URLs with enhanced filtering
 func (s *AnalyticsService) GetAllURLsAnalytics(offset, limit int) ([]models.URLAnalyticsSummary, int64, error) {
 	var urls []models.URL
@@ -89,7 +189,7 @@ func (s *AnalyticsService) GetAllURLsAnalytics(offset, limit int) ([]models.URLA
 
 	// Get URLs with pagination, ordered by click count and creation date
 	if err := s.db.Offset(offset).Limit(limit).
-		Order("click_count desc, created_at desc").Find(&urls).Error; err != nil {
+		Order(s.clickCountColumn() + " desc, created_at desc").Find(&urls).Error; err != nil {
 		return nil, 0, err
 	}
 
@@ -97,7 +197,7 @@ func (s *AnalyticsService) GetAllURLsAnalytics(offset, limit int) ([]models.URLA
 	for _, url := range urls {
 		// Get recent clicks
 		var recentClicks []models.Click
-		s.db.Where("url_code = ?", url.Code).
+		s.clicks().Where("url_code = ?", url.Code).
 			Order("clicked_at desc").Limit(5).Find(&recentClicks)
 
 		// Get platform stats
@@ -106,7 +206,7 @@ func (s *AnalyticsService) GetAllURLsAnalytics(offset, limit int) ([]models.URLA
 			Platform string
 			Count    int64
 		}
-		s.db.Model(&models.Click{}).Select("platform, count(*) as count").
+		s.clicks().Select("platform, count(*) as count").
 			Where("url_code = ?", url.Code).Group("platform").Scan(&platformResults)
 		for _, result := range platformResults {
 			platformStats[result.Platform] = result.Count
@@ -114,8 +214,9 @@ func (s *AnalyticsService) GetAllURLsAnalytics(offset, limit int) ([]models.URLA
 
 		summary := models.URLAnalyticsSummary{
 			Code:          url.Code,
+			DomainID:      url.DomainID,
 			OriginalURL:   url.OriginalURL,
-			ClickCount:    url.ClickCount,
+			ClickCount:    s.clickCount(&url),
 			CreatedAt:     url.CreatedAt,
 			CreatedByAPI:  url.CreatedByAPIKey != "",
 			APIKeyID:      url.CreatedByAPIKey,
@@ -134,38 +235,40 @@ func (s *AnalyticsService) GetSystemStats() (*models.SystemStats, error) {
 	var stats models.SystemStats
 
 	// Total URLs
-	s.db.Model(&models.URL{}).Count(&stats.TotalURLs)
+	if err := s.db.Model(&models.URL{}).Count(&stats.TotalURLs).Error; err != nil {
+		return nil, err
+	}
 
 	// Total Clicks
-	s.db.Model(&models.Click{}).Count(&stats.TotalClicks)
+	s.clicks().Count(&stats.TotalClicks)
 
 	// URLs created today
 	today := time.Now().Truncate(24 * time.Hour)
 	s.db.Model(&models.URL{}).Where("created_at >= ?", today).Count(&stats.URLsToday)
 
 	// Clicks today
-	s.db.Model(&models.Click{}).Where("clicked_at >= ?", today).Count(&stats.ClicksToday)
+	s.clicks().Where("clicked_at >= ?", today).Count(&stats.ClicksToday)
 
 	// URLs created this week
 	weekStart := today.AddDate(0, 0, -int(today.Weekday()))
 	s.db.Model(&models.URL{}).Where("created_at >= ?", weekStart).Count(&stats.URLsThisWeek)
 
 	// Clicks this week
-	s.db.Model(&models.Click{}).Where("clicked_at >= ?", weekStart).Count(&stats.ClicksThisWeek)
+	s.clicks().Where("clicked_at >= ?", weekStart).Count(&stats.ClicksThisWeek)
 
 	// URLs created this month
 	monthStart := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
 	s.db.Model(&models.URL{}).Where("created_at >= ?", monthStart).Count(&stats.URLsThisMonth)
 
 	// Clicks this month
-	s.db.Model(&models.Click{}).Where("clicked_at >= ?", monthStart).Count(&stats.ClicksThisMonth)
+	s.clicks().Where("clicked_at >= ?", monthStart).Count(&stats.ClicksThisMonth)
 
 	// Top platforms (enhanced)
 	var platformResults []struct {
 		Platform string
 		Count    int64
 	}
-	s.db.Model(&models.Click{}).Select("platform, count(*) as count").
+	s.clicks().Select("platform, count(*) as count").
 		Group("platform").Order("count desc").Limit(10).Scan(&platformResults)
 
 	stats.TopPlatforms = make(map[string]int64)
@@ -178,7 +281,7 @@ func (s *AnalyticsService) GetSystemStats() (*models.SystemStats, error) {
 		Browser string
 		Count   int64
 	}
-	s.db.Model(&models.Click{}).Select("browser, count(*) as count").
+	s.clicks().Select("browser, count(*) as count").
 		Group("browser").Order("count desc").Limit(5).Scan(&browserResults)
 
 	stats.TopBrowsers = make(map[string]int64)
@@ -200,7 +303,7 @@ func (s *AnalyticsService) GetSystemStats() (*models.SystemStats, error) {
 		Code       string
 		ClickCount int64
 	}
-	s.db.Model(&models.Click{}).
+	s.clicks().
 		Select("url_code as code, count(*) as click_count").
 		Where("clicked_at >= ?", today).
 		Group("url_code").
@@ -230,7 +333,7 @@ func (s *AnalyticsService) getDailyTrends(days int) []models.DailyTrend {
 
 	s.db.Model(&models.URL{}).
 		Select("DATE(created_at) as date, COUNT(*) as count").
-		Where("created_at >= NOW() - INTERVAL '? days'", days).
+		Where("created_at >= ?", time.Now().AddDate(0, 0, -days)).
 		Group("DATE(created_at)").
 		Order("date desc").
 		Scan(&urlResults)
@@ -241,9 +344,9 @@ func (s *AnalyticsService) getDailyTrends(days int) []models.DailyTrend {
 		Count int64
 	}
 
-	s.db.Model(&models.Click{}).
+	s.clicks().
 		Select("DATE(clicked_at) as date, COUNT(*) as count").
-		Where("clicked_at >= NOW() - INTERVAL '? days'", days).
+		Where("clicked_at >= ?", time.Now().AddDate(0, 0, -days)).
 		Group("DATE(clicked_at)").
 		Order("date desc").
 		Scan(&clickResults)
@@ -284,9 +387,9 @@ func (s *AnalyticsService) getGlobalHourlyTrends(hours int) []models.HourlyTrend
 		Count int64
 	}
 
-	s.db.Model(&models.Click{}).
+	s.clicks().
 		Select("EXTRACT(HOUR FROM clicked_at)::int as hour, COUNT(*) as count").
-		Where("clicked_at >= NOW() - INTERVAL '? hours'", hours).
+		Where("clicked_at >= ?", time.Now().Add(-time.Duration(hours)*time.Hour)).
 		Group("EXTRACT(HOUR FROM clicked_at)").
 		Order("hour").
 		Scan(&results)
@@ -311,8 +414,9 @@ func (s *AnalyticsService) getGlobalHourlyTrends(hours int) []models.HourlyTrend
 func (s *AnalyticsService) GetTopURLs(limit int) ([]models.URLAnalyticsSummary, error) {
 	var urls []models.URL
 
-	if err := s.db.Where("click_count > 0").
-		Order("click_count desc").
+	clickCount := s.clickCountColumn()
+	if err := s.db.Where(clickCount + " > 0").
+		Order(clickCount + " desc").
 		Limit(limit).
 		Find(&urls).Error; err != nil {
 		return nil, err
@@ -326,7 +430,7 @@ func (s *AnalyticsService) GetTopURLs(limit int) ([]models.URLAnalyticsSummary,
 			Platform string
 			Count    int64
 		}
-		s.db.Model(&models.Click{}).Select("platform, count(*) as count").
+		s.clicks().Select("platform, count(*) as count").
 			Where("url_code = ?", url.Code).Group("platform").Scan(&platformResults)
 		for _, pResult := range platformResults {
 			platformStats[pResult.Platform] = pResult.Count
@@ -334,8 +438,9 @@ func (s *AnalyticsService) GetTopURLs(limit int) ([]models.URLAnalyticsSummary,
 
 		summary := models.URLAnalyticsSummary{
 			Code:          url.Code,
+			DomainID:      url.DomainID,
 			OriginalURL:   url.OriginalURL,
-			ClickCount:    url.ClickCount,
+			ClickCount:    s.clickCount(&url),
 			CreatedAt:     url.CreatedAt,
 			CreatedByAPI:  url.CreatedByAPIKey != "",
 			APIKeyID:      url.CreatedByAPIKey,
@@ -359,7 +464,7 @@ func (s *AnalyticsService) GetRecentActivity(limit int) (*models.RecentActivity,
 	}
 
 	// Get recent clicks
-	if err := s.db.Order("clicked_at desc").Limit(limit).Find(&recentClicks).Error; err != nil {
+	if err := s.clicks().Order("clicked_at desc").Limit(limit).Find(&recentClicks).Error; err != nil {
 		return nil, err
 	}
 
@@ -373,7 +478,7 @@ func (s *AnalyticsService) GetRecentActivity(limit int) (*models.RecentActivity,
 func (s *AnalyticsService) GetClicksByTimeRange(code string, startTime, endTime time.Time) ([]models.Click, error) {
 	var clicks []models.Click
 
-	query := s.db.Where("clicked_at >= ? AND clicked_at <= ?", startTime, endTime)
+	query := s.clicks().Where("clicked_at >= ? AND clicked_at <= ?", startTime, endTime)
 	if code != "" {
 		query = query.Where("url_code = ?", code)
 	}
@@ -385,6 +490,13 @@ func (s *AnalyticsService) GetClicksByTimeRange(code string, startTime, endTime
 	return clicks, nil
 }
 
+// CountURLsCreatedSince returns how many URLs were created after the given time
+func (s *AnalyticsService) CountURLsCreatedSince(since time.Time) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.URL{}).Where("created_at >= ?", since).Count(&count).Error
+	return count, err
+}
+
 // GetGeoStats returns geographical statistics for clicks
 func (s *AnalyticsService) GetGeoStats(code string) (map[string]int64, error) {
 	var results []struct {
@@ -392,7 +504,7 @@ func (s *AnalyticsService) GetGeoStats(code string) (map[string]int64, error) {
 		Count   int64
 	}
 
-	query := s.db.Model(&models.Click{}).Select("country, count(*) as count").Group("country")
+	query := s.clicks().Select("country, count(*) as count").Group("country")
 	if code != "" {
 		query = query.Where("url_code = ?", code)
 	}
@@ -418,7 +530,7 @@ func (s *AnalyticsService) GetReferrerStats(code string) (map[string]int64, erro
 		Count    int64
 	}
 
-	query := s.db.Model(&models.Click{}).Select("referrer, count(*) as count").Group("referrer")
+	query := s.clicks().Select("referrer, count(*) as count").Group("referrer")
 	if code != "" {
 		query = query.Where("url_code = ?", code)
 	}
@@ -446,9 +558,9 @@ func (s *AnalyticsService) GetClickTrends(code string, days int) ([]models.Daily
 		Count int64
 	}
 
-	query := s.db.Model(&models.Click{}).
+	query := s.clicks().
 		Select("DATE(clicked_at) as date, count(*) as count").
-		Where("clicked_at >= NOW() - INTERVAL '? days'", days).
+		Where("clicked_at >= ?", time.Now().AddDate(0, 0, -days)).
 		Group("DATE(clicked_at)").
 		Order("date desc")
 
@@ -471,6 +583,132 @@ func (s *AnalyticsService) GetClickTrends(code string, days int) ([]models.Daily
 	return trends, nil
 }
 
+// GetCampaignAnalytics combines the clicks of a campaign's links, deleted ones
+// included, with daily trends over the last days
+func (s *AnalyticsService) GetCampaignAnalytics(campaignID uint, days int) (*models.CampaignAnalytics, error) {
+	var campaign models.Campaign
+	if err := s.db.First(&campaign, campaignID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCampaignNotFound
+		}
+		return nil, err
+	}
+
+	links := s.db.Unscoped().Model(&models.URL{}).Where("campaign_id = ?", campaignID).Session(&gorm.Session{})
+	clicks := s.clicks().Where("url_code IN (?)", links.Select("code")).Session(&gorm.Session{})
+
+	analytics := &models.CampaignAnalytics{
+		Campaign:      campaign,
+		PlatformStats: make(map[string]int64),
+		GeoStats:      make(map[string]int64),
+		ReferrerStats: make(map[string]int64),
+		SourceStats:   make(map[string]int64),
+		MediumStats:   make(map[string]int64),
+		DailyTrends:   []models.DailyTrend{},
+		TopLinks:      []models.CampaignLink{},
+	}
+
+	// Totals and UTM breakdowns come from the links' click counters
+	var totals struct {
+		Links  int64
+		Clicks int64
+	}
+	clickCount := s.clickCountColumn()
+	if err := links.Select("count(*) as links, coalesce(sum(" + clickCount + "), 0) as clicks").Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+	analytics.TotalLinks = totals.Links
+	analytics.TotalClicks = totals.Clicks
+
+	for column, stats := range map[string]map[string]int64{
+		"utm_source": analytics.SourceStats,
+		"utm_medium": analytics.MediumStats,
+	} {
+		var results []struct {
+			Tag    string
+			Clicks int64
+		}
+		err := links.Select(column + " as tag, coalesce(sum(" + clickCount + "), 0) as clicks").Group(column).Scan(&results).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			tag := result.Tag
+			if tag == "" {
+				tag = "(none)"
+			}
+			stats[tag] = result.Clicks
+		}
+	}
+
+	var urls []models.URL
+	if err := links.Order(clickCount + " desc, created_at desc").Limit(10).Find(&urls).Error; err != nil {
+		return nil, err
+	}
+	for _, url := range urls {
+		analytics.TopLinks = append(analytics.TopLinks, models.CampaignLink{
+			Code:        url.Code,
+			DomainID:    url.DomainID,
+			OriginalURL: url.OriginalURL,
+			UTM:         url.UTM,
+			ClickCount:  s.clickCount(&url),
+			Deleted:     url.DeletedAt.Valid,
+		})
+	}
+
+	// Breakdowns of the clicks themselves
+	for column, stats := range map[string]map[string]int64{
+		"platform": analytics.PlatformStats,
+		"country":  analytics.GeoStats,
+	} {
+		var results []struct {
+			Value string
+			Count int64
+		}
+		if err := clicks.Select(column + " as value, count(*) as count").Group(column).Scan(&results).Error; err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if result.Value != "" {
+				stats[result.Value] = result.Count
+			}
+		}
+	}
+
+	var referrers []struct {
+		Referrer string
+		Count    int64
+	}
+	err := clicks.Select("referrer, count(*) as count").Group("referrer").
+		Order("count desc").Limit(10).Scan(&referrers).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range referrers {
+		referrer := result.Referrer
+		if referrer == "" {
+			referrer = "Direct"
+		}
+		analytics.ReferrerStats[referrer] = result.Count
+	}
+
+	var trends []struct {
+		Date  time.Time
+		Count int64
+	}
+	err = clicks.Select("DATE(clicked_at) as date, count(*) as count").
+		Where("clicked_at >= ?", time.Now().AddDate(0, 0, -days)).
+		Group("DATE(clicked_at)").Order("date desc").Scan(&trends).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range trends {
+		analytics.DailyTrends = append(analytics.DailyTrends, models.DailyTrend{Date: result.Date, Clicks: result.Count})
+	}
+
+	return analytics, nil
+}
+
 // GetPerformanceMetrics returns performance metrics for URLs
 func (s *AnalyticsService) GetPerformanceMetrics() (*models.PerformanceMetrics, error) {
 	var metrics models.PerformanceMetrics
@@ -481,7 +719,7 @@ func (s *AnalyticsService) GetPerformanceMetrics() (*models.PerformanceMetrics,
 	// Success rate (URLs that have been clicked vs total URLs)
 	var totalURLs, clickedURLs int64
 	s.db.Model(&models.URL{}).Count(&totalURLs)
-	s.db.Model(&models.URL{}).Where("click_count > 0").Count(&clickedURLs)
+	s.db.Model(&models.URL{}).Where(s.clickCountColumn() + " > 0").Count(&clickedURLs)
 
 	if totalURLs > 0 {
 		metrics.SuccessRate = float64(clickedURLs) / float64(totalURLs) * 100
@@ -492,7 +730,7 @@ func (s *AnalyticsService) GetPerformanceMetrics() (*models.PerformanceMetrics,
 
 	// Total requests today
 	today := time.Now().Truncate(24 * time.Hour)
-	s.db.Model(&models.Click{}).Where("clicked_at >= ?", today).Count(&metrics.RequestsToday)
+	s.clicks().Where("clicked_at >= ?", today).Count(&metrics.RequestsToday)
 
 	// Error rate (simulation - track actual errors)
 	metrics.ErrorRate = 0.01
@@ -508,12 +746,15 @@ func (s *AnalyticsService) GetAPIKeyUsage() ([]models.APIKeyUsage, error) {
 		ClickCount int64
 	}
 
-	s.db.Model(&models.URL{}).
-		Select("created_by_api_key as api_key_id, COUNT(*) as url_count, COALESCE(SUM(click_count), 0) as click_count").
+	err := s.db.Model(&models.URL{}).
+		Select("created_by_api_key as api_key_id, COUNT(*) as url_count, COALESCE(SUM(" + s.clickCountColumn() + "), 0) as click_count").
 		Where("created_by_api_key != ''").
 		Group("created_by_api_key").
 		Order("click_count desc").
-		Scan(&results)
+		Scan(&results).Error
+	if err != nil {
+		return nil, err
+	}
 
 	var usage []models.APIKeyUsage
 	for _, result := range results {
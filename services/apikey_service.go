@@ -2,8 +2,11 @@ package services
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"log"
+	"strings"
 	"time"
 	"url-shortener/models"
 	"url-shortener/utils"
@@ -11,12 +14,69 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrAPIKeyNotFound is returned when no API key matches a key ID
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrInvalidAPIKey is returned for unknown, inactive or expired keys and wrong secrets
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrAPIKeyExpiryInPast is returned when a key would be created or updated already expired
+	ErrAPIKeyExpiryInPast = errors.New("expires_at must be in the future")
+	// ErrInvalidGracePeriod is returned for rotation grace periods out of range
+	ErrInvalidGracePeriod = errors.New("grace_period must be a duration between 0s and 720h")
+	// ErrTooManyAPIKeyFailures is returned while a key ID is locked after wrong secrets
+	ErrTooManyAPIKeyFailures = errors.New("too many invalid API key attempts")
+)
+
+// MaxRotationGrace bounds how long a rotated-out secret may stay valid
+const MaxRotationGrace = 30 * 24 * time.Hour
+
+// Verified secrets are remembered briefly, so a busy key isn't hashed with
+// argon2id on every request. Wrong secrets are limited per key ID before
+// they are hashed; secrets already verified skip the limit, so guessing
+// can't lock out the key's owner.
+const (
+	verifiedSecretCacheSize = 10000
+	verifiedSecretTTL       = time.Minute
+	maxAPIKeyFailures       = 10
+	apiKeyFailureWindow     = time.Minute
+)
+
+type APIKeyConfig struct {
+	HMACSecret    string        // Key for HMAC-SHA256 secret hashing; empty falls back to argon2id
+	RotationGrace time.Duration // Default time the old secret stays valid after a rotation
+}
+
 type APIKeyService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	hasher      *utils.SecretHasher
+	cfg         APIKeyConfig
+	workspaceID uint
+	verified    *utils.LRU[string, string] // SHA-256 of key ID and secret to the stored hash it matched
+	failures    *utils.AttemptLimiter
 }
 
-func NewAPIKeyService(db *gorm.DB) *APIKeyService {
-	return &APIKeyService{db: db}
+// NewAPIKeyService creates an API key service. Create it once and share it, so
+// every caller hashes and verifies secrets with the same configuration.
+func NewAPIKeyService(db *gorm.DB, cfg APIKeyConfig) *APIKeyService {
+	if cfg.HMACSecret == "" {
+		log.Println("API_KEY_HMAC_SECRET is not set, hashing API key secrets with argon2id (slower per request)")
+	}
+	return &APIKeyService{
+		db:       db,
+		hasher:   utils.NewSecretHasher(cfg.HMACSecret),
+		cfg:      cfg,
+		verified: utils.NewLRU[string, string](verifiedSecretCacheSize, verifiedSecretTTL),
+		failures: utils.NewAttemptLimiter(maxAPIKeyFailures, apiKeyFailureWindow),
+	}
+}
+
+// ForWorkspace returns a copy of the service that only manages the keys of one
+// workspace and creates new ones in it. Keys are validated by the unscoped service.
+func (s *APIKeyService) ForWorkspace(workspaceID uint) *APIKeyService {
+	scoped := *s
+	scoped.db = workspaceScope(s.db, workspaceID)
+	scoped.workspaceID = workspaceID
+	return &scoped
 }
 
 func (s *APIKeyService) CreateAPIKey(req models.APIKeyRequest) (*models.APIKeyResponse, error) {
@@ -31,15 +91,32 @@ func (s *APIKeyService) CreateAPIKey(req models.APIKeyRequest) (*models.APIKeyRe
 		return nil, err
 	}
 
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrAPIKeyExpiryInPast
+	}
+
 	// Hash the secret for storage
-	hashedSecret := utils.HashAPIKey(keySecret)
+	hashedSecret, err := s.hasher.Hash(keySecret)
+	if err != nil {
+		return nil, err
+	}
 
 	apiKey := models.APIKey{
-		KeyID:       keyID,
-		KeySecret:   hashedSecret,
-		Name:        req.Name,
-		Description: req.Description,
-		IsActive:    true,
+		WorkspaceID:    s.workspaceID,
+		KeyID:          keyID,
+		KeySecret:      hashedSecret,
+		Name:           req.Name,
+		Description:    req.Description,
+		IsActive:       true,
+		RateLimit:      req.RateLimit,
+		DailyQuota:     req.DailyQuota,
+		MonthlyQuota:   req.MonthlyQuota,
+		Scopes:         req.Scopes,
+		AllowedDomains: normalizeDomains(req.AllowedDomains),
+		ExpiresAt:      req.ExpiresAt,
+	}
+	if apiKey.Scopes == nil {
+		apiKey.Scopes = models.AllScopes
 	}
 
 	result := s.db.Create(&apiKey)
@@ -47,32 +124,160 @@ func (s *APIKeyService) CreateAPIKey(req models.APIKeyRequest) (*models.APIKeyRe
 		return nil, result.Error
 	}
 
-	return &models.APIKeyResponse{
-		KeyID:       apiKey.KeyID,
-		KeySecret:   keySecret, // Return unhashed secret only on creation
-		Name:        apiKey.Name,
-		Description: apiKey.Description,
-		IsActive:    apiKey.IsActive,
-		CreatedAt:   apiKey.CreatedAt,
-	}, nil
+	response := ToAPIKeyResponse(apiKey)
+	response.KeySecret = keySecret // Return unhashed secret only on creation
+	return &response, nil
 }
 
+// ValidateAPIKey authenticates a key ID and secret. During a rotation's grace
+// window the previous secret is accepted too. Secrets stored in an outdated
+// format are rehashed once verified.
 func (s *APIKeyService) ValidateAPIKey(keyID, keySecret string) (*models.APIKey, error) {
 	var apiKey models.APIKey
 	result := s.db.Where("key_id = ? AND is_active = ?", keyID, true).First(&apiKey)
 	if result.Error != nil {
-		return nil, errors.New("invalid API key")
+		return nil, ErrInvalidAPIKey
 	}
 
-	hashedSecret := utils.HashAPIKey(keySecret)
-	if apiKey.KeySecret != hashedSecret {
-		return nil, errors.New("invalid API key")
+	now := time.Now()
+	if apiKey.ExpiresAt != nil && !now.Before(*apiKey.ExpiresAt) {
+		return nil, ErrInvalidAPIKey
 	}
 
-	// Update last used time
-	now := time.Now()
-	s.db.Model(&apiKey).Update("last_used_at", &now)
+	previousValid := apiKey.PreviousKeySecret != "" &&
+		apiKey.PreviousSecretExpiresAt != nil && now.Before(*apiKey.PreviousSecretExpiresAt)
+
+	// A cached match only counts while the hash it matched is still stored,
+	// so rotating or revoking the secret takes effect at once
+	cacheKey := verifiedSecretKey(keyID, keySecret)
+	if matched, ok := s.verified.Get(cacheKey); ok &&
+		(matched == apiKey.KeySecret || previousValid && matched == apiKey.PreviousKeySecret) {
+		s.db.Model(&apiKey).Update("last_used_at", &now)
+		return &apiKey, nil
+	}
+
+	if s.failures.RetryAfter(keyID) > 0 {
+		return nil, ErrTooManyAPIKeyFailures
+	}
+
+	column, matched := "key_secret", apiKey.KeySecret
+	ok, needsRehash := s.hasher.Verify(keySecret, apiKey.KeySecret)
+	if !ok && previousValid {
+		column, matched = "previous_key_secret", apiKey.PreviousKeySecret
+		ok, needsRehash = s.hasher.Verify(keySecret, apiKey.PreviousKeySecret)
+	}
+	if !ok {
+		s.failures.Fail(keyID)
+		return nil, ErrInvalidAPIKey
+	}
+
+	updates := map[string]interface{}{"last_used_at": &now}
+	if needsRehash {
+		if rehashed, err := s.hasher.Hash(keySecret); err == nil {
+			updates[column] = rehashed
+			matched = rehashed
+		}
+	}
+	if s.db.Model(&apiKey).Updates(updates).Error == nil {
+		s.verified.Set(cacheKey, matched)
+	}
+
+	return &apiKey, nil
+}
+
+// verifiedSecretKey identifies a key ID and secret pair in the verified cache
+// without keeping the secret in memory
+func verifiedSecretKey(keyID, keySecret string) string {
+	sum := sha256.Sum256([]byte(keyID + ":" + keySecret))
+	return hex.EncodeToString(sum[:])
+}
+
+// RotateAPIKey issues a new secret for an active key. The current secret stays
+// valid for grace (nil uses the configured default); a second rotation within
+// the window revokes the oldest secret immediately.
+func (s *APIKeyService) RotateAPIKey(keyID string, grace *time.Duration) (*models.APIKeyResponse, error) {
+	gracePeriod := s.cfg.RotationGrace
+	if grace != nil {
+		gracePeriod = *grace
+	}
+	if gracePeriod < 0 || gracePeriod > MaxRotationGrace {
+		return nil, ErrInvalidGracePeriod
+	}
+
+	var apiKey models.APIKey
+	if err := s.db.Where("key_id = ? AND is_active = ?", keyID, true).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+
+	keySecret, err := s.generateKeySecret()
+	if err != nil {
+		return nil, err
+	}
+	hashedSecret, err := s.hasher.Hash(keySecret)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey.PreviousKeySecret = ""
+	apiKey.PreviousSecretExpiresAt = nil
+	if gracePeriod > 0 {
+		expires := time.Now().Add(gracePeriod)
+		apiKey.PreviousKeySecret = apiKey.KeySecret
+		apiKey.PreviousSecretExpiresAt = &expires
+	}
+	apiKey.KeySecret = hashedSecret
+
+	err = s.db.Model(&apiKey).
+		Select("key_secret", "previous_key_secret", "previous_secret_expires_at").
+		Updates(&apiKey).Error
+	if err != nil {
+		return nil, err
+	}
+
+	response := ToAPIKeyResponse(apiKey)
+	response.KeySecret = keySecret
+	return &response, nil
+}
+
+// ToAPIKeyResponse converts a key to its API form, without the secret
+func ToAPIKeyResponse(key models.APIKey) models.APIKeyResponse {
+	scopes := key.Scopes
+	if scopes == nil {
+		scopes = models.AllScopes
+	}
 
+	response := models.APIKeyResponse{
+		KeyID:          key.KeyID,
+		Name:           key.Name,
+		Description:    key.Description,
+		IsActive:       key.IsActive,
+		RateLimit:      key.RateLimit,
+		DailyQuota:     key.DailyQuota,
+		MonthlyQuota:   key.MonthlyQuota,
+		Scopes:         scopes,
+		AllowedDomains: key.AllowedDomains,
+		ExpiresAt:      key.ExpiresAt,
+		LastUsedAt:     key.LastUsedAt,
+		CreatedAt:      key.CreatedAt,
+	}
+	if key.PreviousSecretExpiresAt != nil && time.Now().Before(*key.PreviousSecretExpiresAt) {
+		response.PreviousSecretExpiresAt = key.PreviousSecretExpiresAt
+	}
+	return response
+}
+
+// GetAPIKey returns a key by ID, active or not
+func (s *APIKeyService) GetAPIKey(keyID string) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	if err := s.db.Where("key_id = ?", keyID).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
 	return &apiKey, nil
 }
 
@@ -87,6 +292,120 @@ func (s *APIKeyService) DeactivateAPIKey(keyID string) error {
 		Update("is_active", false).Error
 }
 
+// UpdateAPIKey changes a key's scopes, allowed domains, expiry, rate limit and creation quotas
+func (s *APIKeyService) UpdateAPIKey(keyID string, req models.APIKeyUpdateRequest) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	if err := s.db.Where("key_id = ?", keyID).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+
+	var columns []string
+	if req.RateLimit != nil {
+		apiKey.RateLimit = *req.RateLimit
+		columns = append(columns, "rate_limit")
+	}
+	if req.DailyQuota != nil {
+		apiKey.DailyQuota = *req.DailyQuota
+		columns = append(columns, "daily_quota")
+	}
+	if req.MonthlyQuota != nil {
+		apiKey.MonthlyQuota = *req.MonthlyQuota
+		columns = append(columns, "monthly_quota")
+	}
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			return nil, ErrAPIKeyExpiryInPast
+		}
+		apiKey.ExpiresAt = req.ExpiresAt
+		columns = append(columns, "expires_at")
+	}
+	if req.Scopes != nil {
+		apiKey.Scopes = *req.Scopes
+		columns = append(columns, "scopes")
+	}
+	if req.AllowedDomains != nil {
+		apiKey.AllowedDomains = normalizeDomains(*req.AllowedDomains)
+		columns = append(columns, "allowed_domains")
+	}
+
+	if len(columns) > 0 {
+		if err := s.db.Model(&apiKey).Select(columns).Updates(&apiKey).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &apiKey, nil
+}
+
+// normalizeDomains lowercases domains and never returns nil, so an empty list is stored as []
+func normalizeDomains(domains []string) []string {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		normalized = append(normalized, normalizeDomain(domain))
+	}
+	return normalized
+}
+
+// normalizeDomain lowercases a host name and drops the trailing dot of a fully qualified one
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// QuotaStatus is how many more URLs a key may create before its quota resets
+type QuotaStatus struct {
+	Limit     int64
+	Remaining int64
+	Reset     time.Time
+}
+
+// CreationQuota returns the most restrictive of the key's daily and monthly
+// quotas, or nil if it has neither. Deduplicated links don't count; every
+// stored URL does, including deleted ones.
+func (s *APIKeyService) CreationQuota(apiKey *models.APIKey, now time.Time) (*QuotaStatus, error) {
+	scope := unscopedDB(s.db).Unscoped().Model(&models.URL{}).Where("created_by_api_key = ?", apiKey.KeyID)
+	return creationQuota(scope, apiKey.DailyQuota, apiKey.MonthlyQuota, now)
+}
+
+// creationQuota counts the URLs matched by scope against a daily and a monthly
+// limit and returns the most restrictive status, or nil if both are unlimited
+func creationQuota(scope *gorm.DB, daily, monthly int64, now time.Time) (*QuotaStatus, error) {
+	now = now.UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	periods := []struct {
+		limit int64
+		start time.Time
+		reset time.Time
+	}{
+		{daily, dayStart, dayStart.AddDate(0, 0, 1)},
+		{monthly, monthStart, monthStart.AddDate(0, 1, 0)},
+	}
+
+	// The scope is reused for each period, so its conditions must not accumulate
+	scope = scope.Session(&gorm.Session{})
+
+	var status *QuotaStatus
+	for _, period := range periods {
+		if period.limit <= 0 {
+			continue
+		}
+
+		var used int64
+		if err := scope.Where("created_at >= ?", period.start).Count(&used).Error; err != nil {
+			return nil, err
+		}
+
+		remaining := max(period.limit-used, 0)
+		if status == nil || remaining < status.Remaining {
+			status = &QuotaStatus{Limit: period.limit, Remaining: remaining, Reset: period.reset}
+		}
+	}
+	return status, nil
+}
+
 func (s *APIKeyService) generateKeyID() (string, error) {
 	for {
 		bytes := make([]byte, 10)
@@ -95,9 +414,9 @@ func (s *APIKeyService) generateKeyID() (string, error) {
 		}
 		keyID := "ak_" + hex.EncodeToString(bytes)[:17] // ak_ + 17 chars = 20 total
 
-		// Check if key ID already exists
+		// Check if key ID already exists in any workspace
 		var existing models.APIKey
-		result := s.db.Where("key_id = ?", keyID).First(&existing)
+		result := unscopedDB(s.db).Where("key_id = ?", keyID).First(&existing)
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return keyID, nil
 		}
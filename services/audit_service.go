@@ -0,0 +1,107 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"url-shortener/models"
+
+	"gorm.io/gorm"
+)
+
+// auditIgnoredFields change on every write and would only add noise to diffs
+var auditIgnoredFields = map[string]bool{"updated_at": true}
+
+type AuditService struct {
+	db *gorm.DB
+}
+
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// ForWorkspace returns a copy of the service that only lists the entries of one workspace
+func (s *AuditService) ForWorkspace(workspaceID uint) *AuditService {
+	return &AuditService{db: workspaceScope(s.db, workspaceID)}
+}
+
+// Record appends entries to the audit log, each in its own WorkspaceID
+func (s *AuditService) Record(entries ...models.AuditLog) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return s.db.CreateInBatches(entries, 500).Error
+}
+
+// GetAuditLogs returns matching entries, newest first
+func (s *AuditService) GetAuditLogs(filter models.AuditFilter, offset, limit int) ([]models.AuditLog, int64, error) {
+	query := s.db.Model(&models.AuditLog{})
+	for column, value := range map[string]string{
+		"action":        filter.Action,
+		"actor_type":    filter.ActorType,
+		"actor_id":      filter.ActorID,
+		"resource_type": filter.ResourceType,
+		"resource_id":   filter.ResourceID,
+	} {
+		if value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	entries := []models.AuditLog{}
+	err := query.Order("created_at desc, id desc").Offset(offset).Limit(limit).Find(&entries).Error
+	return entries, total, err
+}
+
+// AuditSnapshot returns the JSON fields of v, so secrets hidden from the API
+// (json:"-") stay out of the audit log too
+func AuditSnapshot(v interface{}) map[string]interface{} {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if json.Unmarshal(data, &fields) != nil {
+		return nil
+	}
+	for name := range auditIgnoredFields {
+		delete(fields, name)
+	}
+	return fields
+}
+
+// AuditDiff returns only the JSON fields that differ between before and after
+func AuditDiff(before, after interface{}) (map[string]interface{}, map[string]interface{}) {
+	old, current := AuditSnapshot(before), AuditSnapshot(after)
+	changedBefore := make(map[string]interface{})
+	changedAfter := make(map[string]interface{})
+
+	for name, value := range current {
+		if previous, ok := old[name]; !ok || !reflect.DeepEqual(previous, value) {
+			changedBefore[name] = old[name]
+			changedAfter[name] = value
+		}
+	}
+	for name, value := range old {
+		if _, ok := current[name]; !ok {
+			changedBefore[name] = value
+			changedAfter[name] = nil
+		}
+	}
+	return changedBefore, changedAfter
+}
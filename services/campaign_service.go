@@ -0,0 +1,133 @@
+package services
+
+import (
+	"errors"
+
+	"url-shortener/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrCampaignNotFound is returned when no campaign of the workspace matches an ID
+	ErrCampaignNotFound = errors.New("campaign not found")
+	// ErrCampaignTaken is returned when the workspace already has a campaign with the name
+	ErrCampaignTaken = errors.New("campaign name is already in use")
+	// ErrCampaignInUse is returned when deleting a campaign that still has links
+	ErrCampaignInUse = errors.New("campaign still has links")
+)
+
+type CampaignService struct {
+	db          *gorm.DB
+	workspaceID uint
+}
+
+func NewCampaignService(db *gorm.DB) *CampaignService {
+	return &CampaignService{db: db}
+}
+
+// ForWorkspace returns a copy of the service that only manages the campaigns of one workspace
+func (s *CampaignService) ForWorkspace(workspaceID uint) *CampaignService {
+	return &CampaignService{db: workspaceScope(s.db, workspaceID), workspaceID: workspaceID}
+}
+
+func (s *CampaignService) GetCampaigns() ([]models.Campaign, error) {
+	campaigns := []models.Campaign{}
+	err := s.db.Order("name").Find(&campaigns).Error
+	return campaigns, err
+}
+
+func (s *CampaignService) GetCampaign(id uint) (*models.Campaign, error) {
+	var campaign models.Campaign
+	if err := s.db.First(&campaign, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCampaignNotFound
+		}
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+func (s *CampaignService) CreateCampaign(req models.CampaignRequest) (*models.Campaign, error) {
+	campaign := models.Campaign{WorkspaceID: s.workspaceID, Name: req.Name, Description: req.Description}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkName(tx, req.Name, 0); err != nil {
+			return err
+		}
+		return tx.Create(&campaign).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// UpdateCampaign applies the fields set in req
+func (s *CampaignService) UpdateCampaign(id uint, req models.CampaignUpdateRequest) (*models.Campaign, error) {
+	campaign, err := s.GetCampaign(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	if req.Name != nil {
+		campaign.Name = *req.Name
+		columns = append(columns, "name")
+	}
+	if req.Description != nil {
+		campaign.Description = *req.Description
+		columns = append(columns, "description")
+	}
+	if len(columns) == 0 {
+		return campaign, nil
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if req.Name != nil {
+			if err := s.checkName(tx, *req.Name, id); err != nil {
+				return err
+			}
+		}
+		return tx.Model(campaign).Select(columns).Updates(campaign).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return campaign, nil
+}
+
+// DeleteCampaign removes a campaign no link, including soft-deleted ones, belongs to
+func (s *CampaignService) DeleteCampaign(id uint) (*models.Campaign, error) {
+	campaign, err := s.GetCampaign(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var links int64
+		if err := tx.Unscoped().Model(&models.URL{}).Where("campaign_id = ?", id).Count(&links).Error; err != nil {
+			return err
+		}
+		if links > 0 {
+			return ErrCampaignInUse
+		}
+		return tx.Delete(campaign).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return campaign, nil
+}
+
+// checkName returns ErrCampaignTaken if another campaign of the workspace has the name
+func (s *CampaignService) checkName(tx *gorm.DB, name string, exceptID uint) error {
+	var existing int64
+	err := tx.Model(&models.Campaign{}).Where("name = ? AND id <> ?", name, exceptID).Count(&existing).Error
+	if err != nil {
+		return err
+	}
+	if existing > 0 {
+		return ErrCampaignTaken
+	}
+	return nil
+}
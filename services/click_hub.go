@@ -0,0 +1,126 @@
+package services
+
+import (
+	"errors"
+	"sync"
+
+	"url-shortener/models"
+)
+
+// ErrTooManySubscribers is returned when the hub already has its maximum number of live streams
+var ErrTooManySubscribers = errors.New("too many live click streams")
+
+const (
+	// Clicks a subscriber may fall behind by before it is evicted
+	clickSubscriberBuffer = 256
+	maxClickSubscribers   = 1000
+)
+
+// ClickSubscription receives the live clicks of one workspace, optionally of a
+// single code. C is closed when the subscriber is evicted or the hub closes.
+type ClickSubscription struct {
+	C <-chan models.LiveClick
+
+	ch          chan models.LiveClick
+	workspaceID uint
+	code        string
+	evicted     bool
+}
+
+// Evicted reports whether C was closed because the subscriber fell too far behind
+func (s *ClickSubscription) Evicted() bool {
+	return s.evicted
+}
+
+// ClickHub fans stored clicks out to live subscribers such as dashboard
+// streams. Publishing never blocks the click pipeline: a subscriber whose
+// buffer is full is evicted instead. It implements ClickListener.
+type ClickHub struct {
+	mu     sync.Mutex
+	subs   map[*ClickSubscription]struct{}
+	closed bool
+}
+
+func NewClickHub() *ClickHub {
+	return &ClickHub{subs: make(map[*ClickSubscription]struct{})}
+}
+
+// Subscribe starts receiving the clicks of a workspace, or of one of its codes when code is set
+func (h *ClickHub) Subscribe(workspaceID uint, code string) (*ClickSubscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed || len(h.subs) >= maxClickSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+
+	ch := make(chan models.LiveClick, clickSubscriberBuffer)
+	sub := &ClickSubscription{C: ch, ch: ch, workspaceID: workspaceID, code: code}
+	h.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Unsubscribe stops a subscription. It is safe to call after eviction.
+func (h *ClickHub) Unsubscribe(sub *ClickSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// ClicksRecorded publishes a stored batch of clicks
+func (h *ClickHub) ClicksRecorded(clicks []models.Click) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subs) == 0 {
+		return
+	}
+
+	for _, click := range clicks {
+		// The stream shows people's clicks, like the default analytics
+		if click.IsBot {
+			continue
+		}
+		workspaceID := click.WorkspaceID
+		if workspaceID == 0 {
+			workspaceID = models.DefaultWorkspaceID
+		}
+		live := models.LiveClick{
+			Code:      click.URLCode,
+			Platform:  click.Platform,
+			Browser:   click.Browser,
+			Country:   click.Country,
+			Referrer:  click.Referrer,
+			ClickedAt: click.ClickedAt,
+		}
+
+		for sub := range h.subs {
+			if sub.workspaceID != workspaceID || (sub.code != "" && sub.code != click.URLCode) {
+				continue
+			}
+			select {
+			case sub.ch <- live:
+			default:
+				sub.evicted = true
+				delete(h.subs, sub)
+				close(sub.ch)
+			}
+		}
+	}
+}
+
+// Close ends every subscription, letting open streams finish before shutdown
+func (h *ClickHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
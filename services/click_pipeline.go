@@ -0,0 +1,435 @@
+package services
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"url-shortener/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// walReplayInterval is how often clicks spilled to the WAL are retried
+const walReplayInterval = 30 * time.Second
+
+type ClickPipelineConfig struct {
+	QueueSize     int           // Clicks buffered in memory before spilling to the WAL
+	Workers       int           // Concurrent batch writers
+	BatchSize     int           // Clicks per bulk insert
+	FlushInterval time.Duration // Maximum time a click waits in a partial batch
+	WALPath       string        // Append-only file for clicks that couldn't be written
+}
+
+// ClickListener is told about clicks once they are stored. It runs on the
+// pipeline's workers, so it must not block for long.
+type ClickListener interface {
+	ClicksRecorded(clicks []models.Click)
+}
+
+// ClickPipeline ingests clicks asynchronously: a bounded queue feeds a pool of
+// workers that bulk-insert clicks and fold click_count increments per code,
+// counting bots' clicks in bot_click_count instead. All clicks on links with
+// max_clicks, bots' included, were counted when the redirect reserved them.
+// Batches that fail to write, and clicks arriving while the queue is full,
+// are appended to a local WAL file and replayed later. Every click gets a
+// ClickID when enqueued, so a batch that was stored although its write
+// reported an error, or a WAL that couldn't be cleared after its replay, isn't
+// stored or counted twice.
+type ClickPipeline struct {
+	db          *gorm.DB
+	geoResolver GeoResolver
+	cfg         ClickPipelineConfig
+	listeners   []ClickListener
+
+	queue  chan models.Click
+	mu     sync.RWMutex // guards closed against concurrent Enqueue/Close
+	closed bool
+	stop   chan struct{}
+	wg     sync.WaitGroup
+
+	walMu sync.Mutex
+}
+
+func NewClickPipeline(db *gorm.DB, geoResolver GeoResolver, cfg ClickPipelineConfig, listeners ...ClickListener) *ClickPipeline {
+	if cfg.QueueSize < 1 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+
+	p := &ClickPipeline{
+		db:          db,
+		geoResolver: geoResolver,
+		cfg:         cfg,
+		listeners:   listeners,
+		queue:       make(chan models.Click, cfg.QueueSize),
+		stop:        make(chan struct{}),
+	}
+
+	// Recover clicks left over from a previous run before accepting new ones
+	p.replayWAL()
+
+	for i := 0; i < cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+
+	p.wg.Add(1)
+	go p.walLoop()
+
+	return p
+}
+
+// Enqueue hands a click to the pipeline without blocking the caller.
+// When the queue is full the click goes straight to the WAL.
+func (p *ClickPipeline) Enqueue(click models.Click) {
+	if click.ClickID == "" {
+		click.ClickID = newClickID()
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		p.spill([]models.Click{click})
+		return
+	}
+
+	select {
+	case p.queue <- click:
+	default:
+		p.spill([]models.Click{click})
+	}
+}
+
+// Close stops accepting clicks and waits for queued clicks to be flushed
+func (p *ClickPipeline) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.queue)
+	close(p.stop)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+func (p *ClickPipeline) worker() {
+	defer p.wg.Done()
+
+	batch := make([]models.Click, 0, p.cfg.BatchSize)
+	ticker := time.NewTicker(p.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case click, ok := <-p.queue:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			batch = append(batch, p.enrich(click))
+			if len(batch) >= p.cfg.BatchSize {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// enrich fills in location data; a failed lookup leaves the fields empty
+func (p *ClickPipeline) enrich(click models.Click) models.Click {
+	if location, err := p.geoResolver.Lookup(click.IPAddress); err == nil {
+		click.CountryCode = location.CountryCode
+		click.Country = location.Country
+		click.Region = location.Region
+		click.City = location.City
+		click.ASN = location.ASN
+		click.ASOrg = location.ASOrg
+	}
+	return click
+}
+
+func (p *ClickPipeline) flush(batch []models.Click) {
+	if len(batch) == 0 {
+		return
+	}
+	if _, err := p.write(batch); err != nil {
+		log.Printf("Failed to write %d clicks, spilling to WAL: %v", len(batch), err)
+		p.spill(batch)
+	}
+}
+
+// errClicksRace is returned when another writer stored some of a batch's
+// clicks while it was being written
+var errClicksRace = errors.New("clicks were stored concurrently")
+
+// write inserts the clicks of the batch that aren't stored yet and applies the
+// folded click_count and bot_click_count increments in one transaction, then
+// hands the stored clicks to the listeners. It returns how many were stored.
+func (p *ClickPipeline) write(batch []models.Click) (int, error) {
+	var rows []models.Click
+	err := p.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if rows, err = unstored(tx, batch); err != nil || len(rows) == 0 {
+			return err
+		}
+
+		// Another writer can still store one of them before this commits, in
+		// which case the insert skips it. Rather than count it twice, the
+		// batch is rolled back and retried from the WAL.
+		result := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "click_id"}}, DoNothing: true}).
+			CreateInBatches(&rows, len(rows))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(rows)) {
+			return errClicksRace
+		}
+
+		for _, l := range countClicks(rows) {
+			err := tx.Model(&models.URL{}).Where("workspace_id = ? AND code = ?", l.workspaceID, l.code).
+				UpdateColumns(map[string]interface{}{
+					"click_count":     gorm.Expr("click_count + CASE WHEN max_clicks > 0 THEN 0 ELSE ? END", l.people),
+					"bot_click_count": gorm.Expr("bot_click_count + CASE WHEN max_clicks > 0 THEN 0 ELSE ? END", l.bots),
+				}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(rows) > 0 {
+		for _, listener := range p.listeners {
+			listener.ClicksRecorded(rows)
+		}
+	}
+	return len(rows), nil
+}
+
+// linkClicks is the number of people's and bots' clicks on one link
+type linkClicks struct {
+	workspaceID  uint
+	code         string
+	people, bots int64
+}
+
+// countClicks folds clicks into counts per link. Codes are only unique within
+// a workspace. The links are sorted so that concurrent workers update them in
+// the same order and can't deadlock.
+func countClicks(clicks []models.Click) []linkClicks {
+	type link struct {
+		workspaceID uint
+		code        string
+	}
+	counts := make(map[link]*linkClicks)
+	for _, click := range clicks {
+		l := link{click.WorkspaceID, click.URLCode}
+		if counts[l] == nil {
+			counts[l] = &linkClicks{workspaceID: click.WorkspaceID, code: click.URLCode}
+		}
+		if click.IsBot {
+			counts[l].bots++
+		} else {
+			counts[l].people++
+		}
+	}
+
+	links := make([]linkClicks, 0, len(counts))
+	for _, count := range counts {
+		links = append(links, *count)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].workspaceID != links[j].workspaceID {
+			return links[i].workspaceID < links[j].workspaceID
+		}
+		return links[i].code < links[j].code
+	})
+	return links
+}
+
+// spill appends clicks to the WAL as JSON lines
+func (p *ClickPipeline) spill(clicks []models.Click) {
+	p.walMu.Lock()
+	defer p.walMu.Unlock()
+
+	if err := p.appendWAL(clicks); err != nil {
+		log.Printf("Failed to write %d clicks to WAL, clicks lost: %v", len(clicks), err)
+	}
+}
+
+func (p *ClickPipeline) appendWAL(clicks []models.Click) error {
+	if p.cfg.WALPath == "" {
+		return errors.New("no WAL path configured")
+	}
+	if err := os.MkdirAll(filepath.Dir(p.cfg.WALPath), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(p.cfg.WALPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, click := range clicks {
+		click.ID = 0
+		if err := encoder.Encode(click); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (p *ClickPipeline) walLoop() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(walReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.replayWAL()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// replayWAL writes spilled clicks back to the database, keeping whatever still fails
+func (p *ClickPipeline) replayWAL() {
+	if p.cfg.WALPath == "" {
+		return
+	}
+
+	p.walMu.Lock()
+	defer p.walMu.Unlock()
+
+	clicks, err := p.readWAL()
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to read click WAL: %v", err)
+		}
+		return
+	}
+	if len(clicks) == 0 {
+		return
+	}
+
+	var remaining []models.Click
+	replayed := 0
+	for start := 0; start < len(clicks); start += p.cfg.BatchSize {
+		end := min(start+p.cfg.BatchSize, len(clicks))
+		stored, err := p.write(clicks[start:end])
+		if err != nil {
+			log.Printf("Click WAL replay failed, will retry: %v", err)
+			remaining = clicks[start:]
+			break
+		}
+		replayed += stored
+	}
+
+	if err := os.Remove(p.cfg.WALPath); err != nil {
+		log.Printf("Failed to clear click WAL: %v", err)
+		return
+	}
+	if len(remaining) > 0 {
+		if err := p.appendWAL(remaining); err != nil {
+			log.Printf("Failed to rewrite %d clicks to WAL, clicks lost: %v", len(remaining), err)
+		}
+		return
+	}
+
+	log.Printf("Replayed %d clicks from WAL, %d were already stored", replayed, len(clicks)-replayed)
+}
+
+// unstored returns a copy of the clicks of a batch that aren't in the database
+// yet, which the listeners can keep after the batch is reused
+func unstored(tx *gorm.DB, batch []models.Click) ([]models.Click, error) {
+	ids := make([]string, len(batch))
+	for i, click := range batch {
+		ids[i] = click.ClickID
+	}
+	var stored []string
+	if err := tx.Model(&models.Click{}).Where("click_id IN ?", ids).Pluck("click_id", &stored).Error; err != nil {
+		return nil, err
+	}
+
+	isStored := make(map[string]bool, len(stored))
+	for _, id := range stored {
+		isStored[id] = true
+	}
+	unstored := make([]models.Click, 0, len(batch)-len(stored))
+	for _, click := range batch {
+		if !isStored[click.ClickID] {
+			unstored = append(unstored, click)
+		}
+	}
+	return unstored, nil
+}
+
+// newClickID returns a random 32-character hex ID
+func newClickID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err) // crypto/rand doesn't fail on supported platforms
+	}
+	return hex.EncodeToString(id)
+}
+
+func (p *ClickPipeline) readWAL() ([]models.Click, error) {
+	f, err := os.Open(p.cfg.WALPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var clicks []models.Click
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var click models.Click
+		if err := json.Unmarshal(scanner.Bytes(), &click); err != nil {
+			// A torn write from a crash only affects the last line
+			log.Printf("Skipping corrupt click WAL entry: %v", err)
+			continue
+		}
+		clicks = append(clicks, click)
+	}
+	return clicks, scanner.Err()
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"url-shortener/models"
+
+	"gorm.io/gorm"
+)
+
+// recordedClicks is a ClickListener keeping every batch it is told about
+type recordedClicks struct {
+	mu      sync.Mutex
+	batches [][]models.Click
+}
+
+func (r *recordedClicks) ClicksRecorded(clicks []models.Click) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, clicks)
+}
+
+func (r *recordedClicks) clickIDs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []string
+	for _, batch := range r.batches {
+		for _, click := range batch {
+			ids = append(ids, click.ClickID)
+		}
+	}
+	return ids
+}
+
+// newTestPipeline returns a pipeline spilling to a WAL in a temporary
+// directory, which is closed when the test ends
+func newTestPipeline(t *testing.T, db *gorm.DB, listeners ...ClickListener) *ClickPipeline {
+	t.Helper()
+	p := NewClickPipeline(db, NoopGeoResolver{}, ClickPipelineConfig{
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		WALPath:       filepath.Join(t.TempDir(), "clicks.wal"),
+	}, listeners...)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func testClick(code, clickID string, bot bool) models.Click {
+	return models.Click{
+		WorkspaceID: models.DefaultWorkspaceID,
+		URLCode:     code,
+		ClickID:     clickID,
+		IsBot:       bot,
+		ClickedAt:   time.Now(),
+	}
+}
+
+func TestCountClicks(t *testing.T) {
+	clicks := []models.Click{
+		testClick("b", "1", false),
+		testClick("a", "2", false),
+		testClick("b", "3", true),
+		testClick("b", "4", false),
+		{WorkspaceID: 2, URLCode: "a", ClickID: "5"},
+	}
+	want := []linkClicks{
+		{workspaceID: models.DefaultWorkspaceID, code: "a", people: 1},
+		{workspaceID: models.DefaultWorkspaceID, code: "b", people: 2, bots: 1},
+		{workspaceID: 2, code: "a", people: 1},
+	}
+
+	got := countClicks(clicks)
+	if len(got) != len(want) {
+		t.Fatalf("countClicks() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("countClicks()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestClickPipelineSpillsToWAL(t *testing.T) {
+	p := newTestPipeline(t, openTestDB(t, unreachableDSN))
+
+	// The batch can't be written, so closing the pipeline spills it
+	p.Enqueue(testClick("abc123", "", false))
+	p.Enqueue(testClick("abc123", "", true))
+	p.Close()
+
+	clicks, err := p.readWAL()
+	if err != nil {
+		t.Fatalf("readWAL() error = %v", err)
+	}
+	if len(clicks) != 2 {
+		t.Fatalf("WAL holds %d clicks, want 2", len(clicks))
+	}
+	if clicks[0].ClickID == "" || clicks[0].ClickID == clicks[1].ClickID {
+		t.Errorf("click IDs = %q, %q, want two different IDs", clicks[0].ClickID, clicks[1].ClickID)
+	}
+	if !clicks[1].IsBot {
+		t.Error("WAL lost the bot flag")
+	}
+
+	// Clicks arriving after Close go straight to the WAL
+	p.Enqueue(testClick("abc123", "late", false))
+
+	// A replay that can't reach the database keeps all of them
+	p.replayWAL()
+	clicks, err = p.readWAL()
+	if err != nil {
+		t.Fatalf("readWAL() after replay error = %v", err)
+	}
+	if len(clicks) != 3 || clicks[2].ClickID != "late" {
+		t.Errorf("WAL after failed replay = %+v, want the 3 clicks", clicks)
+	}
+}
+
+func createTestURL(t *testing.T, db *gorm.DB, url models.URL) *models.URL {
+	t.Helper()
+	url.WorkspaceID = models.DefaultWorkspaceID
+	url.OriginalURL = "https://example.com/" + url.Code
+	url.URLHash = url.Code
+	if err := db.Create(&url).Error; err != nil {
+		t.Fatalf("create url: %v", err)
+	}
+	return &url
+}
+
+func clickCounts(t *testing.T, db *gorm.DB, code string) (people, bots int64) {
+	t.Helper()
+	var url models.URL
+	if err := db.Where("code = ?", code).First(&url).Error; err != nil {
+		t.Fatalf("load url %s: %v", code, err)
+	}
+	return url.ClickCount, url.BotClickCount
+}
+
+func storedClicks(t *testing.T, db *gorm.DB) int64 {
+	t.Helper()
+	var n int64
+	if err := db.Model(&models.Click{}).Count(&n).Error; err != nil {
+		t.Fatalf("count clicks: %v", err)
+	}
+	return n
+}
+
+func TestClickPipelineWriteSkipsStoredClicks(t *testing.T) {
+	db := testDB(t)
+	createTestURL(t, db, models.URL{Code: "open"})
+	createTestURL(t, db, models.URL{Code: "budget", MaxClicks: 10})
+	listener := &recordedClicks{}
+	p := newTestPipeline(t, db, listener)
+
+	batch := []models.Click{
+		testClick("open", "c1", false),
+		testClick("open", "c2", false),
+		testClick("open", "c3", true),
+		testClick("budget", "c4", false),
+	}
+	if n, err := p.write(batch); err != nil || n != 4 {
+		t.Fatalf("write() = %d, %v, want 4 stored", n, err)
+	}
+
+	// Writing the batch again, as after a write that reported an error,
+	// stores and counts nothing
+	if n, err := p.write(batch); err != nil || n != 0 {
+		t.Fatalf("second write() = %d, %v, want 0 stored", n, err)
+	}
+	// Only the new click of a partly stored batch is stored and counted
+	if n, err := p.write([]models.Click{batch[0], testClick("open", "c5", false)}); err != nil || n != 1 {
+		t.Fatalf("third write() = %d, %v, want 1 stored", n, err)
+	}
+
+	if n := storedClicks(t, db); n != 5 {
+		t.Errorf("stored %d clicks, want 5", n)
+	}
+	if people, bots := clickCounts(t, db, "open"); people != 3 || bots != 1 {
+		t.Errorf("open link counts = %d people, %d bots, want 3 and 1", people, bots)
+	}
+	// Clicks on links with a budget were counted when the redirect reserved them
+	if people, bots := clickCounts(t, db, "budget"); people != 0 || bots != 0 {
+		t.Errorf("budget link counts = %d people, %d bots, want none", people, bots)
+	}
+
+	ids := listener.clickIDs()
+	want := []string{"c1", "c2", "c3", "c4", "c5"}
+	if len(ids) != len(want) {
+		t.Fatalf("listener got clicks %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("listener got clicks %v, want %v", ids, want)
+			break
+		}
+	}
+}
+
+func TestClickPipelineReplaysWAL(t *testing.T) {
+	db := testDB(t)
+	createTestURL(t, db, models.URL{Code: "open"})
+	listener := &recordedClicks{}
+	p := newTestPipeline(t, db, listener)
+
+	// The first click was stored, but the WAL still has it, as when it
+	// couldn't be cleared after a replay
+	clicks := []models.Click{
+		testClick("open", "w1", false),
+		testClick("open", "w2", false),
+		testClick("open", "w3", true),
+	}
+	if _, err := p.write(clicks[:1]); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	p.spill(clicks)
+
+	p.replayWAL()
+
+	if _, err := os.Stat(p.cfg.WALPath); !os.IsNotExist(err) {
+		t.Errorf("WAL still exists after replay: %v", err)
+	}
+	if n := storedClicks(t, db); n != 3 {
+		t.Errorf("stored %d clicks, want 3", n)
+	}
+	if people, bots := clickCounts(t, db, "open"); people != 2 || bots != 1 {
+		t.Errorf("counts = %d people, %d bots, want 2 and 1", people, bots)
+	}
+	if ids := listener.clickIDs(); len(ids) != 3 {
+		t.Errorf("listener got clicks %v, want each click once", ids)
+	}
+
+	// Replaying again finds nothing to do
+	p.replayWAL()
+	if n := storedClicks(t, db); n != 3 {
+		t.Errorf("stored %d clicks after second replay, want 3", n)
+	}
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"url-shortener/models"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The tests that read and write rows run against the Postgres database named
+// by TEST_DATABASE_URL, which they empty first, and are skipped without it.
+// The others use a database that refuses connections.
+
+// unreachableDSN points at a port nothing listens on
+const unreachableDSN = "host=127.0.0.1 port=1 user=test dbname=test sslmode=disable connect_timeout=1"
+
+func openTestDB(t *testing.T, dsn string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger:               logger.Default.LogMode(logger.Silent),
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// testDB returns an empty, migrated database with the default workspace, or
+// skips the test
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db := openTestDB(t, dsn)
+	if err := db.AutoMigrate(models.Tables()...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	var tables []string
+	for _, model := range models.Tables() {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatalf("parse %T: %v", model, err)
+		}
+		tables = append(tables, stmt.Table)
+	}
+	if err := db.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE").Error; err != nil {
+		t.Fatalf("empty tables: %v", err)
+	}
+	workspace := models.Workspace{ID: models.DefaultWorkspaceID, Slug: "default", Name: "Default"}
+	if err := db.Create(&workspace).Error; err != nil {
+		t.Fatalf("create workspace: %v", err)
+	}
+	return db
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"url-shortener/models"
+	"url-shortener/utils"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrDomainNotFound is returned when no domain of the workspace matches an ID
+	ErrDomainNotFound = errors.New("domain not found")
+	// ErrDomainTaken is returned for hosts already added to the workspace,
+	// verified by another workspace, or used by BASE_URL
+	ErrDomainTaken = errors.New("domain is already in use")
+	// ErrDomainNotVerified is returned when creating a link on a domain that isn't
+	// a verified domain of the workspace
+	ErrDomainNotVerified = errors.New("domain is not a verified domain of this workspace")
+	// ErrDomainVerificationFailed is returned when the verification TXT record is missing or wrong
+	ErrDomainVerificationFailed = errors.New("verification TXT record not found")
+	// ErrDomainInUse is returned when deleting a domain that still has links
+	ErrDomainInUse = errors.New("domain still has links")
+)
+
+// VerificationRecordPrefix is prepended to a host to get the name of the TXT
+// record that proves control of it
+const VerificationRecordPrefix = "_kamero-verify."
+
+// Every redirect resolves its Host header, so verified hosts are cached
+const (
+	domainCacheSize  = 4096
+	domainCacheTTL   = time.Minute
+	dnsLookupTimeout = 5 * time.Second
+)
+
+// TXTResolver looks up DNS TXT records. *net.Resolver implements it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// NewTXTResolver returns the system resolver, or one that sends every query to
+// addr (host:port) when set, such as a local DNS stub
+func NewTXTResolver(addr string) TXTResolver {
+	if addr == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+type DomainService struct {
+	db          *gorm.DB
+	resolver    TXTResolver
+	baseURL     string
+	baseHost    string
+	scheme      string // BASE_URL's, used for custom domains too
+	hosts       *utils.LRU[string, uint]
+	domains     *utils.LRU[uint, models.Domain]
+	workspaceID uint
+}
+
+// NewDomainService creates a domain service serving links without a custom
+// domain from baseURL. Create it once and share it, so verifying or deleting a
+// domain invalidates the caches redirects read from.
+func NewDomainService(db *gorm.DB, resolver TXTResolver, baseURL string) *DomainService {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	baseHost, scheme := "", "https"
+	if parsed, err := neturl.Parse(baseURL); err == nil {
+		baseHost = normalizeDomain(parsed.Hostname())
+		if parsed.Scheme == "http" {
+			scheme = "http"
+		}
+	}
+	return &DomainService{
+		db:       db,
+		resolver: resolver,
+		baseURL:  baseURL,
+		baseHost: baseHost,
+		scheme:   scheme,
+		hosts:    utils.NewLRU[string, uint](domainCacheSize, domainCacheTTL),
+		domains:  utils.NewLRU[uint, models.Domain](domainCacheSize, domainCacheTTL),
+	}
+}
+
+// ForWorkspace returns a copy of the service that only manages the domains of
+// one workspace. Host resolution and short URLs work the same on every copy.
+func (s *DomainService) ForWorkspace(workspaceID uint) *DomainService {
+	scoped := *s
+	scoped.db = workspaceScope(s.db, workspaceID)
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
+// BaseURL returns BASE_URL, where the service itself and links without a custom domain live
+func (s *DomainService) BaseURL() string {
+	return s.baseURL
+}
+
+// LinkBase returns what the short URLs of links on a domain start with: the
+// custom host with BASE_URL's scheme, or BASE_URL for domain 0 and unknown
+// domains. Plain HTTP deployments, such as local ones, thus link to custom
+// domains over HTTP too.
+func (s *DomainService) LinkBase(domainID uint) string {
+	if domainID == 0 {
+		return s.baseURL
+	}
+	domain, ok := s.domains.Get(domainID)
+	if !ok {
+		if err := unscopedDB(s.db).First(&domain, domainID).Error; err != nil {
+			return s.baseURL
+		}
+		s.domains.Set(domainID, domain)
+	}
+	return s.scheme + "://" + domain.Host
+}
+
+// ShortURL returns the short URL of a link
+func (s *DomainService) ShortURL(domainID uint, code string) string {
+	return s.LinkBase(domainID) + "/" + code
+}
+
+// ResolveHost returns the verified domain a request's Host header names, or 0
+// (BASE_URL's domain) for any other host
+func (s *DomainService) ResolveHost(host string) uint {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = normalizeDomain(host)
+	if host == "" || host == s.baseHost {
+		return 0
+	}
+
+	if id, ok := s.hosts.Get(host); ok {
+		return id
+	}
+
+	var domain models.Domain
+	err := unscopedDB(s.db).Select("id").Where("host = ? AND verified_at IS NOT NULL", host).First(&domain).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		// Don't cache lookup failures as unknown hosts
+		return 0
+	}
+	s.hosts.Set(host, domain.ID)
+	return domain.ID
+}
+
+func (s *DomainService) GetDomains() ([]models.Domain, error) {
+	var domains []models.Domain
+	err := s.db.Order("host").Find(&domains).Error
+	return domains, err
+}
+
+func (s *DomainService) GetDomain(id uint) (*models.Domain, error) {
+	var domain models.Domain
+	if err := s.db.First(&domain, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDomainNotFound
+		}
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// CreateDomain adds an unverified domain to the workspace with a fresh
+// verification token
+func (s *DomainService) CreateDomain(host string) (*models.Domain, error) {
+	host = normalizeDomain(host)
+	if host == s.baseHost {
+		return nil, ErrDomainTaken
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	domain := models.Domain{WorkspaceID: s.workspaceID, Host: host, VerificationToken: token}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Unverified hosts may be claimed by several workspaces; only one can verify
+		var existing int64
+		err := unscopedDB(tx).Model(&models.Domain{}).
+			Where("host = ? AND (workspace_id = ? OR verified_at IS NOT NULL)", host, s.workspaceID).
+			Count(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing > 0 {
+			return ErrDomainTaken
+		}
+		return tx.Create(&domain).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// VerifyDomain marks a domain verified once its TXT record holds the
+// verification token. Verifying an already verified domain is a no-op.
+func (s *DomainService) VerifyDomain(id uint) (*models.Domain, error) {
+	domain, err := s.GetDomain(id)
+	if err != nil || domain.VerifiedAt != nil {
+		return domain, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+	records, err := s.resolver.LookupTXT(ctx, VerificationRecordPrefix+domain.Host)
+	if err != nil || !containsRecord(records, domain.VerificationToken) {
+		return nil, ErrDomainVerificationFailed
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var verified int64
+		err := unscopedDB(tx).Model(&models.Domain{}).
+			Where("host = ? AND verified_at IS NOT NULL AND id <> ?", domain.Host, domain.ID).
+			Count(&verified).Error
+		if err != nil {
+			return err
+		}
+		if verified > 0 {
+			return ErrDomainTaken
+		}
+		return tx.Model(domain).Update("verified_at", &now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	domain.VerifiedAt = &now
+	s.hosts.Delete(domain.Host)
+	return domain, nil
+}
+
+// DeleteDomain removes a domain that no link, including soft-deleted ones, is served from
+func (s *DomainService) DeleteDomain(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var domain models.Domain
+		if err := tx.First(&domain, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrDomainNotFound
+			}
+			return err
+		}
+
+		var links int64
+		if err := tx.Unscoped().Model(&models.URL{}).Where("domain_id = ?", id).Count(&links).Error; err != nil {
+			return err
+		}
+		if links > 0 {
+			return ErrDomainInUse
+		}
+
+		if err := tx.Delete(&domain).Error; err != nil {
+			return err
+		}
+		s.hosts.Delete(domain.Host)
+		s.domains.Delete(domain.ID)
+		return nil
+	})
+}
+
+// containsRecord reports whether any TXT record equals value, ignoring surrounding space
+func containsRecord(records []string, value string) bool {
+	for _, record := range records {
+		if strings.TrimSpace(record) == value {
+			return true
+		}
+	}
+	return false
+}
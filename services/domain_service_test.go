@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"url-shortener/models"
+)
+
+// stubResolver answers TXT lookups from a map; other names don't exist
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func TestLinkBase(t *testing.T) {
+	db := openTestDB(t, unreachableDSN)
+	tests := []struct {
+		baseURL  string
+		domainID uint
+		want     string
+	}{
+		{"https://sho.rt/", 0, "https://sho.rt"},
+		{"https://sho.rt", 5, "https://go.acme.test"},
+		{"http://localhost:8080", 5, "http://go.acme.test"},
+		{"http://localhost:8080", 0, "http://localhost:8080"},
+		// Domains that can't be loaded fall back to BASE_URL
+		{"https://sho.rt", 6, "https://sho.rt"},
+	}
+	for _, tt := range tests {
+		s := NewDomainService(db, nil, tt.baseURL)
+		s.domains.Set(5, models.Domain{ID: 5, Host: "go.acme.test"})
+		if got := s.LinkBase(tt.domainID); got != tt.want {
+			t.Errorf("LinkBase(%d) with BASE_URL %s = %q, want %q", tt.domainID, tt.baseURL, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyDomain(t *testing.T) {
+	db := testDB(t)
+	resolver := stubResolver{}
+	s := NewDomainService(db, resolver, "https://sho.rt")
+	workspace := s.ForWorkspace(models.DefaultWorkspaceID)
+
+	domain, err := workspace.CreateDomain("Go.Acme.test")
+	if err != nil {
+		t.Fatalf("CreateDomain() error = %v", err)
+	}
+	record := VerificationRecordPrefix + "go.acme.test"
+
+	// No TXT record yet
+	if _, err := workspace.VerifyDomain(domain.ID); !errors.Is(err, ErrDomainVerificationFailed) {
+		t.Errorf("VerifyDomain() without record error = %v, want ErrDomainVerificationFailed", err)
+	}
+
+	// A record holding another token
+	resolver[record] = []string{"v=spf1 -all", "wrong-token"}
+	if _, err := workspace.VerifyDomain(domain.ID); !errors.Is(err, ErrDomainVerificationFailed) {
+		t.Errorf("VerifyDomain() with wrong token error = %v, want ErrDomainVerificationFailed", err)
+	}
+	if id := s.ResolveHost("go.acme.test"); id != 0 {
+		t.Errorf("unverified domain resolves to %d, want 0", id)
+	}
+
+	resolver[record] = []string{"v=spf1 -all", " " + domain.VerificationToken + " "}
+	verified, err := workspace.VerifyDomain(domain.ID)
+	if err != nil {
+		t.Fatalf("VerifyDomain() error = %v", err)
+	}
+	if verified.VerifiedAt == nil {
+		t.Error("verified domain has no verified_at")
+	}
+	if id := s.ResolveHost("GO.acme.test:443"); id != domain.ID {
+		t.Errorf("ResolveHost() = %d, want %d", id, domain.ID)
+	}
+
+	// Once verified, the record isn't checked again
+	delete(resolver, record)
+	if _, err := workspace.VerifyDomain(domain.ID); err != nil {
+		t.Errorf("VerifyDomain() of verified domain error = %v", err)
+	}
+}
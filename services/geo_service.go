@@ -0,0 +1,169 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"net"
+
+	"url-shortener/models"
+	"url-shortener/utils"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoResolver resolves a client IP address to a location. Implementations
+// must not call external services, as lookups run on the click path.
+type GeoResolver interface {
+	Lookup(ip string) (*models.GeoLocation, error)
+}
+
+// NewGeoResolver builds the configured resolver: MaxMind databases behind an
+// LRU cache, or a no-op resolver when no database is configured or it fails to open
+func NewGeoResolver(cityDBPath, asnDBPath string, cacheSize int) GeoResolver {
+	if cityDBPath == "" && asnDBPath == "" {
+		return NoopGeoResolver{}
+	}
+
+	resolver, err := NewMMDBGeoResolver(cityDBPath, asnDBPath)
+	if err != nil {
+		log.Printf("GeoIP disabled: %v", err)
+		return NoopGeoResolver{}
+	}
+
+	log.Printf("GeoIP enabled (city db: %q, asn db: %q)", cityDBPath, asnDBPath)
+	return NewCachedGeoResolver(resolver, cacheSize)
+}
+
+// NoopGeoResolver is used when GeoIP is not configured
+type NoopGeoResolver struct{}
+
+func (NoopGeoResolver) Lookup(ip string) (*models.GeoLocation, error) {
+	return &models.GeoLocation{}, nil
+}
+
+// MMDBGeoResolver reads offline MaxMind (GeoIP2/GeoLite2) City or Country and ASN databases
+type MMDBGeoResolver struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+type mmdbCityRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+type mmdbASNRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// NewMMDBGeoResolver opens the given database files; either path may be empty
+func NewMMDBGeoResolver(cityDBPath, asnDBPath string) (*MMDBGeoResolver, error) {
+	resolver := &MMDBGeoResolver{}
+
+	if cityDBPath != "" {
+		reader, err := maxminddb.Open(cityDBPath)
+		if err != nil {
+			return nil, err
+		}
+		resolver.city = reader
+	}
+
+	if asnDBPath != "" {
+		reader, err := maxminddb.Open(asnDBPath)
+		if err != nil {
+			resolver.Close()
+			return nil, err
+		}
+		resolver.asn = reader
+	}
+
+	return resolver, nil
+}
+
+func (r *MMDBGeoResolver) Lookup(ip string) (*models.GeoLocation, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, errors.New("invalid IP address")
+	}
+
+	location := &models.GeoLocation{}
+
+	// Private and loopback addresses are never in the databases
+	if parsed.IsPrivate() || parsed.IsLoopback() || parsed.IsUnspecified() {
+		return location, nil
+	}
+
+	if r.city != nil {
+		var record mmdbCityRecord
+		if err := r.city.Lookup(parsed, &record); err != nil {
+			return nil, err
+		}
+		location.CountryCode = record.Country.ISOCode
+		location.Country = record.Country.Names["en"]
+		if len(record.Subdivisions) > 0 {
+			location.Region = record.Subdivisions[0].Names["en"]
+		}
+		location.City = record.City.Names["en"]
+	}
+
+	if r.asn != nil {
+		var record mmdbASNRecord
+		if err := r.asn.Lookup(parsed, &record); err != nil {
+			return nil, err
+		}
+		location.ASN = record.Number
+		location.ASOrg = record.Organization
+	}
+
+	return location, nil
+}
+
+func (r *MMDBGeoResolver) Close() error {
+	var err error
+	if r.city != nil {
+		err = r.city.Close()
+	}
+	if r.asn != nil {
+		if closeErr := r.asn.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// CachedGeoResolver memoizes lookups of another resolver in an in-process LRU
+type CachedGeoResolver struct {
+	resolver GeoResolver
+	cache    *utils.LRU[string, *models.GeoLocation]
+}
+
+func NewCachedGeoResolver(resolver GeoResolver, size int) *CachedGeoResolver {
+	return &CachedGeoResolver{
+		resolver: resolver,
+		// The databases are static while the process runs, so entries never expire
+		cache: utils.NewLRU[string, *models.GeoLocation](size, 0),
+	}
+}
+
+func (r *CachedGeoResolver) Lookup(ip string) (*models.GeoLocation, error) {
+	if location, ok := r.cache.Get(ip); ok {
+		return location, nil
+	}
+
+	location, err := r.resolver.Lookup(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cache.Set(ip, location)
+	return location, nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"image"
+	"time"
+
+	"url-shortener/models"
+	"url-shortener/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxQRLogoBytes limits the size of uploaded QR logos
+const MaxQRLogoBytes = 512 * 1024
+
+// Decoded logos kept in memory, so rendering a QR code doesn't decode its logo
+const (
+	logoCacheSize = 64
+	logoCacheTTL  = time.Hour
+)
+
+// decodedLogo is a logo's image as of the logo's UpdatedAt, so replacing the
+// logo on any server makes the others decode it again
+type decodedLogo struct {
+	updatedAt time.Time
+	image     image.Image
+}
+
+type QRService struct {
+	db          *gorm.DB
+	workspaceID uint
+	decoded     *utils.LRU[uint, decodedLogo]
+}
+
+func NewQRService(db *gorm.DB) *QRService {
+	return &QRService{db: db, decoded: utils.NewLRU[uint, decodedLogo](logoCacheSize, logoCacheTTL)}
+}
+
+// ForWorkspace returns a copy of the service that only sees and stores the logos of one workspace
+func (s *QRService) ForWorkspace(workspaceID uint) *QRService {
+	return &QRService{db: workspaceScope(s.db, workspaceID), workspaceID: workspaceID, decoded: s.decoded}
+}
+
+// SaveLogo validates and stores a logo, replacing any existing logo with the same name
+func (s *QRService) SaveLogo(name string, data []byte) (*models.QRLogo, error) {
+	if name == "" || len(name) > 64 || !utils.IsValidShortCode(name) {
+		return nil, errors.New("logo name must be 1-64 letters, digits, '-' or '_'")
+	}
+	if len(data) > MaxQRLogoBytes {
+		return nil, errors.New("logo must be at most 512KB")
+	}
+
+	// Logos are scaled down once here rather than on every render
+	data, contentType, err := utils.PrepareLogo(data)
+	if err != nil {
+		return nil, err
+	}
+
+	logo := models.QRLogo{
+		WorkspaceID: s.workspaceID,
+		Name:        name,
+		ContentType: contentType,
+		Data:        data,
+	}
+
+	result := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "workspace_id"}, {Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"content_type", "data", "updated_at"}),
+	}).Create(&logo)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &logo, nil
+}
+
+func (s *QRService) GetLogo(name string) (*models.QRLogo, error) {
+	var logo models.QRLogo
+	if err := s.db.Where("name = ?", name).First(&logo).Error; err != nil {
+		return nil, err
+	}
+	return &logo, nil
+}
+
+// GetLogoImage returns a logo with its decoded image
+func (s *QRService) GetLogoImage(name string) (*models.QRLogo, image.Image, error) {
+	logo, err := s.GetLogo(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cached, ok := s.decoded.Get(logo.ID); ok && cached.updatedAt.Equal(logo.UpdatedAt) {
+		return logo, cached.image, nil
+	}
+
+	img, _, err := utils.DecodeLogo(logo.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.decoded.Set(logo.ID, decodedLogo{updatedAt: logo.UpdatedAt, image: img})
+	return logo, img, nil
+}
+
+func (s *QRService) GetLogos() ([]models.QRLogo, error) {
+	var logos []models.QRLogo
+	result := s.db.Select("id, name, content_type, created_at, updated_at").Order("name").Find(&logos)
+	return logos, result.Error
+}
+
+func (s *QRService) DeleteLogo(name string) error {
+	result := s.db.Where("name = ?", name).Delete(&models.QRLogo{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("logo not found")
+	}
+	return nil
+}
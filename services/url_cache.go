@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"url-shortener/models"
+	"url-shortener/utils"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// URLCache caches redirect lookups by domain and code (see urlCacheKey). A nil
+// URL is a negative entry, remembering that a code doesn't exist on a domain. Implementations must degrade to
+// cache misses on backend errors rather than fail the lookup.
+type URLCache interface {
+	// Get returns the cached URL and whether the code was cached at all
+	Get(code string) (*models.URL, bool)
+	// Set caches a URL, or a negative entry when url is nil
+	Set(code string, url *models.URL)
+	// Delete invalidates the given codes
+	Delete(codes ...string)
+}
+
+// NewURLCache builds the configured cache backend: "memory", "redis" or "none"
+func NewURLCache(backend string, size int, ttl, negativeTTL time.Duration, redisURL string) URLCache {
+	switch backend {
+	case "none":
+		return NoopURLCache{}
+	case "redis":
+		cache, err := NewRedisURLCache(redisURL, ttl, negativeTTL)
+		if err != nil {
+			log.Printf("Redis cache unavailable, falling back to in-memory cache: %v", err)
+			return NewMemoryURLCache(size, ttl, negativeTTL)
+		}
+		log.Printf("URL cache: redis (ttl %s)", ttl)
+		return cache
+	default:
+		log.Printf("URL cache: memory (%d entries, ttl %s)", size, ttl)
+		return NewMemoryURLCache(size, ttl, negativeTTL)
+	}
+}
+
+// NoopURLCache disables caching
+type NoopURLCache struct{}
+
+func (NoopURLCache) Get(code string) (*models.URL, bool) { return nil, false }
+func (NoopURLCache) Set(code string, url *models.URL)    {}
+func (NoopURLCache) Delete(codes ...string)              {}
+
+// MemoryURLCache is an in-process LRU with TTL and negative caching
+type MemoryURLCache struct {
+	cache       *utils.LRU[string, *models.URL]
+	negativeTTL time.Duration
+}
+
+func NewMemoryURLCache(size int, ttl, negativeTTL time.Duration) *MemoryURLCache {
+	return &MemoryURLCache{
+		cache:       utils.NewLRU[string, *models.URL](size, ttl),
+		negativeTTL: negativeTTL,
+	}
+}
+
+func (c *MemoryURLCache) Get(code string) (*models.URL, bool) {
+	url, ok := c.cache.Get(code)
+	if !ok || url == nil {
+		return nil, ok
+	}
+	// Hand out deep copies so callers can't modify the cached entry
+	return url.Clone(), true
+}
+
+func (c *MemoryURLCache) Set(code string, url *models.URL) {
+	if url == nil {
+		c.cache.SetWithTTL(code, nil, c.negativeTTL)
+		return
+	}
+	c.cache.Set(code, url.Clone())
+}
+
+func (c *MemoryURLCache) Delete(codes ...string) {
+	for _, code := range codes {
+		c.cache.Delete(code)
+	}
+}
+
+// redisMissing marks a negative entry in Redis
+const redisMissing = "-"
+
+// redisTimeout bounds every cache round trip so a slow Redis can't stall redirects
+const redisTimeout = 100 * time.Millisecond
+
+// RedisURLCache stores JSON-encoded URLs in any Redis-protocol server
+type RedisURLCache struct {
+	client      *redis.Client
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+func NewRedisURLCache(redisURL string, ttl, negativeTTL time.Duration) (*RedisURLCache, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without this, a stalled server blocks calls for the client's read
+	// timeout whatever their context's deadline
+	opts.ContextTimeoutEnabled = true
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &RedisURLCache{client: client, ttl: ttl, negativeTTL: negativeTTL}, nil
+}
+
+func (c *RedisURLCache) Get(code string) (*models.URL, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	value, err := c.client.Get(ctx, redisKey(code)).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("Redis cache get failed: %v", err)
+		}
+		return nil, false
+	}
+
+	if value == redisMissing {
+		return nil, true
+	}
+
+	var entry redisEntry
+	if err := json.Unmarshal([]byte(value), &entry); err != nil {
+		return nil, false
+	}
+	entry.URL.PasswordHash = entry.PasswordHash
+	return &entry.URL, true
+}
+
+func (c *RedisURLCache) Set(code string, url *models.URL) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	value, ttl := redisMissing, c.negativeTTL
+	if url != nil {
+		data, err := json.Marshal(redisEntry{URL: *url, PasswordHash: url.PasswordHash})
+		if err != nil {
+			return
+		}
+		value, ttl = string(data), c.ttl
+	}
+
+	if err := c.client.Set(ctx, redisKey(code), value, ttl).Err(); err != nil {
+		log.Printf("Redis cache set failed: %v", err)
+	}
+}
+
+func (c *RedisURLCache) Delete(codes ...string) {
+	if len(codes) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	keys := make([]string, len(codes))
+	for i, code := range codes {
+		keys[i] = redisKey(code)
+	}
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		log.Printf("Redis cache delete failed: %v", err)
+	}
+}
+
+func redisKey(code string) string {
+	return "url:" + code
+}
+
+// redisEntry carries fields the public JSON omits (e.g. the password hash)
+type redisEntry struct {
+	URL          models.URL `json:"url"`
+	PasswordHash string     `json:"password_hash,omitempty"`
+}
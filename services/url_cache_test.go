@@ -0,0 +1,234 @@
+package services
+
+import (
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"url-shortener/models"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func testCachedURL() *models.URL {
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	return &models.URL{
+		ID:           7,
+		Code:         "abc123",
+		OriginalURL:  "https://example.com/",
+		PasswordHash: "$2a$10$hash",
+		ExpiresAt:    &expiresAt,
+		UTM:          models.UTMParams{Source: "newsletter"},
+		Rules: []models.RedirectRule{{
+			URL:       "https://example.de/",
+			Countries: []string{"DE"},
+			Query:     map[string]string{"ref": ""},
+			Schedule:  &models.RuleSchedule{Days: []string{"mon"}},
+		}},
+		Variants: []models.LinkVariant{{Name: "a", URL: "https://example.com/a", Weight: 1}},
+	}
+}
+
+// mutate changes everything a caller could change through a cached URL
+func mutate(url *models.URL) {
+	url.OriginalURL = "https://evil.example/"
+	*url.ExpiresAt = url.ExpiresAt.Add(time.Hour)
+	url.Rules[0].URL = "https://evil.example/de"
+	url.Rules[0].Countries[0] = "FR"
+	url.Rules[0].Query["ref"] = "x"
+	url.Rules[0].Schedule.Days[0] = "sun"
+	url.Variants[0].URL = "https://evil.example/a"
+}
+
+func TestMemoryURLCache(t *testing.T) {
+	cache := NewMemoryURLCache(10, time.Minute, 50*time.Millisecond)
+
+	if url, ok := cache.Get("abc123"); ok || url != nil {
+		t.Errorf("Get() before Set = %v, %v, want a miss", url, ok)
+	}
+
+	url := testCachedURL()
+	cache.Set("abc123", url)
+	got, ok := cache.Get("abc123")
+	if !ok || !reflect.DeepEqual(got, testCachedURL()) {
+		t.Fatalf("Get() = %+v, %v, want the cached URL", got, ok)
+	}
+
+	// Neither the URL passed to Set nor the ones handed out share anything
+	// with the cached entry
+	mutate(url)
+	mutate(got)
+	if again, _ := cache.Get("abc123"); !reflect.DeepEqual(again, testCachedURL()) {
+		t.Errorf("cached URL was changed through a copy: %+v", again)
+	}
+
+	cache.Delete("abc123")
+	if _, ok := cache.Get("abc123"); ok {
+		t.Error("Get() after Delete hit")
+	}
+
+	// Negative entries expire after their own TTL
+	cache.Set("missing", nil)
+	if url, ok := cache.Get("missing"); !ok || url != nil {
+		t.Errorf("Get() of negative entry = %v, %v, want nil, true", url, ok)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := cache.Get("missing"); ok {
+		t.Error("negative entry outlived its TTL")
+	}
+}
+
+func newTestRedisCache(t *testing.T) (*RedisURLCache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	cache, err := NewRedisURLCache("redis://"+server.Addr()+"/0", time.Minute, 30*time.Second)
+	if err != nil {
+		t.Fatalf("NewRedisURLCache() error = %v", err)
+	}
+	t.Cleanup(func() { cache.client.Close() })
+	return cache, server
+}
+
+func TestRedisURLCache(t *testing.T) {
+	cache, server := newTestRedisCache(t)
+
+	if url, ok := cache.Get("abc123"); ok || url != nil {
+		t.Errorf("Get() before Set = %v, %v, want a miss", url, ok)
+	}
+
+	cache.Set("abc123", testCachedURL())
+	// The password hash isn't part of the URL's JSON, but is cached
+	got, ok := cache.Get("abc123")
+	if !ok || !reflect.DeepEqual(got, testCachedURL()) {
+		t.Fatalf("Get() = %+v, %v, want the cached URL", got, ok)
+	}
+	if ttl := server.TTL(redisKey("abc123")); ttl != time.Minute {
+		t.Errorf("TTL = %v, want 1m", ttl)
+	}
+
+	cache.Delete("abc123", "other")
+	if _, ok := cache.Get("abc123"); ok {
+		t.Error("Get() after Delete hit")
+	}
+
+	cache.Set("missing", nil)
+	if url, ok := cache.Get("missing"); !ok || url != nil {
+		t.Errorf("Get() of negative entry = %v, %v, want nil, true", url, ok)
+	}
+	server.FastForward(31 * time.Second)
+	if _, ok := cache.Get("missing"); ok {
+		t.Error("negative entry outlived its TTL")
+	}
+
+	// Entries that can't be decoded are misses
+	server.Set(redisKey("corrupt"), "{")
+	if _, ok := cache.Get("corrupt"); ok {
+		t.Error("Get() of corrupt entry hit")
+	}
+}
+
+// stallingProxy forwards connections to a Redis server until it is told to
+// stall, after which it stops passing on anything
+type stallingProxy struct {
+	listener net.Listener
+	target   string
+	stalled  atomic.Bool
+}
+
+func newStallingProxy(t *testing.T, target string) *stallingProxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	p := &stallingProxy{listener: listener, target: target}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *stallingProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	go p.copy(upstream, conn)
+	p.copy(conn, upstream)
+}
+
+func (p *stallingProxy) copy(dst, src net.Conn) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			return
+		}
+		if p.stalled.Load() {
+			continue
+		}
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+func TestRedisURLCacheTimeout(t *testing.T) {
+	server := miniredis.RunT(t)
+	proxy := newStallingProxy(t, server.Addr())
+	cache, err := NewRedisURLCache("redis://"+proxy.listener.Addr().String()+"/0", time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("NewRedisURLCache() error = %v", err)
+	}
+	defer cache.client.Close()
+	cache.Set("abc123", testCachedURL())
+
+	// Once Redis stops answering, lookups miss after redisTimeout
+	proxy.stalled.Store(true)
+	start := time.Now()
+	url, ok := cache.Get("abc123")
+	cache.Set("abc123", testCachedURL())
+	cache.Delete("abc123")
+	if ok || url != nil {
+		t.Errorf("Get() from stalled Redis = %v, %v, want a miss", url, ok)
+	}
+	if elapsed := time.Since(start); elapsed > 10*redisTimeout {
+		t.Errorf("stalled Redis held up the cache for %v", elapsed)
+	}
+}
+
+func TestNewURLCache(t *testing.T) {
+	if _, ok := NewURLCache("none", 10, time.Minute, time.Minute, "").(NoopURLCache); !ok {
+		t.Error(`NewURLCache("none") isn't a NoopURLCache`)
+	}
+	if _, ok := NewURLCache("memory", 10, time.Minute, time.Minute, "").(*MemoryURLCache); !ok {
+		t.Error(`NewURLCache("memory") isn't a MemoryURLCache`)
+	}
+
+	server := miniredis.RunT(t)
+	redisURL := "redis://" + server.Addr() + "/0"
+	cache := NewURLCache("redis", 10, time.Minute, time.Minute, redisURL)
+	if redisCache, ok := cache.(*RedisURLCache); !ok {
+		t.Error(`NewURLCache("redis") isn't a RedisURLCache`)
+	} else {
+		redisCache.client.Close()
+	}
+
+	// An unreachable Redis falls back to the in-memory cache
+	server.Close()
+	if _, ok := NewURLCache("redis", 10, time.Minute, time.Minute, redisURL).(*MemoryURLCache); !ok {
+		t.Error(`NewURLCache("redis") without a server isn't a MemoryURLCache`)
+	}
+}
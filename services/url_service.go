@@ -1,95 +1,491 @@
 package services
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"strconv"
 	"time"
 
 	"url-shortener/models"
 	"url-shortener/utils"
 
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var (
+	// ErrAliasTaken is returned when a requested custom alias is already in use
+	ErrAliasTaken = errors.New("alias is already in use")
+	// ErrExpiryInPast is returned when a link would be created already expired
+	ErrExpiryInPast = errors.New("expires_at must be in the future")
+	// ErrPasswordTooLong is returned for passwords bcrypt cannot hash
+	ErrPasswordTooLong = errors.New("password must be at most 72 bytes")
+	// ErrURLNotFound is returned when no URL matches a code
+	ErrURLNotFound = errors.New("URL not found")
+	// ErrURLNotOwned is returned when an API key accesses a URL it didn't create
+	ErrURLNotOwned = errors.New("URL was not created by this API key")
+	// ErrDuplicateURL is returned when an update would make a link identical to another one
+	ErrDuplicateURL = errors.New("another short URL already has these destinations")
+	// ErrDomainNotAllowed is returned when a destination is outside an API key's allowed domains
+	ErrDomainNotAllowed = errors.New("destination domain is not allowed for this API key")
+	// ErrUTMTooLong is returned when a UTM tag taken from the destination doesn't fit its column
+	ErrUTMTooLong = errors.New("UTM values must be at most 255 characters")
+)
+
+// maxUTMLength is the size of the utm_* columns
+const maxUTMLength = 255
+
 type URLService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	cache       URLCache
+	workspaceID uint
+}
+
+// NewURLService creates a URL service; cache may be nil to disable lookup caching.
+// Instances that modify URLs must share the cache used for redirects so they can invalidate it.
+func NewURLService(db *gorm.DB, cache URLCache) *URLService {
+	if cache == nil {
+		cache = NoopURLCache{}
+	}
+	return &URLService{db: db, cache: cache}
 }
 
-func NewURLService(db *gorm.DB) *URLService {
-	return &URLService{db: db}
+// ForWorkspace returns a copy of the service that only sees the URLs of one
+// workspace and creates new ones in it. Redirects use the unscoped service and
+// look links up by domain and code.
+func (s *URLService) ForWorkspace(workspaceID uint) *URLService {
+	scoped := *s
+	scoped.db = workspaceScope(s.db, workspaceID)
+	scoped.workspaceID = workspaceID
+	return &scoped
 }
 
 func (s *URLService) CreateShortURL(req models.ShortenRequest, apiKeyID string) (*models.URL, bool, error) {
-	// Generate hash for the URL combination
-	urlHash := utils.GenerateURLHash(
-		req.URL,
-		req.IOSRedirectURL,
-		req.AndroidRedirectURL,
-		req.DesktopRedirectURL,
-		req.MacRedirectURL,
-	)
+	url, err := s.prepareURL(req, apiKeyID)
+	if err != nil {
+		return nil, false, err
+	}
 
-	// Check if URL combination already exists
-	var existingURL models.URL
-	result := s.db.Where("url_hash = ?", urlHash).First(&existingURL)
-	if result.Error == nil {
-		// URL already exists, return the existing one
-		return &existingURL, false, nil
+	isNew, err := s.insertURL(s.db, url)
+	if err != nil {
+		return nil, false, err
 	}
 
-	// If error is not "record not found", return the error
-	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return nil, false, result.Error
+	if isNew {
+		// Drop any negative entry left by earlier lookups of this code
+		s.cache.Delete(urlCacheKey(url.DomainID, url.Code))
 	}
 
-	// Generate unique short code
-	var code string
-	var err error
+	return url, isNew, nil
+}
+
+// CheckDestinations verifies that every non-empty target URL points at a host
+// the API key may link to. A nil key (anonymous request) allows anything.
+func CheckDestinations(apiKey *models.APIKey, targets ...string) error {
+	if apiKey == nil {
+		return nil
+	}
+	for _, target := range targets {
+		if target == "" {
+			continue
+		}
+		parsed, err := neturl.Parse(target)
+		if err != nil || !apiKey.AllowsHost(parsed.Hostname()) {
+			return fmt.Errorf("%w: %s", ErrDomainNotAllowed, target)
+		}
+	}
+	return nil
+}
+
+// BatchResult is the outcome of one item of CreateShortURLs
+type BatchResult struct {
+	URL   *models.URL
+	IsNew bool
+	Err   error
+}
+
+// CreateShortURLs creates many short URLs, returning one result per request in
+// the same order. Each chunk of chunkSize requests is written in one transaction;
+// a failing item only rolls back itself, not the rest of its chunk.
+func (s *URLService) CreateShortURLs(reqs []models.ShortenRequest, apiKeyID string, chunkSize int) []BatchResult {
+	if chunkSize < 1 {
+		chunkSize = len(reqs)
+	}
+
+	results := make([]BatchResult, len(reqs))
+	for start := 0; start < len(reqs); start += chunkSize {
+		end := min(start+chunkSize, len(reqs))
+
+		var created []string
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			for i := start; i < end; i++ {
+				url, err := s.prepareURL(reqs[i], apiKeyID)
+				if err != nil {
+					results[i].Err = err
+					continue
+				}
+
+				// Nested transactions are savepoints, so one bad row doesn't abort the chunk
+				var isNew bool
+				err = tx.Transaction(func(itemTx *gorm.DB) error {
+					isNew, err = s.insertURL(itemTx, url)
+					return err
+				})
+				if err != nil {
+					results[i].Err = err
+					continue
+				}
+
+				results[i] = BatchResult{URL: url, IsNew: isNew}
+				if isNew {
+					created = append(created, urlCacheKey(url.DomainID, url.Code))
+				}
+			}
+			return nil
+		})
 
-	for {
-		code, err = utils.GenerateShortCode(6)
 		if err != nil {
-			return nil, false, err
+			// The commit failed, so nothing from this chunk was stored
+			for i := start; i < end; i++ {
+				results[i] = BatchResult{Err: err}
+			}
+			continue
 		}
+		s.cache.Delete(created...)
+	}
 
-		// Check if code already exists
-		var existingCode models.URL
-		result := s.db.Where("code = ?", code).First(&existingCode)
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			break
+	return results
+}
+
+// prepareURL validates a request and builds the URL to store, including its hash
+func (s *URLService) prepareURL(req models.ShortenRequest, apiKeyID string) (*models.URL, error) {
+	if req.Alias != "" {
+		if err := utils.ValidateAlias(req.Alias); err != nil {
+			return nil, err
 		}
 	}
 
-	url := models.URL{
-		Code:               code,
-		OriginalURL:        req.URL,
-		URLHash:            urlHash,
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrExpiryInPast
+	}
+
+	if err := utils.ValidateRules(req.Rules); err != nil {
+		return nil, err
+	}
+	if err := utils.ValidateVariants(req.Variants); err != nil {
+		return nil, err
+	}
+
+	domainID, err := s.linkDomain(req.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hand-written UTM parameters are stored as tags, so the same destination
+	// tagged in a different order or encoding is still deduplicated
+	originalURL, embedded := utils.ExtractUTM(req.URL)
+	utm := utils.MergeUTM(req.UTM, embedded)
+	if err := checkUTM(utm); err != nil {
+		return nil, err
+	}
+
+	if req.CampaignID != 0 {
+		var campaigns int64
+		if err := s.db.Model(&models.Campaign{}).Where("id = ?", req.CampaignID).Count(&campaigns).Error; err != nil {
+			return nil, err
+		}
+		if campaigns == 0 {
+			return nil, ErrCampaignNotFound
+		}
+	}
+
+	var passwordHash string
+	if req.Password != "" {
+		if len(req.Password) > 72 {
+			return nil, ErrPasswordTooLong
+		}
+		hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		passwordHash = string(hashed)
+	}
+
+	url := &models.URL{
+		WorkspaceID:        s.workspaceID,
+		DomainID:           domainID,
+		Code:               req.Alias,
+		IsCustomAlias:      req.Alias != "",
+		OriginalURL:        originalURL,
 		IOSRedirectURL:     req.IOSRedirectURL,
 		AndroidRedirectURL: req.AndroidRedirectURL,
 		DesktopRedirectURL: req.DesktopRedirectURL,
 		MacRedirectURL:     req.MacRedirectURL,
+		ExpiresAt:          req.ExpiresAt,
+		MaxClicks:          req.MaxClicks,
+		ExpiredRedirectURL: req.ExpiredRedirectURL,
+		PasswordHash:       passwordHash,
 		CreatedByAPIKey:    apiKeyID,
+		UTM:                utm,
+		CampaignID:         req.CampaignID,
+		Rules:              req.Rules,
+		Variants:           req.Variants,
+		Preview:            req.Preview,
 	}
+	url.URLHash = computeURLHash(url)
 
-	result = s.db.Create(&url)
-	if result.Error != nil {
-		return nil, false, result.Error
+	return url, nil
+}
+
+// checkUTM rejects UTM tags that don't fit their columns
+func checkUTM(utm models.UTMParams) error {
+	for _, value := range []string{utm.Source, utm.Medium, utm.Campaign, utm.Term, utm.Content} {
+		if len(value) > maxUTMLength {
+			return ErrUTMTooLong
+		}
+	}
+	return nil
+}
+
+// linkDomain returns the domain a new link is served from: the requested host,
+// which must be a verified domain of the workspace, else the workspace's
+// default domain once it is verified, else BASE_URL's (0)
+func (s *URLService) linkDomain(host string) (uint, error) {
+	requested := host != ""
+	if !requested {
+		var workspace models.Workspace
+		err := unscopedDB(s.db).Select("default_domain").First(&workspace, s.workspaceID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && workspace.DefaultDomain == "" {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		host = workspace.DefaultDomain
+	}
+
+	var domain models.Domain
+	err := s.db.Select("id").Where("host = ? AND verified_at IS NOT NULL", normalizeDomain(host)).First(&domain).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if requested {
+			return 0, fmt.Errorf("%w: %s", ErrDomainNotVerified, host)
+		}
+		return 0, nil
+	}
+	return domain.ID, err
+}
+
+// insertURL stores a prepared URL unless one with the same hash exists, in which
+// case url is replaced by the existing row. It reports whether a row was created.
+func (s *URLService) insertURL(db *gorm.DB, url *models.URL) (bool, error) {
+	// Check if URL combination already exists in the workspace
+	var existingURL models.URL
+	result := db.Where("url_hash = ?", url.URLHash).First(&existingURL)
+	if result.Error == nil {
+		// URL already exists, return the existing one
+		*url = existingURL
+		return false, nil
+	}
+
+	// If error is not "record not found", return the error
+	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return false, result.Error
+	}
+
+	var alias string
+	if url.IsCustomAlias {
+		alias = url.Code
+	}
+	code, err := s.resolveCode(db, url, alias)
+	if err != nil {
+		return false, err
+	}
+	url.Code = code
+
+	if err := db.Create(url).Error; err != nil {
+		return false, err
 	}
 
-	return &url, true, nil
+	return true, nil
 }
 
-func (s *URLService) GetURLByCode(code string) (*models.URL, error) {
+// resolveCode returns the requested alias if it is free on the URL's domain and
+// in its workspace, or a new random code
+func (s *URLService) resolveCode(db *gorm.DB, url *models.URL, alias string) (string, error) {
+	if alias != "" {
+		// Aliases are compared case-insensitively so "Spring-Sale" can't shadow "spring-sale"
+		var count int64
+		err := codeOwners(db, url).Where("LOWER(code) = LOWER(?)", alias).Count(&count).Error
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return "", ErrAliasTaken
+		}
+		return alias, nil
+	}
+
+	// Generate unique short code
+	for {
+		code, err := utils.GenerateShortCode(6)
+		if err != nil {
+			return "", err
+		}
+
+		taken, err := codeExists(db, url, code)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return code, nil
+		}
+	}
+}
+
+// codeExists checks whether a code is used on the URL's domain or in its
+// workspace, including by soft-deleted links
+func codeExists(db *gorm.DB, url *models.URL, code string) (bool, error) {
+	var count int64
+	err := codeOwners(db, url).Where("code = ?", code).Count(&count).Error
+	return count > 0, err
+}
+
+// codeOwners selects the links whose codes a new URL can't reuse: those on the
+// same domain, which share its short URLs, and those in the same workspace,
+// where the API and admin views address links by code alone
+func codeOwners(db *gorm.DB, url *models.URL) *gorm.DB {
+	return unscopedDB(db).Unscoped().Model(&models.URL{}).
+		Where("domain_id = ? OR workspace_id = ?", url.DomainID, url.WorkspaceID)
+}
+
+// computeURLHash hashes a URL's redirect targets together with the optional
+// settings (alias, expiry, password, UTM tags, campaign, rules, variants,
+// preview) that make otherwise identical links distinct
+func computeURLHash(url *models.URL) string {
+	var qualifiers []string
+	if url.IsCustomAlias {
+		qualifiers = append(qualifiers, "alias:"+url.Code)
+	}
+	if url.DomainID != 0 {
+		qualifiers = append(qualifiers, "domain:"+strconv.FormatUint(uint64(url.DomainID), 10))
+	}
+	if url.ExpiresAt != nil {
+		qualifiers = append(qualifiers, "expires:"+url.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if url.MaxClicks > 0 {
+		qualifiers = append(qualifiers, "max_clicks:"+strconv.FormatInt(url.MaxClicks, 10))
+	}
+	if url.ExpiredRedirectURL != "" {
+		qualifiers = append(qualifiers, "expired_redirect:"+url.ExpiredRedirectURL)
+	}
+	// Password hashes are salted, so protected links are never deduplicated
+	if url.PasswordHash != "" {
+		qualifiers = append(qualifiers, "password:"+url.PasswordHash)
+	}
+	for _, tag := range []struct{ name, value string }{
+		{"utm_source", url.UTM.Source},
+		{"utm_medium", url.UTM.Medium},
+		{"utm_campaign", url.UTM.Campaign},
+		{"utm_term", url.UTM.Term},
+		{"utm_content", url.UTM.Content},
+	} {
+		if tag.value != "" {
+			qualifiers = append(qualifiers, tag.name+":"+tag.value)
+		}
+	}
+	if url.CampaignID != 0 {
+		qualifiers = append(qualifiers, "campaign:"+strconv.FormatUint(uint64(url.CampaignID), 10))
+	}
+	if len(url.Rules) > 0 {
+		// Maps marshal with sorted keys, so equal rules always hash alike
+		rules, _ := json.Marshal(url.Rules)
+		qualifiers = append(qualifiers, "rules:"+string(rules))
+	}
+	for _, variant := range url.Variants {
+		qualifiers = append(qualifiers, "variant:"+variant.Name+":"+strconv.Itoa(variant.Weight)+":"+variant.URL)
+	}
+	for _, tag := range []struct{ name, value string }{
+		{"og_title", url.Preview.Title},
+		{"og_description", url.Preview.Description},
+		{"og_image", url.Preview.Image},
+	} {
+		if tag.value != "" {
+			qualifiers = append(qualifiers, tag.name+":"+tag.value)
+		}
+	}
+
+	return utils.GenerateURLHash(
+		url.OriginalURL,
+		url.IOSRedirectURL,
+		url.AndroidRedirectURL,
+		url.DesktopRedirectURL,
+		url.MacRedirectURL,
+		qualifiers...,
+	)
+}
+
+// GetURLByCode looks up the link with a code on a domain (0 for BASE_URL's),
+// served from the cache when possible
+func (s *URLService) GetURLByCode(domainID uint, code string) (*models.URL, error) {
+	key := urlCacheKey(domainID, code)
+	if url, ok := s.cache.Get(key); ok {
+		// The cache is shared by every workspace
+		if url == nil || s.workspaceID != 0 && url.WorkspaceID != s.workspaceID {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return url, nil
+	}
+
 	var url models.URL
-	result := s.db.Where("code = ?", code).First(&url)
+	result := s.db.Where("domain_id = ? AND code = ?", domainID, code).First(&url)
 	if result.Error != nil {
+		// A scoped miss doesn't mean the code is free in other workspaces
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) && s.workspaceID == 0 {
+			s.cache.Set(key, nil)
+		}
 		return nil, result.Error
 	}
+
+	// Links with a click budget need a fresh click_count on every visit
+	if url.MaxClicks == 0 {
+		s.cache.Set(key, &url)
+	}
+	return &url, nil
+}
+
+// GetURLByCodeIncludingDeleted looks a URL up in the database, bypassing the cache
+// and including soft-deleted rows (used for exports and admin views)
+func (s *URLService) GetURLByCodeIncludingDeleted(code string) (*models.URL, error) {
+	var url models.URL
+	if err := s.db.Unscoped().Where("code = ?", code).First(&url).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrURLNotFound
+		}
+		return nil, err
+	}
 	return &url, nil
 }
 
-func (s *URLService) IncrementClickCount(code string) error {
-	return s.db.Model(&models.URL{}).Where("code = ?", code).
-		UpdateColumn("click_count", gorm.Expr("click_count + ?", 1)).Error
+// CheckPassword verifies a visitor-supplied password against a protected URL
+func (s *URLService) CheckPassword(url *models.URL, password string) bool {
+	if url.PasswordHash == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(url.PasswordHash), []byte(password)) == nil
+}
+
+// ReserveClick counts a visit to a link with a click budget right away, so
+// concurrent visitors can't overshoot max_clicks. It returns false once the
+// budget is used up. Bots' visits count as well, in click_count, and the click
+// pipeline doesn't count these links' clicks again.
+func (s *URLService) ReserveClick(url *models.URL) (bool, error) {
+	result := s.db.Model(&models.URL{}).Where("id = ? AND click_count < max_clicks", url.ID).
+		UpdateColumn("click_count", gorm.Expr("click_count + 1"))
+	return result.RowsAffected == 1, result.Error
 }
 
 func (s *URLService) GetURLsByAPIKey(apiKeyID string) ([]models.URL, error) {
@@ -158,8 +554,9 @@ func (s *URLService) SoftDeleteURL(code string) error {
 	if result.Error != nil {
 		return result.Error
 	}
+	s.invalidate(code)
 	if result.RowsAffected == 0 {
-		return errors.New("URL not found")
+		return ErrURLNotFound
 	}
 	return nil
 }
@@ -170,8 +567,9 @@ func (s *URLService) RestoreURL(code string) error {
 	if result.Error != nil {
 		return result.Error
 	}
+	s.invalidate(code)
 	if result.RowsAffected == 0 {
-		return errors.New("URL not found")
+		return ErrURLNotFound
 	}
 	return nil
 }
@@ -195,6 +593,7 @@ func (s *URLService) GetDeletedURLs(offset, limit int) ([]models.URL, int64, err
 // BulkDeleteURLs deletes multiple URLs
 func (s *URLService) BulkDeleteURLs(codes []string) (int64, error) {
 	result := s.db.Where("code IN ?", codes).Delete(&models.URL{})
+	s.invalidate(codes...)
 	return result.RowsAffected, result.Error
 }
 
@@ -203,6 +602,7 @@ func (s *URLService) BulkRestoreURLs(codes []string) (int64, error) {
 	result := s.db.Unscoped().Model(&models.URL{}).
 		Where("code IN ? AND deleted_at IS NOT NULL", codes).
 		Update("deleted_at", nil)
+	s.invalidate(codes...)
 	return result.RowsAffected, result.Error
 }
 
@@ -212,12 +612,117 @@ func (s *URLService) UpdateURL(code string, updates models.URL) error {
 	if result.Error != nil {
 		return result.Error
 	}
+	s.invalidate(code)
 	if result.RowsAffected == 0 {
-		return errors.New("URL not found")
+		return ErrURLNotFound
 	}
 	return nil
 }
 
+// GetOwnedURL returns a URL created by the given API key, optionally including soft-deleted ones
+func (s *URLService) GetOwnedURL(code, apiKeyID string, includeDeleted bool) (*models.URL, error) {
+	query := s.db
+	if includeDeleted {
+		query = query.Unscoped()
+	}
+
+	var url models.URL
+	if err := query.Where("code = ?", code).First(&url).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrURLNotFound
+		}
+		return nil, err
+	}
+
+	if url.CreatedByAPIKey == "" || url.CreatedByAPIKey != apiKeyID {
+		return nil, ErrURLNotOwned
+	}
+
+	return &url, nil
+}
+
+// PatchURL applies a partial update of the redirect targets, rules, UTM tags,
+// variants and preview and recomputes the URL hash
+func (s *URLService) PatchURL(code string, req models.UpdateURLRequest) (*models.URL, error) {
+	var url models.URL
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", code).First(&url).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrURLNotFound
+			}
+			return err
+		}
+
+		var embedded models.UTMParams
+		if req.URL != nil {
+			url.OriginalURL, embedded = utils.ExtractUTM(*req.URL)
+		}
+		// As on creation, a utm object wins over tags written into url. Without
+		// one, tags in the new url override the stored ones.
+		if req.UTM != nil {
+			url.UTM = utils.MergeUTM(*req.UTM, embedded)
+		} else {
+			url.UTM = utils.MergeUTM(embedded, url.UTM)
+		}
+		if err := checkUTM(url.UTM); err != nil {
+			return err
+		}
+		if req.IOSRedirectURL != nil {
+			url.IOSRedirectURL = *req.IOSRedirectURL
+		}
+		if req.AndroidRedirectURL != nil {
+			url.AndroidRedirectURL = *req.AndroidRedirectURL
+		}
+		if req.DesktopRedirectURL != nil {
+			url.DesktopRedirectURL = *req.DesktopRedirectURL
+		}
+		if req.MacRedirectURL != nil {
+			url.MacRedirectURL = *req.MacRedirectURL
+		}
+		if req.Rules != nil {
+			if err := utils.ValidateRules(*req.Rules); err != nil {
+				return err
+			}
+			url.Rules = *req.Rules
+		}
+		if req.Variants != nil {
+			if err := utils.ValidateVariants(*req.Variants); err != nil {
+				return err
+			}
+			url.Variants = *req.Variants
+		}
+		if req.Preview != nil {
+			url.Preview = *req.Preview
+		}
+
+		// The hash must stay unique across the workspace's links, including soft-deleted ones
+		url.URLHash = computeURLHash(&url)
+		var conflicts int64
+		if err := tx.Unscoped().Model(&models.URL{}).
+			Where("url_hash = ? AND code <> ?", url.URLHash, code).
+			Count(&conflicts).Error; err != nil {
+			return err
+		}
+		if conflicts > 0 {
+			return ErrDuplicateURL
+		}
+
+		return tx.Model(&url).Select(
+			"original_url", "ios_redirect_url", "android_redirect_url",
+			"desktop_redirect_url", "mac_redirect_url", "url_hash",
+			"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content", "rules", "variants",
+			"og_title", "og_description", "og_image",
+		).Updates(&url).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Delete(urlCacheKey(url.DomainID, url.Code))
+	return &url, nil
+}
+
 // GetURLStats returns basic statistics for a URL
 func (s *URLService) GetURLStats(code string) (*models.URLStats, error) {
 	var url models.URL
@@ -385,11 +890,11 @@ func (s *URLService) CleanupExpiredURLs(daysInactive int) (int64, error) {
 	cutoffDate := time.Now().AddDate(0, 0, -daysInactive)
 
 	// Find URLs that haven't been clicked since cutoff date and have no recent clicks
+	// One grouped condition, so the OR can't escape the workspace scope
 	var expiredURLs []string
 	s.db.Model(&models.URL{}).
 		Select("code").
-		Where("click_count = 0 AND created_at < ?", cutoffDate).
-		Or("code NOT IN (SELECT DISTINCT url_code FROM clicks WHERE clicked_at > ?)", cutoffDate).
+		Where("(click_count = 0 AND created_at < ?) OR code NOT IN (SELECT DISTINCT url_code FROM clicks WHERE clicked_at > ?)", cutoffDate, cutoffDate).
 		Pluck("code", &expiredURLs)
 
 	if len(expiredURLs) == 0 {
@@ -398,6 +903,7 @@ func (s *URLService) CleanupExpiredURLs(daysInactive int) (int64, error) {
 
 	// Soft delete expired URLs
 	result := s.db.Where("code IN ?", expiredURLs).Delete(&models.URL{})
+	s.invalidate(expiredURLs...)
 	return result.RowsAffected, result.Error
 }
 
@@ -441,3 +947,67 @@ func (s *URLService) GetURLUsageReport() (*models.URLUsageReport, error) {
 
 	return &report, nil
 }
+
+// urlCacheKey is the redirect cache key of a code on a domain. Links on
+// BASE_URL's domain keep plain codes as keys; ':' never appears in codes.
+func urlCacheKey(domainID uint, code string) string {
+	if domainID == 0 {
+		return code
+	}
+	return strconv.FormatUint(uint64(domainID), 10) + ":" + code
+}
+
+// invalidate drops the cached lookups of the given codes on every domain the
+// service's links with those codes are served from
+func (s *URLService) invalidate(codes ...string) {
+	if len(codes) == 0 {
+		return
+	}
+
+	var links []models.URL
+	if err := s.db.Unscoped().Select("domain_id", "code").Where("code IN ?", codes).Find(&links).Error; err != nil {
+		log.Printf("Failed to look up domains of invalidated codes: %v", err)
+		s.cache.Delete(codes...)
+		return
+	}
+
+	keys := make([]string, len(links))
+	for i, link := range links {
+		keys[i] = urlCacheKey(link.DomainID, link.Code)
+	}
+	s.cache.Delete(keys...)
+}
+
+// URLDetail converts a URL to its API form, with short URLs under baseURL
+func URLDetail(url *models.URL, baseURL string) models.URLDetailResponse {
+	detail := models.URLDetailResponse{
+		Code:               url.Code,
+		ShortURL:           baseURL + "/" + url.Code,
+		OriginalURL:        url.OriginalURL,
+		IOSRedirectURL:     url.IOSRedirectURL,
+		AndroidRedirectURL: url.AndroidRedirectURL,
+		DesktopRedirectURL: url.DesktopRedirectURL,
+		MacRedirectURL:     url.MacRedirectURL,
+		ClickCount:         url.ClickCount,
+		BotClickCount:      url.BotClickCount,
+		ExpiresAt:          url.ExpiresAt,
+		MaxClicks:          url.MaxClicks,
+		ExpiredRedirectURL: url.ExpiredRedirectURL,
+		Protected:          url.PasswordHash != "",
+		UTM:                url.UTM,
+		CampaignID:         url.CampaignID,
+		Rules:              url.Rules,
+		Variants:           url.Variants,
+		CreatedAt:          url.CreatedAt,
+		UpdatedAt:          url.UpdatedAt,
+	}
+	if url.Preview.IsSet() {
+		preview := url.Preview
+		detail.Preview = &preview
+	}
+	if url.DeletedAt.Valid {
+		deletedAt := url.DeletedAt.Time
+		detail.DeletedAt = &deletedAt
+	}
+	return detail
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"url-shortener/models"
+)
+
+func TestReserveClickDatabaseError(t *testing.T) {
+	s := NewURLService(openTestDB(t, unreachableDSN), nil)
+	reserved, err := s.ReserveClick(&models.URL{ID: 1, MaxClicks: 1})
+	if err == nil || reserved {
+		t.Errorf("ReserveClick() = %v, %v, want an error", reserved, err)
+	}
+}
+
+func TestReserveClick(t *testing.T) {
+	db := testDB(t)
+	url := createTestURL(t, db, models.URL{Code: "budget", MaxClicks: 3})
+	s := NewURLService(db, nil)
+
+	// More visitors than the budget allows arrive at once
+	var wg sync.WaitGroup
+	var reserved atomic.Int64
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := s.ReserveClick(url)
+			if err != nil {
+				t.Errorf("ReserveClick() error = %v", err)
+			}
+			if ok {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := reserved.Load(); n != 3 {
+		t.Errorf("%d clicks reserved, want 3", n)
+	}
+	if people, _ := clickCounts(t, db, "budget"); people != 3 {
+		t.Errorf("click_count = %d, want 3", people)
+	}
+	if ok, err := s.ReserveClick(url); ok || err != nil {
+		t.Errorf("ReserveClick() on a used up budget = %v, %v, want false", ok, err)
+	}
+}
@@ -0,0 +1,557 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"url-shortener/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	webhookPollInterval  = time.Second
+	webhookSweepInterval = time.Minute
+	webhookClaimBatch    = 100
+	webhookMaxBackoff    = 6 * time.Hour
+	webhookExpiredBatch  = 500
+	webhookErrorLength   = 500
+)
+
+type WebhookConfig struct {
+	Workers      int           // Concurrent deliveries
+	Timeout      time.Duration // Per-request timeout
+	MaxAttempts  int           // Attempts before a delivery goes to the dead-letter table
+	BackoffBase  time.Duration // Delay after the first failure, doubled after each one
+	LogRetention time.Duration // How long finished deliveries are kept in the log
+
+	// AllowPrivateNetworks lets webhooks reach loopback, link-local and
+	// private addresses, e.g. in tests. Otherwise editors could make the
+	// server POST to internal services and cloud metadata endpoints.
+	AllowPrivateNetworks bool
+}
+
+// WebhookDispatcher queues webhook events in webhook_deliveries and sends them
+// in the background. Deliveries are claimed with SKIP LOCKED, so several
+// instances can share the queue. It also implements ClickListener.
+type WebhookDispatcher struct {
+	db      *gorm.DB
+	domains *DomainService
+	cfg     WebhookConfig
+	client  *http.Client
+
+	wake   chan struct{}
+	stop   chan struct{}
+	ctx    context.Context // Cancelled by Close to abort requests in flight
+	cancel context.CancelFunc
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+func NewWebhookDispatcher(db *gorm.DB, domains *DomainService, cfg WebhookConfig) *WebhookDispatcher {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.BackoffBase <= 0 {
+		cfg.BackoffBase = 30 * time.Second
+	}
+
+	d := &WebhookDispatcher{
+		db:      db,
+		domains: domains,
+		cfg:     cfg,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: webhookTransport(cfg.AllowPrivateNetworks),
+			// A redirect is reported as a failed delivery rather than followed
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	d.wg.Add(1)
+	go d.loop()
+
+	return d
+}
+
+// webhookTransport is http.DefaultTransport, refusing connections to anything
+// but public addresses unless allowPrivate is set. The addresses are checked
+// after DNS resolution, so a public name pointing at a private address is
+// refused too. Proxies are bypassed, as they would connect on our behalf.
+func webhookTransport(allowPrivate bool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if allowPrivate {
+		return transport
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		},
+	}
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// isPublicIP reports whether ip is outside the loopback, link-local, private,
+// multicast and unspecified ranges
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsPrivate() && !ip.IsUnspecified()
+}
+
+// Wake makes the dispatcher look for due deliveries now instead of at the next poll
+func (d *WebhookDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the dispatcher, aborting deliveries in flight. They are retried
+// once their claim expires, and pending ones stay queued for the next start.
+func (d *WebhookDispatcher) Close() {
+	d.once.Do(func() {
+		close(d.stop)
+		d.cancel()
+	})
+	d.wg.Wait()
+}
+
+func (d *WebhookDispatcher) loop() {
+	defer d.wg.Done()
+
+	poll := time.NewTicker(webhookPollInterval)
+	defer poll.Stop()
+	sweep := time.NewTicker(webhookSweepInterval)
+	defer sweep.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-poll.C:
+			d.deliverDue()
+		case <-d.wake:
+			d.deliverDue()
+		case <-sweep.C:
+			d.notifyExpired()
+			d.pruneLogs()
+		}
+	}
+}
+
+// LinkEvent queues event for the webhooks of the API key that created url.
+// Anonymous and admin-created links have no webhooks.
+func (d *WebhookDispatcher) LinkEvent(event string, url *models.URL) {
+	if url.CreatedByAPIKey == "" {
+		return
+	}
+	if event == models.EventLinkDeleted && !url.DeletedAt.Valid {
+		deleted := *url
+		deleted.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		url = &deleted
+	}
+
+	webhooks, err := d.subscribers(url.WorkspaceID, []string{url.CreatedByAPIKey}, event)
+	if err != nil {
+		log.Printf("Failed to find webhooks for %s of %s: %v", event, url.Code, err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(models.WebhookEvent{
+		Event:      event,
+		OccurredAt: time.Now().UTC(),
+		Data:       URLDetail(url, d.domains.LinkBase(url.DomainID)),
+	})
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event, err)
+		return
+	}
+
+	d.enqueue(webhooks, event, payload)
+}
+
+// ClicksRecorded queues click.recorded events for a stored batch of clicks:
+// one delivery per subscribed webhook holding the sampled clicks of its key's
+// links. Bot clicks are only sent to webhooks that include bots.
+func (d *WebhookDispatcher) ClicksRecorded(clicks []models.Click) {
+	byWorkspace := make(map[uint][]models.Click)
+	for _, click := range clicks {
+		workspaceID := click.WorkspaceID
+		if workspaceID == 0 {
+			workspaceID = models.DefaultWorkspaceID
+		}
+		byWorkspace[workspaceID] = append(byWorkspace[workspaceID], click)
+	}
+
+	for workspaceID, clicks := range byWorkspace {
+		if err := d.clicksRecorded(workspaceID, clicks); err != nil {
+			log.Printf("Failed to queue click.recorded events: %v", err)
+		}
+	}
+}
+
+func (d *WebhookDispatcher) clicksRecorded(workspaceID uint, clicks []models.Click) error {
+	codes := make([]string, 0, len(clicks))
+	for _, click := range clicks {
+		codes = append(codes, click.URLCode)
+	}
+
+	var links []models.URL
+	err := unscopedDB(d.db).Unscoped().Select("code", "created_by_api_key").
+		Where("workspace_id = ? AND code IN ? AND created_by_api_key <> ''", workspaceID, codes).
+		Find(&links).Error
+	if err != nil || len(links) == 0 {
+		return err
+	}
+
+	keyOf := make(map[string]string, len(links))
+	keyIDs := make([]string, 0, len(links))
+	for _, link := range links {
+		keyOf[link.Code] = link.CreatedByAPIKey
+		keyIDs = append(keyIDs, link.CreatedByAPIKey)
+	}
+
+	webhooks, err := d.subscribers(workspaceID, keyIDs, models.EventClickRecorded)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, webhook := range webhooks {
+		var sampled []models.Click
+		for _, click := range clicks {
+			if keyOf[click.URLCode] != webhook.APIKeyID || click.IsBot && !webhook.IncludeBots {
+				continue
+			}
+			if rand.Float64() < webhook.ClickSampleRate {
+				sampled = append(sampled, click)
+			}
+		}
+		if len(sampled) == 0 {
+			continue
+		}
+
+		payload, err := json.Marshal(models.WebhookEvent{
+			Event:      models.EventClickRecorded,
+			OccurredAt: now,
+			Data:       models.WebhookClicks{Clicks: sampled},
+		})
+		if err != nil {
+			return err
+		}
+		d.enqueue([]models.Webhook{webhook}, models.EventClickRecorded, payload)
+	}
+	return nil
+}
+
+// subscribers returns the active webhooks of active API keys that receive event
+func (d *WebhookDispatcher) subscribers(workspaceID uint, keyIDs []string, event string) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	err := workspaceScope(d.db, workspaceID).
+		Where("api_key_id IN ? AND is_active = ?", keyIDs, true).
+		Where("EXISTS (SELECT 1 FROM api_keys WHERE api_keys.key_id = webhooks.api_key_id AND api_keys.is_active = ? AND api_keys.deleted_at IS NULL)", true).
+		Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	subscribed := webhooks[:0]
+	for _, webhook := range webhooks {
+		if webhook.Subscribes(event) {
+			subscribed = append(subscribed, webhook)
+		}
+	}
+	return subscribed, nil
+}
+
+func (d *WebhookDispatcher) enqueue(webhooks []models.Webhook, event string, payload json.RawMessage) {
+	now := time.Now()
+	deliveries := make([]models.WebhookDelivery, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			WorkspaceID:   webhook.WorkspaceID,
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Payload:       payload,
+			Status:        models.DeliveryPending,
+			NextAttemptAt: now,
+		})
+	}
+	if err := d.db.Create(&deliveries).Error; err != nil {
+		log.Printf("Failed to queue %d %s deliveries: %v", len(deliveries), event, err)
+		return
+	}
+	d.Wake()
+}
+
+// deliverDue sends every delivery whose next attempt is due, at most
+// cfg.Workers at a time
+func (d *WebhookDispatcher) deliverDue() {
+	for {
+		deliveries, err := d.claim()
+		if err != nil {
+			log.Printf("Failed to claim webhook deliveries: %v", err)
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+
+		sem := make(chan struct{}, d.cfg.Workers)
+		var wg sync.WaitGroup
+	claimed:
+		for i := range deliveries {
+			// Deliveries not started when the dispatcher stops keep their
+			// claim until it expires
+			select {
+			case sem <- struct{}{}:
+			case <-d.stop:
+				break claimed
+			}
+			wg.Add(1)
+			go func(delivery *models.WebhookDelivery) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				d.attempt(delivery)
+			}(&deliveries[i])
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookClaimBatch {
+			return
+		}
+		select {
+		case <-d.stop:
+			return
+		default:
+		}
+	}
+}
+
+// claim locks a batch of due deliveries and pushes their next attempt past the
+// request timeout, so other instances skip them while they are in flight
+func (d *WebhookDispatcher) claim() ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+			Order("next_attempt_at").Limit(webhookClaimBatch).
+			Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+		lease := now.Add(d.cfg.Timeout + 30*time.Second)
+		return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", lease).Error
+	})
+	return deliveries, err
+}
+
+// attempt sends a delivery once and records the outcome
+func (d *WebhookDispatcher) attempt(delivery *models.WebhookDelivery) {
+	var webhook models.Webhook
+	err := d.db.First(&webhook, delivery.WebhookID).Error
+	if err == nil && !webhook.IsActive {
+		err = errors.New("webhook is inactive")
+	}
+	if err != nil {
+		d.fail(delivery, 0, err.Error(), true)
+		return
+	}
+
+	statusCode, err := d.send(&webhook, delivery)
+	if d.ctx.Err() != nil {
+		// Aborted by Close, which isn't the endpoint's failure
+		return
+	}
+	if err == nil {
+		now := time.Now()
+		err := d.db.Model(delivery).Updates(map[string]interface{}{
+			"status":           models.DeliverySucceeded,
+			"attempts":         delivery.Attempts + 1,
+			"last_status_code": statusCode,
+			"last_error":       "",
+			"delivered_at":     &now,
+		}).Error
+		if err != nil {
+			log.Printf("Failed to record webhook delivery %d: %v", delivery.ID, err)
+		}
+		return
+	}
+	d.fail(delivery, statusCode, err.Error(), false)
+}
+
+// send POSTs the payload, signed with the webhook's secret. Any 2xx response is a success.
+func (d *WebhookDispatcher) send(webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "kamero-url-shortener-webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhook(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// fail records a failed attempt and schedules the next one, or moves the
+// delivery to the dead-letter table once it is out of attempts (or final is set)
+func (d *WebhookDispatcher) fail(delivery *models.WebhookDelivery, statusCode int, message string, final bool) {
+	if len(message) > webhookErrorLength {
+		message = message[:webhookErrorLength]
+	}
+	attempts := delivery.Attempts + 1
+
+	if !final && attempts < d.cfg.MaxAttempts {
+		backoff := d.cfg.BackoffBase << (attempts - 1)
+		if backoff <= 0 || backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+		err := d.db.Model(delivery).Updates(map[string]interface{}{
+			"attempts":         attempts,
+			"last_status_code": statusCode,
+			"last_error":       message,
+			"next_attempt_at":  time.Now().Add(backoff),
+		}).Error
+		if err != nil {
+			log.Printf("Failed to record webhook delivery %d: %v", delivery.ID, err)
+		}
+		return
+	}
+
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(delivery).Updates(map[string]interface{}{
+			"status":           models.DeliveryFailed,
+			"attempts":         attempts,
+			"last_status_code": statusCode,
+			"last_error":       message,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.WebhookDeadLetter{
+			WorkspaceID:    delivery.WorkspaceID,
+			WebhookID:      delivery.WebhookID,
+			DeliveryID:     delivery.ID,
+			Event:          delivery.Event,
+			Payload:        delivery.Payload,
+			Attempts:       attempts,
+			LastStatusCode: statusCode,
+			LastError:      message,
+		}).Error
+	})
+	if err != nil {
+		log.Printf("Failed to dead-letter webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// notifyExpired sends link.expired for links that ran out of time or clicks
+// since the last sweep. Each link is claimed with a conditional update, so
+// only one instance sends the event.
+func (d *WebhookDispatcher) notifyExpired() {
+	now := time.Now()
+	var links []models.URL
+	err := d.db.Where("expiry_notified_at IS NULL AND created_by_api_key <> ''").
+		Where("((expires_at IS NOT NULL AND expires_at <= ?) OR (max_clicks > 0 AND click_count >= max_clicks))", now).
+		Where(`EXISTS (SELECT 1 FROM webhooks WHERE webhooks.workspace_id = urls.workspace_id AND webhooks.api_key_id = urls.created_by_api_key AND webhooks.is_active = ? AND webhooks.events LIKE ?)`,
+			true, `%"`+models.EventLinkExpired+`"%`).
+		Limit(webhookExpiredBatch).Find(&links).Error
+	if err != nil {
+		log.Printf("Failed to find expired links: %v", err)
+		return
+	}
+
+	for i := range links {
+		result := d.db.Model(&models.URL{}).Where("id = ? AND expiry_notified_at IS NULL", links[i].ID).
+			UpdateColumn("expiry_notified_at", now)
+		if result.Error != nil {
+			log.Printf("Failed to mark %s expired: %v", links[i].Code, result.Error)
+			continue
+		}
+		if result.RowsAffected == 1 {
+			d.LinkEvent(models.EventLinkExpired, &links[i])
+		}
+	}
+}
+
+// pruneLogs deletes finished deliveries older than the retention period
+func (d *WebhookDispatcher) pruneLogs() {
+	if d.cfg.LogRetention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-d.cfg.LogRetention)
+	err := d.db.Where("status <> ? AND created_at < ?", models.DeliveryPending, cutoff).
+		Delete(&models.WebhookDelivery{}).Error
+	if err != nil {
+		log.Printf("Failed to prune webhook deliveries: %v", err)
+	}
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "timestamp.payload" under secret,
+// the value of X-Webhook-Signature after "sha256="
+func SignWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"url-shortener/models"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// Names are checked by the addresses they resolve to
+	endpoint := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	client := &http.Client{Transport: webhookTransport(false)}
+	if resp, err := client.Post(endpoint, "application/json", nil); err == nil {
+		resp.Body.Close()
+		t.Fatal("webhook transport connected to a loopback address")
+	} else if !strings.Contains(err.Error(), "is not public") {
+		t.Errorf("error = %v, want the address refused", err)
+	}
+
+	client = &http.Client{Transport: webhookTransport(true)}
+	resp, err := client.Post(endpoint, "application/json", nil)
+	if err != nil {
+		t.Fatalf("webhook transport allowing private networks: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+}
+
+func TestWebhookDispatcherCloseAbortsDeliveries(t *testing.T) {
+	db := testDB(t)
+
+	// The endpoint doesn't answer until the test ends
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	webhook := models.Webhook{WorkspaceID: models.DefaultWorkspaceID, APIKeyID: "key", URL: server.URL, Events: models.WebhookEvents, IsActive: true}
+	if err := db.Create(&webhook).Error; err != nil {
+		t.Fatalf("create webhook: %v", err)
+	}
+	d := NewWebhookDispatcher(db, nil, WebhookConfig{Timeout: time.Minute, MaxAttempts: 3, AllowPrivateNetworks: true})
+	d.enqueue([]models.Webhook{webhook}, models.EventLinkCreated, json.RawMessage(`{}`))
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		d.Close()
+		t.Fatal("delivery wasn't sent")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		d.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() waited for the delivery in flight")
+	}
+
+	// The aborted attempt isn't held against the endpoint
+	var delivery models.WebhookDelivery
+	if err := db.First(&delivery).Error; err != nil {
+		t.Fatalf("load delivery: %v", err)
+	}
+	if delivery.Status != models.DeliveryPending || delivery.Attempts != 0 {
+		t.Errorf("delivery = %s after %d attempts, want pending after 0", delivery.Status, delivery.Attempts)
+	}
+}
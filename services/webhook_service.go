@@ -0,0 +1,246 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"url-shortener/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrWebhookNotFound is returned when no webhook of the workspace matches an ID
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrDeadLetterNotFound is returned when no dead letter of the webhook matches an ID
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+)
+
+type WebhookService struct {
+	db          *gorm.DB
+	dispatcher  *WebhookDispatcher
+	workspaceID uint
+}
+
+func NewWebhookService(db *gorm.DB, dispatcher *WebhookDispatcher) *WebhookService {
+	return &WebhookService{db: db, dispatcher: dispatcher}
+}
+
+// ForWorkspace returns a copy of the service that only manages the webhooks of
+// one workspace's API keys
+func (s *WebhookService) ForWorkspace(workspaceID uint) *WebhookService {
+	scoped := *s
+	scoped.db = workspaceScope(s.db, workspaceID)
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
+// CreateWebhook subscribes a URL to events of an API key's links. The returned
+// webhook holds its signing secret, which is never shown again.
+func (s *WebhookService) CreateWebhook(apiKeyID string, req models.WebhookRequest) (*models.Webhook, error) {
+	var keys int64
+	if err := s.db.Model(&models.APIKey{}).Where("key_id = ?", apiKeyID).Count(&keys).Error; err != nil {
+		return nil, err
+	}
+	if keys == 0 {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	webhook := models.Webhook{
+		WorkspaceID:     s.workspaceID,
+		APIKeyID:        apiKeyID,
+		URL:             req.URL,
+		Secret:          secret,
+		Events:          req.Events,
+		ClickSampleRate: 1,
+		IncludeBots:     req.IncludeBots,
+		IsActive:        true,
+	}
+	if req.ClickSampleRate != nil {
+		webhook.ClickSampleRate = *req.ClickSampleRate
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&webhook).Error; err != nil {
+			return err
+		}
+		return s.skipExpired(tx, &webhook)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// skipExpired marks the key's links that expired before the webhook subscribed
+// to link.expired as notified, so it isn't sent a backlog of old expiries
+func (s *WebhookService) skipExpired(tx *gorm.DB, webhook *models.Webhook) error {
+	if !webhook.IsActive || !webhook.Subscribes(models.EventLinkExpired) {
+		return nil
+	}
+	now := time.Now()
+	return tx.Model(&models.URL{}).
+		Where("created_by_api_key = ? AND expiry_notified_at IS NULL", webhook.APIKeyID).
+		Where("((expires_at IS NOT NULL AND expires_at <= ?) OR (max_clicks > 0 AND click_count >= max_clicks))", now).
+		UpdateColumn("expiry_notified_at", now).Error
+}
+
+// GetWebhooks lists the webhooks of an API key
+func (s *WebhookService) GetWebhooks(apiKeyID string) ([]models.Webhook, error) {
+	webhooks := []models.Webhook{}
+	err := s.db.Where("api_key_id = ?", apiKeyID).Order("id").Find(&webhooks).Error
+	return webhooks, err
+}
+
+func (s *WebhookService) GetWebhook(id uint) (*models.Webhook, error) {
+	var webhook models.Webhook
+	if err := s.db.First(&webhook, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// UpdateWebhook applies the fields set in req
+func (s *WebhookService) UpdateWebhook(id uint, req models.WebhookUpdateRequest) (*models.Webhook, error) {
+	webhook, err := s.GetWebhook(id)
+	if err != nil {
+		return nil, err
+	}
+	wasExpiring := webhook.IsActive && webhook.Subscribes(models.EventLinkExpired)
+
+	var columns []string
+	if req.URL != nil {
+		webhook.URL = *req.URL
+		columns = append(columns, "url")
+	}
+	if req.Events != nil {
+		webhook.Events = *req.Events
+		columns = append(columns, "events")
+	}
+	if req.ClickSampleRate != nil {
+		webhook.ClickSampleRate = *req.ClickSampleRate
+		columns = append(columns, "click_sample_rate")
+	}
+	if req.IncludeBots != nil {
+		webhook.IncludeBots = *req.IncludeBots
+		columns = append(columns, "include_bots")
+	}
+	if req.IsActive != nil {
+		webhook.IsActive = *req.IsActive
+		columns = append(columns, "is_active")
+	}
+	if len(columns) == 0 {
+		return webhook, nil
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(webhook).Select(columns).Updates(webhook).Error; err != nil {
+			return err
+		}
+		if wasExpiring {
+			return nil
+		}
+		return s.skipExpired(tx, webhook)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// DeleteWebhook removes a webhook with its delivery log and dead letters
+func (s *WebhookService) DeleteWebhook(id uint) (*models.Webhook, error) {
+	webhook, err := s.GetWebhook(id)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("webhook_id = ?", id).Delete(&models.WebhookDeadLetter{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(webhook).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// GetDeliveries returns a page of a webhook's delivery log, newest first,
+// optionally limited to one status
+func (s *WebhookService) GetDeliveries(webhookID uint, status string, offset, limit int) ([]models.WebhookDelivery, int64, error) {
+	if _, err := s.GetWebhook(webhookID); err != nil {
+		return nil, 0, err
+	}
+
+	query := s.db.Model(&models.WebhookDelivery{}).Where("webhook_id = ?", webhookID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	err := query.Order("created_at desc, id desc").Offset(offset).Limit(limit).Find(&deliveries).Error
+	return deliveries, total, err
+}
+
+// GetDeadLetters lists the deliveries of a webhook that failed every attempt
+func (s *WebhookService) GetDeadLetters(webhookID uint) ([]models.WebhookDeadLetter, error) {
+	if _, err := s.GetWebhook(webhookID); err != nil {
+		return nil, err
+	}
+	letters := []models.WebhookDeadLetter{}
+	err := s.db.Where("webhook_id = ?", webhookID).Order("created_at desc, id desc").Find(&letters).Error
+	return letters, err
+}
+
+// RetryDeadLetter queues a dead letter as a new delivery with a fresh set of attempts
+func (s *WebhookService) RetryDeadLetter(webhookID, id uint) (*models.WebhookDelivery, error) {
+	if _, err := s.GetWebhook(webhookID); err != nil {
+		return nil, err
+	}
+
+	var delivery models.WebhookDelivery
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var letter models.WebhookDeadLetter
+		if err := tx.Where("webhook_id = ?", webhookID).First(&letter, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrDeadLetterNotFound
+			}
+			return err
+		}
+
+		delivery = models.WebhookDelivery{
+			WorkspaceID:   letter.WorkspaceID,
+			WebhookID:     letter.WebhookID,
+			Event:         letter.Event,
+			Payload:       letter.Payload,
+			Status:        models.DeliveryPending,
+			NextAttemptAt: time.Now(),
+		}
+		if err := tx.Create(&delivery).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&letter).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.dispatcher.Wake()
+	return &delivery, nil
+}
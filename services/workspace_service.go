@@ -0,0 +1,198 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"url-shortener/models"
+	"url-shortener/utils"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrWorkspaceNotFound is returned when no workspace matches an ID
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	// ErrSlugTaken is returned when creating a workspace with an existing slug
+	ErrSlugTaken = errors.New("slug is already in use")
+	// ErrInvalidSlug is returned for slugs that aren't lowercase words joined by hyphens
+	ErrInvalidSlug = errors.New("slug must be lowercase letters, digits and single hyphens")
+	// ErrUnknownDefaultDomain is returned when a default domain isn't one of the workspace's domains
+	ErrUnknownDefaultDomain = errors.New("default_domain must be one of the workspace's domains")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Workspace settings are read on every link creation and short URL response
+const (
+	workspaceCacheSize = 1024
+	workspaceCacheTTL  = time.Minute
+)
+
+type WorkspaceService struct {
+	db    *gorm.DB
+	cache *utils.LRU[uint, models.Workspace]
+}
+
+// NewWorkspaceService creates a workspace service. Create it once and share it,
+// so settings changes invalidate the cache every caller reads from.
+func NewWorkspaceService(db *gorm.DB) *WorkspaceService {
+	return &WorkspaceService{db: db, cache: utils.NewLRU[uint, models.Workspace](workspaceCacheSize, workspaceCacheTTL)}
+}
+
+// workspaceScope limits every query run on the returned handle, including
+// transactions started from it, to rows of one workspace
+func workspaceScope(db *gorm.DB, workspaceID uint) *gorm.DB {
+	return db.Where("workspace_id = ?", workspaceID).Session(&gorm.Session{})
+}
+
+// unscopedDB drops the workspace condition from db while keeping its connection
+// (and so its transaction), for checks that span every workspace such as
+// short code and username uniqueness
+func unscopedDB(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true})
+}
+
+// GetWorkspace returns a workspace, served from a short-lived cache
+func (s *WorkspaceService) GetWorkspace(id uint) (*models.Workspace, error) {
+	if workspace, ok := s.cache.Get(id); ok {
+		return &workspace, nil
+	}
+
+	var workspace models.Workspace
+	if err := s.db.First(&workspace, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWorkspaceNotFound
+		}
+		return nil, err
+	}
+	s.cache.Set(id, workspace)
+	return &workspace, nil
+}
+
+func (s *WorkspaceService) GetWorkspaces() ([]models.Workspace, error) {
+	var workspaces []models.Workspace
+	err := s.db.Order("id").Find(&workspaces).Error
+	return workspaces, err
+}
+
+// CreateWorkspace creates a workspace and its first owner in one transaction.
+// A default domain is added to the workspace's domains, to be verified by the owner.
+func (s *WorkspaceService) CreateWorkspace(req models.WorkspaceRequest) (*models.Workspace, *models.AdminUser, error) {
+	if !slugPattern.MatchString(req.Slug) {
+		return nil, nil, ErrInvalidSlug
+	}
+
+	workspace := models.Workspace{
+		Slug:          req.Slug,
+		Name:          req.Name,
+		DefaultDomain: normalizeDomain(req.DefaultDomain),
+		DailyQuota:    req.DailyQuota,
+		MonthlyQuota:  req.MonthlyQuota,
+		BrandName:     req.BrandName,
+		BrandLogoURL:  req.BrandLogoURL,
+		BrandColor:    req.BrandColor,
+	}
+
+	var owner *models.AdminUser
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&models.Workspace{}).Where("slug = ?", workspace.Slug).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return ErrSlugTaken
+		}
+		if err := tx.Create(&workspace).Error; err != nil {
+			return err
+		}
+
+		if workspace.DefaultDomain != "" {
+			if _, err := NewDomainService(tx, nil, "").ForWorkspace(workspace.ID).CreateDomain(workspace.DefaultDomain); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		owner, err = NewAdminUserService(tx, 0).ForWorkspace(workspace.ID).CreateUser(models.AdminUserRequest{
+			Username: req.OwnerUsername,
+			Password: req.OwnerPassword,
+			Role:     models.RoleOwner,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &workspace, owner, nil
+}
+
+// UpdateWorkspace changes a workspace's name, default domain, quotas or branding
+func (s *WorkspaceService) UpdateWorkspace(id uint, req models.WorkspaceUpdateRequest) (*models.Workspace, error) {
+	var workspace models.Workspace
+	if err := s.db.First(&workspace, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWorkspaceNotFound
+		}
+		return nil, err
+	}
+
+	var columns []string
+	if req.Name != nil {
+		workspace.Name = *req.Name
+		columns = append(columns, "name")
+	}
+	if req.DefaultDomain != nil {
+		workspace.DefaultDomain = normalizeDomain(*req.DefaultDomain)
+		if workspace.DefaultDomain != "" {
+			var domains int64
+			err := s.db.Model(&models.Domain{}).
+				Where("workspace_id = ? AND host = ?", id, workspace.DefaultDomain).
+				Count(&domains).Error
+			if err != nil {
+				return nil, err
+			}
+			if domains == 0 {
+				return nil, ErrUnknownDefaultDomain
+			}
+		}
+		columns = append(columns, "default_domain")
+	}
+	if req.DailyQuota != nil {
+		workspace.DailyQuota = *req.DailyQuota
+		columns = append(columns, "daily_quota")
+	}
+	if req.MonthlyQuota != nil {
+		workspace.MonthlyQuota = *req.MonthlyQuota
+		columns = append(columns, "monthly_quota")
+	}
+	if req.BrandName != nil {
+		workspace.BrandName = *req.BrandName
+		columns = append(columns, "brand_name")
+	}
+	if req.BrandLogoURL != nil {
+		workspace.BrandLogoURL = *req.BrandLogoURL
+		columns = append(columns, "brand_logo_url")
+	}
+	if req.BrandColor != nil {
+		workspace.BrandColor = *req.BrandColor
+		columns = append(columns, "brand_color")
+	}
+
+	if len(columns) > 0 {
+		if err := s.db.Model(&workspace).Select(columns).Updates(&workspace).Error; err != nil {
+			return nil, err
+		}
+		s.cache.Delete(id)
+	}
+	return &workspace, nil
+}
+
+// CreationQuota returns the most restrictive of the workspace's daily and
+// monthly quotas, or nil if it has neither. Links created anonymously and by
+// every API key of the workspace count towards it.
+func (s *WorkspaceService) CreationQuota(workspace *models.Workspace, now time.Time) (*QuotaStatus, error) {
+	scope := s.db.Unscoped().Model(&models.URL{}).Where("workspace_id = ?", workspace.ID)
+	return creationQuota(scope, workspace.DailyQuota, workspace.MonthlyQuota, now)
+}
@@ -0,0 +1,22 @@
+// Sends the session's CSRF token (from the csrf-token meta tag) with every
+// unsafe same-origin fetch, so admin pages don't have to add it themselves.
+(function () {
+  const meta = document.querySelector('meta[name="csrf-token"]');
+  const csrfToken = meta ? meta.content : "";
+  if (!csrfToken) {
+    return;
+  }
+
+  const originalFetch = window.fetch;
+  window.fetch = function (input, init = {}) {
+    const url = new URL(input instanceof Request ? input.url : input, location.href);
+    const method = (init.method || (input instanceof Request ? input.method : "GET")).toUpperCase();
+
+    if (url.origin === location.origin && !["GET", "HEAD", "OPTIONS"].includes(method)) {
+      const headers = new Headers(init.headers || (input instanceof Request ? input.headers : {}));
+      headers.set("X-CSRF-Token", csrfToken);
+      init = { ...init, headers };
+    }
+    return originalFetch.call(this, input, init);
+  };
+})();
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepThreshold is the number of tracked keys above which stale entries are pruned
+const sweepThreshold = 1024
+
+// AttemptLimiter throttles repeated failures per key (e.g. client IP) within a fixed window
+type AttemptLimiter struct {
+	mu          sync.Mutex
+	maxFailures int
+	window      time.Duration
+	attempts    map[string]*attemptRecord
+}
+
+type attemptRecord struct {
+	failures     int
+	firstFailure time.Time
+}
+
+func NewAttemptLimiter(maxFailures int, window time.Duration) *AttemptLimiter {
+	return &AttemptLimiter{
+		maxFailures: maxFailures,
+		window:      window,
+		attempts:    make(map[string]*attemptRecord),
+	}
+}
+
+// RetryAfter returns how long the key is blocked for, or 0 if it may try again
+func (l *AttemptLimiter) RetryAfter(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record, exists := l.attempts[key]
+	if !exists {
+		return 0
+	}
+
+	remaining := time.Until(record.firstFailure.Add(l.window))
+	if remaining <= 0 {
+		delete(l.attempts, key)
+		return 0
+	}
+	if record.failures < l.maxFailures {
+		return 0
+	}
+	return remaining
+}
+
+// Fail records a failed attempt for the key
+func (l *AttemptLimiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	record, exists := l.attempts[key]
+	if !exists || now.Sub(record.firstFailure) >= l.window {
+		if len(l.attempts) >= sweepThreshold {
+			l.sweep(now)
+		}
+		l.attempts[key] = &attemptRecord{failures: 1, firstFailure: now}
+		return
+	}
+	record.failures++
+}
+
+// Reset clears the failures recorded for the key
+func (l *AttemptLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, key)
+}
+
+// sweep drops windows that have already elapsed so the map doesn't grow unbounded
+func (l *AttemptLimiter) sweep(now time.Time) {
+	for key, record := range l.attempts {
+		if now.Sub(record.firstFailure) >= l.window {
+			delete(l.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	l := NewAttemptLimiter(3, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		l.Fail("192.0.2.1")
+	}
+	if d := l.RetryAfter("192.0.2.1"); d != 0 {
+		t.Errorf("RetryAfter() below the limit = %v, want 0", d)
+	}
+
+	l.Fail("192.0.2.1")
+	if d := l.RetryAfter("192.0.2.1"); d <= 0 || d > time.Minute {
+		t.Errorf("RetryAfter() at the limit = %v, want up to 1m", d)
+	}
+	if d := l.RetryAfter("192.0.2.2"); d != 0 {
+		t.Errorf("RetryAfter() of another key = %v, want 0", d)
+	}
+
+	l.Reset("192.0.2.1")
+	if d := l.RetryAfter("192.0.2.1"); d != 0 {
+		t.Errorf("RetryAfter() after Reset = %v, want 0", d)
+	}
+}
+
+func TestAttemptLimiterWindow(t *testing.T) {
+	l := NewAttemptLimiter(2, 50*time.Millisecond)
+	l.Fail("key")
+	l.Fail("key")
+	if l.RetryAfter("key") <= 0 {
+		t.Fatal("key isn't blocked at the limit")
+	}
+
+	// The block ends with the window, and failures after it start a new one
+	time.Sleep(60 * time.Millisecond)
+	if d := l.RetryAfter("key"); d != 0 {
+		t.Errorf("RetryAfter() after the window = %v, want 0", d)
+	}
+	l.Fail("key")
+	if d := l.RetryAfter("key"); d != 0 {
+		t.Errorf("RetryAfter() after one failure in a new window = %v, want 0", d)
+	}
+}
+
+func TestAttemptLimiterSweep(t *testing.T) {
+	l := NewAttemptLimiter(1, time.Millisecond)
+	for i := 0; i < sweepThreshold; i++ {
+		l.Fail(strconv.Itoa(i))
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// Tracking a new key past the threshold drops the elapsed windows
+	l.Fail("new")
+	if n := len(l.attempts); n != 1 {
+		t.Errorf("%d keys tracked after sweep, want 1", n)
+	}
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Names of bots recognised by heuristics rather than by name
+const (
+	BotUnnamed     = "Other"         // Calls itself a bot, crawler or spider
+	BotNoUserAgent = "No user agent" // Sends no User-Agent header
+	BotNoAccept    = "No Accept"     // Browsers send an Accept header on every navigation
+)
+
+// uaBot recognises a bot by any of its tokens, which are lower case. Preview
+// bots fetch links to show a card of the page when they are shared.
+type uaBot struct {
+	name    string
+	tokens  []string
+	preview bool
+}
+
+// uaBots is checked in order. Link-preview fetchers come first, as some send
+// several of their tokens at once: iMessage claims to be both Facebook's and
+// Twitter's, and Telegram's says it is like Twitter's.
+var uaBots = []uaBot{
+	// Link previews of chat apps and social networks
+	{name: "Slackbot", tokens: []string{"slackbot", "slack-imgproxy"}, preview: true},
+	{name: "TelegramBot", tokens: []string{"telegrambot"}, preview: true},
+	{name: "Twitterbot", tokens: []string{"twitterbot"}, preview: true},
+	{name: "facebookexternalhit", tokens: []string{"facebookexternalhit", "facebookcatalog", "meta-externalagent"}, preview: true},
+	{name: "WhatsApp", tokens: []string{"whatsapp/"}, preview: true},
+	{name: "Discordbot", tokens: []string{"discordbot"}, preview: true},
+	{name: "LinkedInBot", tokens: []string{"linkedinbot"}, preview: true},
+	{name: "Pinterestbot", tokens: []string{"pinterestbot", "pinterest/0."}, preview: true},
+	{name: "SkypeUriPreview", tokens: []string{"skypeuripreview"}, preview: true},
+	{name: "redditbot", tokens: []string{"redditbot"}, preview: true},
+	{name: "Embedly", tokens: []string{"embedly"}, preview: true},
+	// Search engines and SEO crawlers
+	{name: "Googlebot", tokens: []string{"googlebot", "adsbot-google", "mediapartners-google", "googleother", "google-inspectiontool"}},
+	{name: "Bingbot", tokens: []string{"bingbot", "bingpreview", "adidxbot"}},
+	{name: "Applebot", tokens: []string{"applebot"}},
+	{name: "DuckDuckBot", tokens: []string{"duckduckbot"}},
+	{name: "YandexBot", tokens: []string{"yandexbot", "yandex.com/bots"}},
+	{name: "Baiduspider", tokens: []string{"baiduspider"}},
+	{name: "AhrefsBot", tokens: []string{"ahrefsbot"}},
+	{name: "SemrushBot", tokens: []string{"semrushbot"}},
+	// Uptime monitors
+	{name: "UptimeRobot", tokens: []string{"uptimerobot"}},
+	{name: "Pingdom", tokens: []string{"pingdom"}},
+	{name: "StatusCake", tokens: []string{"statuscake"}},
+	{name: "Better Stack", tokens: []string{"better uptime", "betterstack"}},
+	{name: "Site24x7", tokens: []string{"site24x7"}},
+	{name: "Datadog Synthetics", tokens: []string{"datadogsynthetics"}},
+	{name: "New Relic", tokens: []string{"newrelicpinger", "newrelicsynthetics"}},
+	{name: "Checkly", tokens: []string{"checkly"}},
+	// HTTP libraries and automated browsers
+	{name: "curl", tokens: []string{"curl/"}},
+	{name: "Wget", tokens: []string{"wget/"}},
+	{name: "python-requests", tokens: []string{"python-requests/"}},
+	{name: "Python", tokens: []string{"python-urllib", "python-httpx", "aiohttp/"}},
+	{name: "Go-http-client", tokens: []string{"go-http-client/"}},
+	{name: "Java", tokens: []string{"java/", "apache-httpclient", "okhttp/"}},
+	{name: "Node.js", tokens: []string{"node-fetch", "axios/", "undici"}},
+	{name: "PowerShell", tokens: []string{"windowspowershell"}},
+	{name: "Postman", tokens: []string{"postmanruntime"}},
+	{name: "HeadlessChrome", tokens: []string{"headlesschrome"}},
+}
+
+// uaBotTokens are the generic signs of bots not in uaBots. Tokens end with a
+// separator so phone models like "CUBOT" don't match.
+var uaBotTokens = []string{"bot/", "bot-", "bot;", "bot)", "crawler", "spider", "slurp"}
+
+// BotName returns the name of the bot sending a User-Agent header, or "" when
+// it looks like a person's browser
+func BotName(userAgent string) string {
+	ua := strings.ToLower(strings.TrimSpace(userAgent))
+	if ua == "" {
+		return BotNoUserAgent
+	}
+	for _, bot := range uaBots {
+		if containsAny(ua, bot.tokens) {
+			return bot.name
+		}
+	}
+	if containsAny(ua, uaBotTokens) {
+		return BotUnnamed
+	}
+	return ""
+}
+
+// IsPreviewBot reports whether the bot named by BotName fetches links to show
+// previews of them in chats and social feeds
+func IsPreviewBot(name string) bool {
+	for _, bot := range uaBots {
+		if bot.name == name {
+			return bot.preview
+		}
+	}
+	return false
+}
+
+// DetectBot is BotName with heuristics on the rest of the request, for bots
+// that pass themselves off as browsers. It only tags clicks for analytics:
+// anyone can leave out a header, so bots mustn't be let off anything people
+// aren't.
+func DetectBot(r *http.Request) string {
+	if name := BotName(r.Header.Get("User-Agent")); name != "" {
+		return name
+	}
+	if r.Header.Get("Accept") == "" {
+		return BotNoAccept
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBotName(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", "Slackbot"},
+		{"Twitterbot/1.0", "Twitterbot"},
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", "facebookexternalhit"},
+		{"WhatsApp/2.23.20.0 A", "WhatsApp"},
+		{"TelegramBot (like TwitterBot)", "TelegramBot"},
+		{"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", "Discordbot"},
+		{"LinkedInBot/1.0 (compatible; Mozilla/5.0; Apache-HttpClient +http://www.linkedin.com)", "LinkedInBot"},
+		// iMessage claims to be both Facebook's and Twitter's crawler
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_11_1) AppleWebKit/601.2.4 (KHTML, like Gecko) Version/9.0.1 Safari/601.2.4 facebookexternalhit/1.1 Facebot Twitterbot/1.0", "Twitterbot"},
+		{"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)", "Bingbot"},
+		{"Mozilla/5.0 (compatible; AhrefsBot/7.0; +http://ahrefs.com/robot/)", "AhrefsBot"},
+		{"Mozilla/5.0+(compatible; UptimeRobot/2.0; http://www.uptimerobot.com/)", "UptimeRobot"},
+		{"python-requests/2.31.0", "python-requests"},
+		{"Go-http-client/1.1", "Go-http-client"},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36", "HeadlessChrome"},
+		{"Mozilla/5.0 (compatible; MyCustomCrawler/1.0)", BotUnnamed},
+		{"Mozilla/5.0 (compatible; SomeBot/3.1; +https://example.com/bot)", BotUnnamed},
+		{"   ", BotNoUserAgent},
+		// Phone models ending in "bot" are people
+		{"Mozilla/5.0 (Linux; Android 10; CUBOT X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", ""},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", ""},
+	}
+
+	for _, tt := range tests {
+		if got := BotName(tt.userAgent); got != tt.want {
+			t.Errorf("BotName(%q) = %q, want %q", tt.userAgent, got, tt.want)
+		}
+	}
+}
+
+func TestIsPreviewBot(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"Slackbot", true},
+		{"Twitterbot", true},
+		{"facebookexternalhit", true},
+		{"WhatsApp", true},
+		{"Discordbot", true},
+		{"Googlebot", false},
+		{"curl", false},
+		{BotUnnamed, false},
+		{BotNoAccept, false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsPreviewBot(tt.name); got != tt.want {
+			t.Errorf("IsPreviewBot(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetectBot(t *testing.T) {
+	const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	tests := []struct {
+		name      string
+		userAgent string
+		accept    string
+		want      string
+	}{
+		{"browser", chrome, "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", ""},
+		{"browser UA without Accept", chrome, "", BotNoAccept},
+		{"browser UA with any Accept", chrome, "*/*", ""},
+		{"named bot without Accept", "Twitterbot/1.0", "", "Twitterbot"},
+		{"named bot with Accept", "curl/8.4.0", "*/*", "curl"},
+		{"no user agent", "", "*/*", BotNoUserAgent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/abc123", nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			if got := DetectBot(r); got != tt.want {
+				t.Errorf("DetectBot() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
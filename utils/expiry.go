@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"time"
+
+	"url-shortener/models"
+)
+
+// ClickBudgetUsedUp is the expired page's reason for links out of max_clicks
+const ClickBudgetUsedUp = "This link has reached its maximum number of visits."
+
+// CheckExpiry reports whether a URL has expired by date or click budget,
+// along with a human readable reason for the expired page.
+func CheckExpiry(url *models.URL, now time.Time) (bool, string) {
+	if url.ExpiresAt != nil && !now.Before(*url.ExpiresAt) {
+		return true, "This link was only valid until " + url.ExpiresAt.UTC().Format("January 2, 2006 15:04 MST") + "."
+	}
+
+	if url.MaxClicks > 0 && url.ClickCount >= url.MaxClicks {
+		return true, ClickBudgetUsedUp
+	}
+
+	return false, ""
+}
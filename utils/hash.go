@@ -6,23 +6,25 @@ import (
 	"strings"
 )
 
-// GenerateURLHash creates a unique hash for URL combination
-func GenerateURLHash(originalURL, iosURL, androidURL, desktopURL, macURL string) string {
+// GenerateURLHash creates a unique hash for URL combination.
+// Non-empty qualifiers (such as a custom alias) are appended so that
+// hashes of plain URL combinations stay unchanged.
+func GenerateURLHash(originalURL, iosURL, androidURL, desktopURL, macURL string, qualifiers ...string) string {
 	// Combine all URLs to create a unique identifier
-	combined := strings.Join([]string{
+	parts := []string{
 		originalURL,
 		iosURL,
 		androidURL,
 		desktopURL,
 		macURL,
-	}, "|")
+	}
+	for _, q := range qualifiers {
+		if q != "" {
+			parts = append(parts, q)
+		}
+	}
+	combined := strings.Join(parts, "|")
 
 	hash := sha256.Sum256([]byte(combined))
 	return fmt.Sprintf("%x", hash)
 }
-
-// HashAPIKey hashes the API key secret
-func HashAPIKey(secret string) string {
-	hash := sha256.Sum256([]byte(secret))
-	return fmt.Sprintf("%x", hash)
-}
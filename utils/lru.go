@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is a thread-safe, fixed-capacity least-recently-used cache.
+// Entries optionally expire after a TTL; a zero TTL keeps them until evicted.
+type LRU[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[K]*list.Element
+	order    *list.List
+}
+
+type lruEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+func NewLRU[K comparable, V any](capacity int, ttl time.Duration) *LRU[K, V] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LRU[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[K]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the cached value and whether it was present and not expired
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	elem, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	entry := elem.Value.(*lruEntry[K, V])
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		c.removeElement(elem)
+		return zero, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// Set stores a value using the cache's default TTL
+func (c *LRU[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL stores a value with a specific TTL, evicting the oldest entry when full
+func (c *LRU[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*lruEntry[K, V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value, expiresAt: expiresAt})
+	if c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Delete removes a key from the cache
+func (c *LRU[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// Len returns the number of cached entries, including expired ones not yet evicted
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRU[K, V]) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*lruEntry[K, V]).key)
+}
@@ -2,77 +2,39 @@ package utils
 
 import (
 	"net/http"
-	"strings"
 
 	"url-shortener/models"
 )
 
+// PlatformInfo describes the client of a request, see ParseUserAgent
 type PlatformInfo struct {
-	Platform string
-	OS       string
-	Browser  string
+	Platform       string // ios, android, mac or desktop, as used by platform-specific redirects
+	OS             string
+	OSVersion      string // Major and minor, e.g. "17.1"
+	Browser        string // In-app browsers are named after their app, e.g. "Instagram"
+	BrowserVersion string // Major only
+	Device         string // phone, tablet, desktop, tv or bot
+	Bot            string // Name of the crawler, monitor or script, "" for people
 }
 
+// DetectPlatform parses the request's User-Agent and also checks the request
+// itself for signs of a bot, see DetectBot
 func DetectPlatform(r *http.Request) PlatformInfo {
-	userAgent := strings.ToLower(r.Header.Get("User-Agent"))
-
-	info := PlatformInfo{}
-
-	// Detect Platform
-	if strings.Contains(userAgent, "iphone") || strings.Contains(userAgent, "ipad") {
-		info.Platform = "ios"
-		info.OS = "iOS"
-	} else if strings.Contains(userAgent, "android") {
-		info.Platform = "android"
-		info.OS = "Android"
-	} else if strings.Contains(userAgent, "macintosh") || strings.Contains(userAgent, "mac os") {
-		info.Platform = "mac"
-		info.OS = "macOS"
-	} else if strings.Contains(userAgent, "windows") {
-		info.Platform = "desktop"
-		info.OS = "Windows"
-	} else if strings.Contains(userAgent, "linux") {
-		info.Platform = "desktop"
-		info.OS = "Linux"
-	} else {
-		info.Platform = "desktop"
-		info.OS = "Unknown"
-	}
-
-	// Detect Browser
-	if strings.Contains(userAgent, "chrome") {
-		info.Browser = "Chrome"
-	} else if strings.Contains(userAgent, "firefox") {
-		info.Browser = "Firefox"
-	} else if strings.Contains(userAgent, "safari") && !strings.Contains(userAgent, "chrome") {
-		info.Browser = "Safari"
-	} else if strings.Contains(userAgent, "edge") {
-		info.Browser = "Edge"
-	} else {
-		info.Browser = "Unknown"
+	info := ParseUserAgent(r.Header.Get("User-Agent"))
+	if info.Bot = DetectBot(r); info.Bot != "" {
+		info.Device = DeviceBot
 	}
-
 	return info
 }
 
-func GetRedirectURL(url *models.URL, platform string) string {
-	switch platform {
-	case "ios":
-		if url.IOSRedirectURL != "" {
-			return url.IOSRedirectURL
-		}
-	case "android":
-		if url.AndroidRedirectURL != "" {
-			return url.AndroidRedirectURL
-		}
-	case "mac":
-		if url.MacRedirectURL != "" {
-			return url.MacRedirectURL
-		}
-	case "desktop":
-		if url.DesktopRedirectURL != "" {
-			return url.DesktopRedirectURL
+// GetRedirectURL returns the URL of the first of the link's rules, including
+// those of its platform-specific URLs, that the visitor matches and true, or
+// the original URL and false when none does
+func GetRedirectURL(link *models.URL, visitor Visitor) (string, bool) {
+	for _, rule := range RedirectRules(link) {
+		if MatchRule(&rule, visitor) {
+			return rule.URL, true
 		}
 	}
-	return url.OriginalURL
+	return link.OriginalURL, false
 }
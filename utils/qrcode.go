@@ -0,0 +1,298 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+	"strings"
+
+	_ "image/gif"  // register decoders for uploaded logos
+	_ "image/jpeg" // register decoders for uploaded logos
+
+	"github.com/skip2/go-qrcode"
+)
+
+// QR rendering bounds
+const (
+	MinQRSize        = 64
+	MaxQRSize        = 2048
+	DefaultQRSize    = 256
+	MaxQuietZone     = 16
+	DefaultQuietZone = 4
+)
+
+// Logo bounds in pixels. Larger logos are rejected before they are decoded;
+// uploads are scaled down to fit MaxStoredLogoSize, which is more than the
+// largest logo drawn (MaxQRSize * logoScale).
+const (
+	MaxLogoDimension  = 2048
+	MaxStoredLogoSize = 512
+)
+
+// logoScale is the fraction of the QR width covered by a centre logo.
+// Kept small enough for level H error correction to recover the hidden modules.
+const logoScale = 0.22
+
+var ErrInvalidQROption = errors.New("invalid QR option")
+
+// QROptions controls how a QR code is rendered
+type QROptions struct {
+	Size       int                  // Output width/height in pixels
+	Level      qrcode.RecoveryLevel // Error correction level
+	Foreground color.NRGBA          // Module colour
+	Background color.NRGBA          // Background and quiet zone colour
+	QuietZone  int                  // Border width in modules
+	Logo       image.Image          // Optional centre logo
+	LogoBytes  []byte               // Original logo file, embedded as-is in SVG output
+	LogoType   string               // MIME type of LogoBytes
+}
+
+// DefaultQROptions returns black-on-white, medium error correction options
+func DefaultQROptions() QROptions {
+	return QROptions{
+		Size:       DefaultQRSize,
+		Level:      qrcode.Medium,
+		Foreground: color.NRGBA{0, 0, 0, 255},
+		Background: color.NRGBA{255, 255, 255, 255},
+		QuietZone:  DefaultQuietZone,
+	}
+}
+
+// ParseQRLevel maps L/M/Q/H to a recovery level
+func ParseQRLevel(level string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToUpper(level) {
+	case "L":
+		return qrcode.Low, nil
+	case "M", "":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	}
+	return 0, fmt.Errorf("%w: level must be one of L, M, Q, H", ErrInvalidQROption)
+}
+
+// ParseHexColor parses RGB, RRGGBB or RRGGBBAA, with or without a leading '#'
+func ParseHexColor(value string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("%w: invalid colour %q", ErrInvalidQROption, value)
+	}
+
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("%w: invalid colour %q", ErrInvalidQROption, value)
+	}
+
+	return color.NRGBA{R: uint8(n >> 24), G: uint8(n >> 16), B: uint8(n >> 8), A: uint8(n)}, nil
+}
+
+// DecodeLogo decodes an uploaded PNG, JPEG or GIF logo. Its header is read
+// first, so oversized images are rejected without allocating their pixels.
+func DecodeLogo(data []byte) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width < 1 || config.Height < 1 {
+		return nil, "", fmt.Errorf("%w: logo must be a PNG, JPEG or GIF image", ErrInvalidQROption)
+	}
+	if config.Width > MaxLogoDimension || config.Height > MaxLogoDimension {
+		return nil, "", fmt.Errorf("%w: logo must be at most %dx%d pixels", ErrInvalidQROption, MaxLogoDimension, MaxLogoDimension)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: logo must be a PNG, JPEG or GIF image", ErrInvalidQROption)
+	}
+	return img, "image/" + format, nil
+}
+
+// PrepareLogo decodes an uploaded logo and returns the file to store: the
+// upload itself, or a PNG of it scaled down to fit MaxStoredLogoSize
+func PrepareLogo(data []byte) ([]byte, string, error) {
+	img, contentType, err := DecodeLogo(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() <= MaxStoredLogoSize && bounds.Dy() <= MaxStoredLogoSize {
+		return data, contentType, nil
+	}
+
+	w, h := fitLogo(bounds, MaxStoredLogoSize)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleImage(img, max(w, 1), max(h, 1))); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// qrMatrix encodes the content and returns the module grid including the quiet zone
+func qrMatrix(content string, opts QROptions) ([][]bool, error) {
+	level := opts.Level
+	if opts.Logo != nil {
+		// The logo hides modules, so always use the highest error correction
+		level = qrcode.Highest
+	}
+
+	qr, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	qr.DisableBorder = true
+	modules := qr.Bitmap()
+
+	size := len(modules) + 2*opts.QuietZone
+	matrix := make([][]bool, size)
+	for y := range matrix {
+		matrix[y] = make([]bool, size)
+	}
+	for y, row := range modules {
+		copy(matrix[y+opts.QuietZone][opts.QuietZone:], row)
+	}
+	return matrix, nil
+}
+
+// RenderQRPNG renders content as a PNG QR code
+func RenderQRPNG(content string, opts QROptions) ([]byte, error) {
+	matrix, err := qrMatrix(content, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := len(matrix)
+	img := image.NewNRGBA(image.Rect(0, 0, opts.Size, opts.Size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{opts.Background}, image.Point{}, draw.Src)
+
+	// Map every pixel back to its module so any size renders without gaps
+	for py := 0; py < opts.Size; py++ {
+		my := py * modules / opts.Size
+		for px := 0; px < opts.Size; px++ {
+			if matrix[my][px*modules/opts.Size] {
+				img.SetNRGBA(px, py, opts.Foreground)
+			}
+		}
+	}
+
+	if opts.Logo != nil {
+		drawLogo(img, opts)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderQRSVG renders content as an SVG QR code
+func RenderQRSVG(content string, opts QROptions) ([]byte, error) {
+	matrix, err := qrMatrix(content, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := len(matrix)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		opts.Size, opts.Size, modules, modules)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" %s/>`, modules, modules, svgFill(opts.Background))
+
+	// One path for all dark modules, merging horizontal runs
+	buf.WriteString(`<path `)
+	buf.WriteString(svgFill(opts.Foreground))
+	buf.WriteString(` d="`)
+	for y, row := range matrix {
+		for x := 0; x < modules; x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < modules && row[x] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	buf.WriteString(`"/>`)
+
+	if opts.Logo != nil && len(opts.LogoBytes) > 0 {
+		box := float64(modules) * logoScale
+		offset := (float64(modules) - box) / 2
+		pad := box * 0.1
+		fmt.Fprintf(&buf, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" %s/>`,
+			offset-pad, offset-pad, box+2*pad, box+2*pad, svgFill(opts.Background))
+		fmt.Fprintf(&buf, `<image x="%.2f" y="%.2f" width="%.2f" height="%.2f" preserveAspectRatio="xMidYMid meet" href="data:%s;base64,%s"/>`,
+			offset, offset, box, box, opts.LogoType, base64.StdEncoding.EncodeToString(opts.LogoBytes))
+	}
+
+	buf.WriteString(`</svg>`)
+	return buf.Bytes(), nil
+}
+
+func svgFill(c color.NRGBA) string {
+	fill := fmt.Sprintf(`fill="#%02x%02x%02x"`, c.R, c.G, c.B)
+	if c.A != 255 {
+		fill += fmt.Sprintf(` fill-opacity="%.3f"`, float64(c.A)/255)
+	}
+	return fill
+}
+
+// drawLogo scales the logo into the centre of the image on a background-coloured pad
+func drawLogo(img *image.NRGBA, opts QROptions) {
+	box := int(float64(opts.Size) * logoScale)
+	if box < 1 {
+		return
+	}
+
+	w, h := fitLogo(opts.Logo.Bounds(), box)
+	if w < 1 || h < 1 {
+		return
+	}
+
+	pad := box / 10
+	center := opts.Size / 2
+	padRect := image.Rect(center-w/2-pad, center-h/2-pad, center+w/2+pad, center+h/2+pad)
+	draw.Draw(img, padRect, &image.Uniform{opts.Background}, image.Point{}, draw.Src)
+
+	dst := image.Rect(center-w/2, center-h/2, center-w/2+w, center-h/2+h)
+	draw.Draw(img, dst, scaleImage(opts.Logo, w, h), image.Point{}, draw.Over)
+}
+
+// fitLogo returns the size of a logo fitted inside a box, keeping its aspect ratio
+func fitLogo(src image.Rectangle, box int) (int, int) {
+	w, h := box, box
+	if src.Dx() > src.Dy() {
+		h = box * src.Dy() / src.Dx()
+	} else if src.Dy() > src.Dx() {
+		w = box * src.Dx() / src.Dy()
+	}
+	return w, h
+}
+
+// scaleImage resizes src to w x h. Nearest-neighbour scaling keeps this
+// dependency free; logos are small.
+func scaleImage(src image.Image, w, h int) *image.NRGBA {
+	bounds := src.Bounds()
+	scaled := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := bounds.Min.Y + y*bounds.Dy()/h
+		for x := 0; x < w; x++ {
+			sx := bounds.Min.X + x*bounds.Dx()/w
+			scaled.Set(x, y, src.At(sx, sy))
+		}
+	}
+	return scaled
+}
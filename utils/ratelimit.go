@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket per key. Each bucket holds up to limit tokens
+// and refills at limit tokens per window, so short bursts are allowed while the
+// long-run rate stays at limit per window.
+type RateLimiter struct {
+	mu      sync.Mutex
+	window  time.Duration
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimitResult describes the state of a bucket after a request
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // Until the bucket is full again
+	RetryAfter time.Duration // Until the next request is allowed, 0 if Allowed
+}
+
+func NewRateLimiter(window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		window:  window,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Take spends one token from the key's bucket, if one is available.
+// The limit is passed per call so it can differ between keys and change over time.
+func (l *RateLimiter) Take(key string, limit int) RateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(limit)
+	perToken := l.window / time.Duration(limit)
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		if len(l.buckets) >= sweepThreshold {
+			l.sweep(now)
+		}
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		l.buckets[key] = bucket
+	} else {
+		refill := float64(now.Sub(bucket.updated)) / float64(perToken)
+		bucket.tokens = math.Min(capacity, bucket.tokens+refill)
+		bucket.updated = now
+	}
+
+	result := RateLimitResult{Limit: limit}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - bucket.tokens) * float64(perToken))
+	}
+
+	result.Remaining = int(bucket.tokens)
+	result.Reset = time.Duration((capacity - bucket.tokens) * float64(perToken))
+	return result
+}
+
+// sweep drops buckets idle for a full window; they would be full again anyway
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= l.window {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(time.Minute)
+
+	for i := 3; i > 0; i-- {
+		res := l.Take("key", 3)
+		if !res.Allowed || res.Remaining != i-1 || res.Limit != 3 {
+			t.Fatalf("Take() = %+v, want allowed with %d remaining", res, i-1)
+		}
+		if res.RetryAfter != 0 {
+			t.Errorf("Take() RetryAfter = %v while allowed", res.RetryAfter)
+		}
+	}
+
+	res := l.Take("key", 3)
+	if res.Allowed || res.Remaining != 0 {
+		t.Errorf("Take() over the limit = %+v, want refused", res)
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > time.Minute {
+		t.Errorf("Take() RetryAfter = %v, want within the window", res.RetryAfter)
+	}
+	if res.Reset <= res.RetryAfter || res.Reset > time.Minute {
+		t.Errorf("Take() Reset = %v, want up to a window", res.Reset)
+	}
+
+	if res := l.Take("other", 3); !res.Allowed {
+		t.Error("Take() limited an unrelated key")
+	}
+}
+
+func TestRateLimiterWindow(t *testing.T) {
+	l := NewRateLimiter(20 * time.Millisecond)
+	l.Take("key", 1)
+	if l.Take("key", 1).Allowed {
+		t.Fatal("Take() allowed a second request in the window")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !l.Take("key", 1).Allowed {
+		t.Error("Take() still refused after the window ended")
+	}
+}
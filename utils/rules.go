@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // Schedules name IANA zones, which slim images may not ship
+
+	"url-shortener/models"
+)
+
+var ErrInvalidRules = errors.New("invalid rules")
+
+// Visitor is what a link's redirect rules are matched against
+type Visitor struct {
+	Platform string // ios, android, mac or desktop
+	Browser  string
+	Country  string // ISO 3166-1 alpha-2 code, empty when unknown or not looked up
+	Language string // Preferred Accept-Language tag, see PreferredLanguage
+	Referrer string
+	Query    url.Values // Query parameters of the short URL
+	Time     time.Time
+}
+
+// ValidateRules checks what the request binding can't: that every rule has a
+// condition and that schedules name real time zones
+func ValidateRules(rules []models.RedirectRule) error {
+	for i, rule := range rules {
+		if len(rule.Countries) == 0 && len(rule.Languages) == 0 && len(rule.Devices) == 0 &&
+			len(rule.Browsers) == 0 && len(rule.Referrers) == 0 && len(rule.Query) == 0 && rule.Schedule == nil {
+			return fmt.Errorf("%w: rule %d has no conditions", ErrInvalidRules, i)
+		}
+		if rule.Schedule != nil {
+			if _, err := loadLocation(rule.Schedule.Timezone); err != nil {
+				return fmt.Errorf("%w: rule %d has an unknown timezone %q", ErrInvalidRules, i, rule.Schedule.Timezone)
+			}
+		}
+	}
+	return nil
+}
+
+// RedirectRules returns a link's rules followed by a device rule for each of
+// its platform-specific URLs, which predate rules
+func RedirectRules(link *models.URL) []models.RedirectRule {
+	rules := link.Rules
+	for _, legacy := range []struct{ device, url string }{
+		{"ios", link.IOSRedirectURL},
+		{"android", link.AndroidRedirectURL},
+		{"mac", link.MacRedirectURL},
+		{"desktop", link.DesktopRedirectURL},
+	} {
+		if legacy.url != "" {
+			rules = append(rules[:len(rules):len(rules)], models.RedirectRule{URL: legacy.url, Devices: []string{legacy.device}})
+		}
+	}
+	return rules
+}
+
+// RulesUseCountry reports whether any of the rules matches on country, so the
+// visitor's location has to be looked up before redirecting
+func RulesUseCountry(rules []models.RedirectRule) bool {
+	for _, rule := range rules {
+		if len(rule.Countries) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchRule reports whether the visitor meets every condition of the rule
+func MatchRule(rule *models.RedirectRule, visitor Visitor) bool {
+	if len(rule.Countries) > 0 && !containsFold(rule.Countries, visitor.Country) {
+		return false
+	}
+	if len(rule.Languages) > 0 && !matchLanguage(rule.Languages, visitor.Language) {
+		return false
+	}
+	if len(rule.Devices) > 0 && !containsFold(rule.Devices, visitor.Platform) {
+		return false
+	}
+	if len(rule.Browsers) > 0 && !containsFold(rule.Browsers, visitor.Browser) {
+		return false
+	}
+	if len(rule.Referrers) > 0 && !matchReferrer(rule.Referrers, visitor.Referrer) {
+		return false
+	}
+	for name, value := range rule.Query {
+		values, ok := visitor.Query[name]
+		if !ok || (value != "" && !containsFold(values, value)) {
+			return false
+		}
+	}
+	if rule.Schedule != nil && !matchSchedule(rule.Schedule, visitor.Time) {
+		return false
+	}
+	return true
+}
+
+func containsFold(list []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLanguage matches a tag and its subtags, so "pt" matches "pt-BR" but
+// "pt-BR" doesn't match "pt-PT"
+func matchLanguage(languages []string, language string) bool {
+	if language == "" {
+		return false
+	}
+	language = strings.ToLower(language)
+	for _, tag := range languages {
+		tag = strings.ToLower(tag)
+		if language == tag || strings.HasPrefix(language, tag+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchReferrer matches the referrer's host against hosts and their subdomains
+func matchReferrer(hosts []string, referrer string) bool {
+	parsed, err := url.Parse(referrer)
+	if err != nil || parsed.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, domain := range hosts {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func matchSchedule(schedule *models.RuleSchedule, now time.Time) bool {
+	location, err := loadLocation(schedule.Timezone)
+	if err != nil {
+		return false
+	}
+	now = now.In(location)
+
+	start, end := clockMinutes(schedule.Start, 0), clockMinutes(schedule.End, 24*60)
+	minute := now.Hour()*60 + now.Minute()
+	onDay := func(day time.Weekday) bool {
+		if len(schedule.Days) == 0 {
+			return true
+		}
+		for _, name := range schedule.Days {
+			if weekdays[name] == day {
+				return true
+			}
+		}
+		return false
+	}
+
+	if start < end {
+		return minute >= start && minute < end && onDay(now.Weekday())
+	}
+	// The window runs past midnight: its first part belongs to today, the
+	// part after midnight to yesterday
+	return (minute >= start && onDay(now.Weekday())) ||
+		(minute < end && onDay((now.Weekday()+6)%7))
+}
+
+// clockMinutes converts "15:04" to minutes after midnight, or returns def if empty
+func clockMinutes(clock string, def int) int {
+	hours, minutes, ok := strings.Cut(clock, ":")
+	if !ok {
+		return def
+	}
+	h, err1 := strconv.Atoi(hours)
+	m, err2 := strconv.Atoi(minutes)
+	if err1 != nil || err2 != nil {
+		return def
+	}
+	return h*60 + m
+}
+
+var locations sync.Map // Time zone name to *time.Location
+
+// loadLocation is time.LoadLocation with the result cached, as it reads the zone database each call
+func loadLocation(name string) (*time.Location, error) {
+	if cached, ok := locations.Load(name); ok {
+		return cached.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, location)
+	return location, nil
+}
+
+// PreferredLanguage returns the tag of an Accept-Language header with the
+// highest quality, the first one on ties, or "" when there is none
+func PreferredLanguage(header string) string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > 0 {
+			tags = append(tags, weighted{tag, quality})
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+	return tags[0].tag
+}
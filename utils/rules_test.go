@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"url-shortener/models"
+)
+
+func TestMatchRule(t *testing.T) {
+	visitor := Visitor{
+		Platform: "ios",
+		Browser:  "Safari",
+		Country:  "DE",
+		Language: "pt-BR",
+		Referrer: "https://m.news.example.com/story",
+		Query:    url.Values{"ref": {"qr"}, "debug": {""}},
+	}
+	tests := []struct {
+		name string
+		rule models.RedirectRule
+		want bool
+	}{
+		{"country", models.RedirectRule{Countries: []string{"at", "de"}}, true},
+		{"other country", models.RedirectRule{Countries: []string{"FR"}}, false},
+		{"language", models.RedirectRule{Languages: []string{"pt"}}, true},
+		{"language and region", models.RedirectRule{Languages: []string{"pt-br"}}, true},
+		{"other region", models.RedirectRule{Languages: []string{"pt-PT"}}, false},
+		{"language prefix only", models.RedirectRule{Languages: []string{"p"}}, false},
+		{"device", models.RedirectRule{Devices: []string{"android", "ios"}}, true},
+		{"other device", models.RedirectRule{Devices: []string{"desktop"}}, false},
+		{"browser", models.RedirectRule{Browsers: []string{"safari"}}, true},
+		{"referrer subdomain", models.RedirectRule{Referrers: []string{"example.com"}}, true},
+		{"referrer suffix only", models.RedirectRule{Referrers: []string{"ample.com"}}, false},
+		{"query value", models.RedirectRule{Query: map[string]string{"ref": "QR"}}, true},
+		{"any query value", models.RedirectRule{Query: map[string]string{"debug": ""}}, true},
+		{"other query value", models.RedirectRule{Query: map[string]string{"ref": "email"}}, false},
+		{"missing query parameter", models.RedirectRule{Query: map[string]string{"utm": ""}}, false},
+		{"all conditions", models.RedirectRule{Countries: []string{"DE"}, Devices: []string{"ios"}, Browsers: []string{"Safari"}}, true},
+		{"one condition fails", models.RedirectRule{Countries: []string{"DE"}, Devices: []string{"android"}}, false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchRule(&tt.rule, visitor); got != tt.want {
+			t.Errorf("%s: MatchRule() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Unknown countries and languages match no list
+	if MatchRule(&models.RedirectRule{Countries: []string{"DE"}}, Visitor{}) {
+		t.Error("rule matched a visitor of unknown country")
+	}
+	if MatchRule(&models.RedirectRule{Referrers: []string{"example.com"}}, Visitor{Referrer: "not a url"}) {
+		t.Error("rule matched an unparseable referrer")
+	}
+}
+
+func TestMatchSchedule(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("load location: %v", err)
+	}
+	// Monday 15 January 2024
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, 15+day, hour, minute, 0, 0, berlin)
+	}
+	office := &models.RuleSchedule{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00", Timezone: "Europe/Berlin"}
+	night := &models.RuleSchedule{Days: []string{"fri"}, Start: "22:00", End: "06:00", Timezone: "Europe/Berlin"}
+	tests := []struct {
+		name     string
+		schedule *models.RuleSchedule
+		time     time.Time
+		want     bool
+	}{
+		{"office hours", office, at(0, 9, 0), true},
+		{"before office hours", office, at(0, 8, 59), false},
+		{"end is exclusive", office, at(0, 17, 0), false},
+		{"weekend", office, at(5, 12, 0), false},
+		{"in another zone", office, at(0, 9, 30).UTC(), true},
+		{"overnight on its day", night, at(4, 23, 0), true},
+		{"overnight after midnight", night, at(5, 5, 59), true},
+		{"overnight ended", night, at(5, 6, 0), false},
+		{"overnight after midnight of another day", night, at(4, 5, 0), false},
+		{"every day, all day", &models.RuleSchedule{}, at(6, 0, 0), true},
+		{"unknown zone", &models.RuleSchedule{Timezone: "Mars/Olympus"}, at(0, 12, 0), false},
+	}
+
+	for _, tt := range tests {
+		if got := matchSchedule(tt.schedule, tt.time); got != tt.want {
+			t.Errorf("%s: matchSchedule() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []models.RedirectRule
+		valid bool
+	}{
+		{"none", nil, true},
+		{"condition", []models.RedirectRule{{URL: "https://example.de/", Countries: []string{"DE"}}}, true},
+		{"schedule", []models.RedirectRule{{URL: "https://example.com/", Schedule: &models.RuleSchedule{Timezone: "America/New_York"}}}, true},
+		{"no conditions", []models.RedirectRule{{URL: "https://example.com/"}}, false},
+		{"unknown zone", []models.RedirectRule{{URL: "https://example.com/", Schedule: &models.RuleSchedule{Timezone: "Mars/Olympus"}}}, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateRules(tt.rules)
+		if tt.valid && err != nil {
+			t.Errorf("%s: ValidateRules() error = %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidRules) {
+			t.Errorf("%s: ValidateRules() error = %v, want ErrInvalidRules", tt.name, err)
+		}
+	}
+}
+
+func TestGetRedirectURL(t *testing.T) {
+	link := &models.URL{
+		OriginalURL:    "https://example.com/",
+		IOSRedirectURL: "https://apps.apple.com/app",
+		Rules: []models.RedirectRule{
+			{URL: "https://example.de/", Countries: []string{"DE"}},
+			{URL: "https://example.com/ios-de", Countries: []string{"DE"}, Devices: []string{"ios"}},
+		},
+	}
+	tests := []struct {
+		name    string
+		visitor Visitor
+		want    string
+		matched bool
+	}{
+		{"first matching rule wins", Visitor{Country: "DE", Platform: "ios"}, "https://example.de/", true},
+		{"rules come before platform URLs", Visitor{Country: "DE", Platform: "android"}, "https://example.de/", true},
+		{"platform URL", Visitor{Country: "FR", Platform: "ios"}, "https://apps.apple.com/app", true},
+		{"no match", Visitor{Country: "FR", Platform: "desktop"}, "https://example.com/", false},
+	}
+
+	for _, tt := range tests {
+		got, matched := GetRedirectURL(link, tt.visitor)
+		if got != tt.want || matched != tt.matched {
+			t.Errorf("%s: GetRedirectURL() = %q, %v, want %q, %v", tt.name, got, matched, tt.want, tt.matched)
+		}
+	}
+	if len(link.Rules) != 2 {
+		t.Errorf("GetRedirectURL() changed the link's rules: %+v", link.Rules)
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"de-DE,de;q=0.9,en;q=0.8", "de-DE"},
+		{"en;q=0.5, fr;q=0.8", "fr"},
+		{"fr, de", "fr"},
+		{"*, nl;q=0.5", "nl"},
+		{"en;q=0", ""},
+		{"en;q=abc, es", "es"},
+	}
+
+	for _, tt := range tests {
+		if got := PreferredLanguage(tt.header); got != tt.want {
+			t.Errorf("PreferredLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Stored secret formats, identified by prefix. Legacy hashes are a bare
+// unsalted SHA-256 hex digest and are only ever verified, never produced.
+const (
+	hmacPrefix     = "hmac-sha256$"
+	argon2idPrefix = "argon2id$"
+)
+
+// argon2id parameters (OWASP baseline: 19 MiB, 2 iterations, 1 thread)
+const (
+	argon2Memory  = 19 * 1024
+	argon2Time    = 2
+	argon2Threads = 1
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// SecretHasher hashes API key secrets for storage. With an HMAC key it uses
+// HMAC-SHA256, which is fast enough to run on every request; without one it
+// falls back to salted argon2id.
+type SecretHasher struct {
+	hmacKey []byte
+}
+
+func NewSecretHasher(hmacKey string) *SecretHasher {
+	return &SecretHasher{hmacKey: []byte(hmacKey)}
+}
+
+// Hash returns the storage form of secret in the preferred format
+func (h *SecretHasher) Hash(secret string) (string, error) {
+	if len(h.hmacKey) > 0 {
+		return hmacPrefix + h.hmac(secret), nil
+	}
+
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(secret), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks secret against a stored hash in any supported format, in
+// constant time. needsRehash is true when the secret matched but the stored
+// hash isn't in the preferred format and should be replaced.
+func (h *SecretHasher) Verify(secret, stored string) (ok, needsRehash bool) {
+	switch {
+	case strings.HasPrefix(stored, hmacPrefix):
+		if len(h.hmacKey) == 0 {
+			return false, false
+		}
+		ok = constantTimeEqual(h.hmac(secret), strings.TrimPrefix(stored, hmacPrefix))
+		return ok, false
+	case strings.HasPrefix(stored, argon2idPrefix):
+		ok, current := verifyArgon2id(secret, stored)
+		return ok, ok && (len(h.hmacKey) > 0 || !current)
+	default:
+		digest := sha256.Sum256([]byte(secret))
+		ok = constantTimeEqual(hex.EncodeToString(digest[:]), stored)
+		return ok, ok
+	}
+}
+
+func (h *SecretHasher) hmac(secret string) string {
+	mac := hmac.New(sha256.New, h.hmacKey)
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyArgon2id checks an encoded argon2id hash; current reports whether it
+// was made with today's parameters
+func verifyArgon2id(secret, stored string) (ok, current bool) {
+	parts := strings.Split(strings.TrimPrefix(stored, argon2idPrefix), "$")
+	if len(parts) != 4 {
+		return false, false
+	}
+
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false, false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false, false
+	}
+
+	key := argon2.IDKey([]byte(secret), salt, iterations, memory, threads, uint32(len(expected)))
+	ok = subtle.ConstantTimeCompare(key, expected) == 1
+	current = memory == argon2Memory && iterations == argon2Time && threads == argon2Threads
+	return ok, current
+}
+
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestSecretHasher(t *testing.T) {
+	const secret = "sk_test"
+	sum := sha256.Sum256([]byte(secret))
+	legacy := hex.EncodeToString(sum[:])
+
+	withHMAC := NewSecretHasher("pepper")
+	withoutHMAC := NewSecretHasher("")
+	hmacHash, err := withHMAC.Hash(secret)
+	if err != nil || !strings.HasPrefix(hmacHash, hmacPrefix) {
+		t.Fatalf("Hash() with HMAC key = %q, %v", hmacHash, err)
+	}
+	argonHash, err := withoutHMAC.Hash(secret)
+	if err != nil || !strings.HasPrefix(argonHash, argon2idPrefix) {
+		t.Fatalf("Hash() without HMAC key = %q, %v", argonHash, err)
+	}
+	if again, _ := withoutHMAC.Hash(secret); again == argonHash {
+		t.Error("argon2id hashes of the same secret aren't salted")
+	}
+
+	tests := []struct {
+		name        string
+		hasher      *SecretHasher
+		secret      string
+		stored      string
+		ok          bool
+		needsRehash bool
+	}{
+		{"HMAC", withHMAC, secret, hmacHash, true, false},
+		{"HMAC, wrong secret", withHMAC, "sk_wrong", hmacHash, false, false},
+		{"HMAC without the key", withoutHMAC, secret, hmacHash, false, false},
+		{"HMAC with another key", NewSecretHasher("other"), secret, hmacHash, false, false},
+		{"argon2id", withoutHMAC, secret, argonHash, true, false},
+		{"argon2id, wrong secret", withoutHMAC, "sk_wrong", argonHash, false, false},
+		{"argon2id once an HMAC key is set", withHMAC, secret, argonHash, true, true},
+		{"legacy SHA-256", withoutHMAC, secret, legacy, true, true},
+		{"legacy SHA-256, wrong secret", withHMAC, "sk_wrong", legacy, false, false},
+		{"corrupt argon2id", withoutHMAC, secret, argon2idPrefix + "v=19$garbage", false, false},
+	}
+
+	for _, tt := range tests {
+		ok, needsRehash := tt.hasher.Verify(tt.secret, tt.stored)
+		if ok != tt.ok || needsRehash != tt.needsRehash {
+			t.Errorf("%s: Verify() = %v, %v, want %v, %v", tt.name, ok, needsRehash, tt.ok, tt.needsRehash)
+		}
+	}
+}
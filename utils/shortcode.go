@@ -2,11 +2,31 @@ package utils
 
 import (
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"math/big"
+	"strings"
 )
 
 const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
+// Alias length bounds for custom (vanity) short codes
+const (
+	MinAliasLength = 3
+	MaxAliasLength = 32
+)
+
+// reservedAliases are path segments already used by the application's own routes
+var reservedAliases = map[string]bool{
+	"admin":     true,
+	"api":       true,
+	"static":    true,
+	"health":    true,
+	"dashboard": true,
+}
+
+var ErrInvalidAlias = errors.New("invalid alias")
+
 func GenerateShortCode(length int) (string, error) {
 	result := make([]byte, length)
 	charsetLength := big.NewInt(int64(len(charset)))
@@ -21,3 +41,46 @@ func GenerateShortCode(length int) (string, error) {
 
 	return string(result), nil
 }
+
+// ValidateAlias checks a custom alias for length, allowed characters and reserved words
+func ValidateAlias(alias string) error {
+	if len(alias) < MinAliasLength || len(alias) > MaxAliasLength {
+		return fmt.Errorf("%w: must be between %d and %d characters", ErrInvalidAlias, MinAliasLength, MaxAliasLength)
+	}
+
+	for _, ch := range alias {
+		if !isCodeChar(ch) {
+			return fmt.Errorf("%w: only letters, digits, '-' and '_' are allowed", ErrInvalidAlias)
+		}
+	}
+
+	if alias[0] == '-' || alias[0] == '_' {
+		return fmt.Errorf("%w: must start with a letter or digit", ErrInvalidAlias)
+	}
+
+	if reservedAliases[strings.ToLower(alias)] {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidAlias, alias)
+	}
+
+	return nil
+}
+
+// IsValidShortCode reports whether a path segment could be a short code (generated or alias)
+func IsValidShortCode(code string) bool {
+	if code == "" || len(code) > MaxAliasLength {
+		return false
+	}
+	for _, ch := range code {
+		if !isCodeChar(ch) {
+			return false
+		}
+	}
+	return true
+}
+
+func isCodeChar(ch rune) bool {
+	return (ch >= 'a' && ch <= 'z') ||
+		(ch >= 'A' && ch <= 'Z') ||
+		(ch >= '0' && ch <= '9') ||
+		ch == '-' || ch == '_'
+}
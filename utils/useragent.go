@@ -0,0 +1,171 @@
+package utils
+
+import "strings"
+
+// Device classes
+const (
+	DevicePhone   = "phone"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceTV      = "tv"
+	DeviceBot     = "bot"
+)
+
+// uaBrowser recognises a browser by any of its tokens. The version follows the
+// matched token, or the version token when one is set.
+type uaBrowser struct {
+	name    string
+	tokens  []string
+	version string
+}
+
+// uaBrowsers is checked in order, so apps and browsers that also send another
+// browser's token come first: in-app browsers and Edge, Opera and the like
+// before Chrome, and Chrome before Safari. Tokens are lower case.
+var uaBrowsers = []uaBrowser{
+	{name: "Instagram", tokens: []string{"instagram "}},
+	{name: "Facebook", tokens: []string{"fbav/", "fban/", "fb_iab/"}},
+	{name: "LinkedIn", tokens: []string{"linkedinapp/"}},
+	{name: "WeChat", tokens: []string{"micromessenger/"}},
+	{name: "Snapchat", tokens: []string{"snapchat/"}},
+	{name: "TikTok", tokens: []string{"musical_ly_", "bytedancewebview"}},
+	{name: "Edge", tokens: []string{"edg/", "edga/", "edgios/", "edge/"}},
+	{name: "Opera", tokens: []string{"opr/", "opios/", "opt/", "opera/"}},
+	{name: "Samsung Internet", tokens: []string{"samsungbrowser/"}},
+	{name: "Yandex", tokens: []string{"yabrowser/"}},
+	{name: "Vivaldi", tokens: []string{"vivaldi/"}},
+	{name: "UC Browser", tokens: []string{"ucbrowser/"}},
+	{name: "Silk", tokens: []string{"silk/"}},
+	{name: "Firefox", tokens: []string{"firefox/", "fxios/"}},
+	{name: "Android WebView", tokens: []string{"; wv)"}, version: "chrome/"},
+	{name: "Chrome", tokens: []string{"crios/", "chrome/", "chromium/"}},
+	{name: "Safari", tokens: []string{"safari/"}, version: "version/"},
+	{name: "Internet Explorer", tokens: []string{"msie "}},
+	{name: "Internet Explorer", tokens: []string{"trident/"}, version: "rv:"},
+}
+
+// uaSystem recognises an operating system by any of its tokens and maps it to
+// the platform used for platform-specific redirects
+type uaSystem struct {
+	name     string
+	tokens   []string
+	version  string
+	platform string
+}
+
+// uaSystems is checked in order: Windows Phone and TV systems before Android
+// and Linux, whose tokens they also send, and iOS before macOS ("like Mac OS X")
+var uaSystems = []uaSystem{
+	{name: "Windows Phone", tokens: []string{"windows phone"}, version: "windows phone ", platform: "desktop"},
+	{name: "Tizen", tokens: []string{"tizen"}, version: "tizen ", platform: "desktop"},
+	{name: "webOS", tokens: []string{"web0s", "webos"}, platform: "desktop"},
+	{name: "tvOS", tokens: []string{"appletv", "apple tv"}, version: "tvos ", platform: "ios"},
+	{name: "iPadOS", tokens: []string{"ipad"}, version: "cpu os ", platform: "ios"},
+	{name: "iOS", tokens: []string{"iphone", "ipod"}, version: "iphone os ", platform: "ios"},
+	{name: "Android", tokens: []string{"android"}, version: "android ", platform: "android"},
+	{name: "ChromeOS", tokens: []string{"cros "}, platform: "desktop"},
+	{name: "Windows", tokens: []string{"windows"}, version: "windows nt ", platform: "desktop"},
+	{name: "macOS", tokens: []string{"macintosh", "mac os x"}, version: "mac os x ", platform: "mac"},
+	{name: "Linux", tokens: []string{"linux", "x11"}, platform: "desktop"},
+}
+
+// windowsVersions names Windows NT kernel versions. Windows 11 still reports 10.0.
+var windowsVersions = map[string]string{
+	"10.0": "10", "6.3": "8.1", "6.2": "8", "6.1": "7", "6.0": "Vista", "5.1": "XP",
+}
+
+// Tokens of the device classes other than phone and desktop, lower case
+var (
+	uaTVTokens     = []string{"smart-tv", "smarttv", "googletv", "android tv", "appletv", "apple tv", "crkey", "roku", "bravia", "hbbtv", "web0s", "; aft", "xbox", "playstation", "nintendo"}
+	uaTabletTokens = []string{"ipad", "tablet", "kindle", "silk/", "playbook"}
+)
+
+// ParseUserAgent classifies a User-Agent header. Browser and OS are "Unknown"
+// when not recognised; versions are left empty.
+//
+// iPads asking for desktop sites send a macOS user agent. Those from apps and
+// from Chrome, Firefox or Edge give themselves away with iOS-only tokens, but
+// Safari's is identical to a Mac's and is reported as macOS.
+func ParseUserAgent(userAgent string) PlatformInfo {
+	ua := strings.ToLower(userAgent)
+	info := PlatformInfo{Platform: "desktop", OS: "Unknown", Browser: "Unknown", Device: DeviceDesktop, Bot: BotName(userAgent)}
+
+	for _, system := range uaSystems {
+		if containsAny(ua, system.tokens) {
+			info.OS = system.name
+			info.Platform = system.platform
+			if system.version != "" {
+				info.OSVersion = uaVersion(ua, system.version, 2)
+			}
+			break
+		}
+	}
+	switch {
+	case info.OS == "Windows":
+		info.OSVersion = windowsVersions[info.OSVersion]
+	case info.OS == "macOS" && (strings.Contains(ua, "mobile/") || containsAny(ua, []string{"crios/", "fxios/", "edgios/"})):
+		info.OS, info.OSVersion, info.Platform = "iPadOS", "", "ios"
+	}
+
+	for _, browser := range uaBrowsers {
+		token, ok := firstToken(ua, browser.tokens)
+		if !ok {
+			continue
+		}
+		info.Browser = browser.name
+		if browser.version != "" {
+			token = browser.version
+		}
+		info.BrowserVersion = uaVersion(ua, token, 1)
+		break
+	}
+
+	switch {
+	case info.Bot != "":
+		info.Device = DeviceBot
+	case containsAny(ua, uaTVTokens) || info.OS == "tvOS":
+		info.Device = DeviceTV
+	case info.OS == "iPadOS" || containsAny(ua, uaTabletTokens) ||
+		(info.OS == "Android" && !strings.Contains(ua, "mobile")):
+		info.Device = DeviceTablet
+	case info.OS == "iOS" || info.OS == "Windows Phone" || strings.Contains(ua, "mobile"):
+		info.Device = DevicePhone
+	}
+
+	return info
+}
+
+func containsAny(s string, tokens []string) bool {
+	_, ok := firstToken(s, tokens)
+	return ok
+}
+
+// firstToken returns the first of tokens that s contains
+func firstToken(s string, tokens []string) (string, bool) {
+	for _, token := range tokens {
+		if strings.Contains(s, token) {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// uaVersion reads the version following token in ua, keeping at most parts
+// dot- or underscore-separated components, e.g. "17_1_2" becomes "17.1" for 2
+func uaVersion(ua, token string, parts int) string {
+	i := strings.Index(ua, token)
+	if i < 0 {
+		return ""
+	}
+	rest := ua[i+len(token):]
+	end := 0
+	for end < len(rest) && (rest[end] >= '0' && rest[end] <= '9' || rest[end] == '.' || rest[end] == '_') {
+		end++
+	}
+
+	components := strings.FieldsFunc(rest[:end], func(r rune) bool { return r == '.' || r == '_' })
+	if len(components) > parts {
+		components = components[:parts]
+	}
+	return strings.Join(components, ".")
+}
@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      PlatformInfo
+	}{
+		{
+			name:      "Safari on iPhone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1.2 Mobile/15E148 Safari/604.1",
+			want:      PlatformInfo{Platform: "ios", OS: "iOS", OSVersion: "17.1", Browser: "Safari", BrowserVersion: "17", Device: DevicePhone},
+		},
+		{
+			name:      "Chrome on iPhone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/119.0.6045.109 Mobile/15E148 Safari/604.1",
+			want:      PlatformInfo{Platform: "ios", OS: "iOS", OSVersion: "17.0", Browser: "Chrome", BrowserVersion: "119", Device: DevicePhone},
+		},
+		{
+			name:      "Safari on iPad",
+			userAgent: "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			want:      PlatformInfo{Platform: "ios", OS: "iPadOS", OSVersion: "16.6", Browser: "Safari", BrowserVersion: "16", Device: DeviceTablet},
+		},
+		{
+			name:      "iPadOS desktop mode in Chrome",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1",
+			want:      PlatformInfo{Platform: "ios", OS: "iPadOS", Browser: "Chrome", BrowserVersion: "120", Device: DeviceTablet},
+		},
+		{
+			name:      "iPadOS desktop mode in an app's web view",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148",
+			want:      PlatformInfo{Platform: "ios", OS: "iPadOS", Browser: "Unknown", Device: DeviceTablet},
+		},
+		{
+			// Identical to a Mac's, so it can't be told apart
+			name:      "iPadOS desktop mode in Safari",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			want:      PlatformInfo{Platform: "mac", OS: "macOS", OSVersion: "10.15", Browser: "Safari", BrowserVersion: "17", Device: DeviceDesktop},
+		},
+		{
+			name:      "Chrome on Android phone",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+			want:      PlatformInfo{Platform: "android", OS: "Android", OSVersion: "14", Browser: "Chrome", BrowserVersion: "120", Device: DevicePhone},
+		},
+		{
+			name:      "Chrome on Android tablet",
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36",
+			want:      PlatformInfo{Platform: "android", OS: "Android", OSVersion: "13", Browser: "Chrome", BrowserVersion: "119", Device: DeviceTablet},
+		},
+		{
+			name:      "Android WebView",
+			userAgent: "Mozilla/5.0 (Linux; Android 12; SM-G991B Build/SP1A.210812.016; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/118.0.5993.111 Mobile Safari/537.36",
+			want:      PlatformInfo{Platform: "android", OS: "Android", OSVersion: "12", Browser: "Android WebView", BrowserVersion: "118", Device: DevicePhone},
+		},
+		{
+			name:      "Samsung Internet before Chrome",
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SAMSUNG SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+			want:      PlatformInfo{Platform: "android", OS: "Android", OSVersion: "13", Browser: "Samsung Internet", BrowserVersion: "23", Device: DevicePhone},
+		},
+		{
+			name:      "Edge before Chrome",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			want:      PlatformInfo{Platform: "desktop", OS: "Windows", OSVersion: "10", Browser: "Edge", BrowserVersion: "120", Device: DeviceDesktop},
+		},
+		{
+			name:      "Edge on Android before Chrome",
+			userAgent: "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36 EdgA/120.0.2210.115",
+			want:      PlatformInfo{Platform: "android", OS: "Android", OSVersion: "10", Browser: "Edge", BrowserVersion: "120", Device: DevicePhone},
+		},
+		{
+			name:      "Opera before Chrome",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36 OPR/105.0.0.0",
+			want:      PlatformInfo{Platform: "desktop", OS: "Windows", OSVersion: "10", Browser: "Opera", BrowserVersion: "105", Device: DeviceDesktop},
+		},
+		{
+			name:      "Chrome on Windows 7",
+			userAgent: "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/109.0.0.0 Safari/537.36",
+			want:      PlatformInfo{Platform: "desktop", OS: "Windows", OSVersion: "7", Browser: "Chrome", BrowserVersion: "109", Device: DeviceDesktop},
+		},
+		{
+			name:      "Firefox on macOS",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.1; rv:121.0) Gecko/20100101 Firefox/121.0",
+			want:      PlatformInfo{Platform: "mac", OS: "macOS", OSVersion: "14.1", Browser: "Firefox", BrowserVersion: "121", Device: DeviceDesktop},
+		},
+		{
+			name:      "Firefox on Linux",
+			userAgent: "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			want:      PlatformInfo{Platform: "desktop", OS: "Linux", Browser: "Firefox", BrowserVersion: "121", Device: DeviceDesktop},
+		},
+		{
+			name:      "Chrome on ChromeOS",
+			userAgent: "Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			want:      PlatformInfo{Platform: "desktop", OS: "ChromeOS", Browser: "Chrome", BrowserVersion: "120", Device: DeviceDesktop},
+		},
+		{
+			name:      "Internet Explorer 11",
+			userAgent: "Mozilla/5.0 (Windows NT 6.3; Trident/7.0; rv:11.0) like Gecko",
+			want:      PlatformInfo{Platform: "desktop", OS: "Windows", OSVersion: "8.1", Browser: "Internet Explorer", BrowserVersion: "11", Device: DeviceDesktop},
+		},
+		{
+			name:      "Facebook in-app browser on iPhone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 [FBAN/FBIOS;FBAV/442.0.0.37.106;FBBV/544112520;FBDV/iPhone15,2;FBMD/iPhone;FBSN/iOS;FBSV/17.1;FBSS/3;FBCR/;FBID/phone;FBLC/en_US;FBOP/80]",
+			want:      PlatformInfo{Platform: "ios", OS: "iOS", OSVersion: "17.1", Browser: "Facebook", BrowserVersion: "442", Device: DevicePhone},
+		},
+		{
+			name:      "Facebook in-app browser on Android",
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SM-A536B Build/TP1A.220624.014; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/119.0.6045.163 Mobile Safari/537.36 [FB_IAB/FB4A;FBAV/443.0.0.23.229;]",
+			want:      PlatformInfo{Platform: "android", OS: "Android", OSVersion: "13", Browser: "Facebook", BrowserVersion: "443", Device: DevicePhone},
+		},
+		{
+			name:      "Instagram in-app browser",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 Instagram 309.1.1.28.108 (iPhone14,5; iOS 16_6; en_US; en; scale=3.00; 1170x2532; 536988435)",
+			want:      PlatformInfo{Platform: "ios", OS: "iOS", OSVersion: "16.6", Browser: "Instagram", BrowserVersion: "309", Device: DevicePhone},
+		},
+		{
+			name:      "WeChat in-app browser",
+			userAgent: "Mozilla/5.0 (Linux; Android 12; V2145A Build/SP1A.210812.003; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/107.0.5304.141 Mobile Safari/537.36 XWEB/5235 MMWEBSDK/20230805 MMWEBID/2803 MicroMessenger/8.0.42.2460(0x28002A37) WeChat/arm64 Weixin NetType/WIFI Language/zh_CN ABI/arm64",
+			want:      PlatformInfo{Platform: "android", OS: "Android", OSVersion: "12", Browser: "WeChat", BrowserVersion: "8", Device: DevicePhone},
+		},
+		{
+			name:      "Kindle Fire",
+			userAgent: "Mozilla/5.0 (Linux; Android 9; KFMAWI) AppleWebKit/537.36 (KHTML, like Gecko) Silk/118.4.1 like Chrome/118.0.5993.144 Safari/537.36",
+			want:      PlatformInfo{Platform: "android", OS: "Android", OSVersion: "9", Browser: "Silk", BrowserVersion: "118", Device: DeviceTablet},
+		},
+		{
+			name:      "Samsung smart TV",
+			userAgent: "Mozilla/5.0 (SMART-TV; LINUX; Tizen 6.5) AppleWebKit/537.36 (KHTML, like Gecko) 85.0.4183.93/6.5 TV Safari/537.36",
+			want:      PlatformInfo{Platform: "desktop", OS: "Tizen", OSVersion: "6.5", Browser: "Safari", Device: DeviceTV},
+		},
+		{
+			name:      "Googlebot",
+			userAgent: "Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.129 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want:      PlatformInfo{Platform: "android", OS: "Android", OSVersion: "6.0", Browser: "Chrome", BrowserVersion: "120", Device: DeviceBot, Bot: "Googlebot"},
+		},
+		{
+			name:      "curl",
+			userAgent: "curl/8.4.0",
+			want:      PlatformInfo{Platform: "desktop", OS: "Unknown", Browser: "Unknown", Device: DeviceBot, Bot: "curl"},
+		},
+		{
+			name:      "empty",
+			userAgent: "",
+			want:      PlatformInfo{Platform: "desktop", OS: "Unknown", Browser: "Unknown", Device: DeviceBot, Bot: BotNoUserAgent},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseUserAgent(tt.userAgent); got != tt.want {
+				t.Errorf("ParseUserAgent()\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectPlatform(t *testing.T) {
+	const iphone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1.2 Mobile/15E148 Safari/604.1"
+
+	r := httptest.NewRequest("GET", "/abc123", nil)
+	r.Header.Set("User-Agent", iphone)
+	r.Header.Set("Accept", "text/html")
+	if got := DetectPlatform(r); got.Bot != "" || got.Device != DevicePhone || got.Platform != "ios" {
+		t.Errorf("DetectPlatform() of a browser = %+v", got)
+	}
+
+	// A browser's user agent without the Accept header every browser sends
+	r.Header.Del("Accept")
+	got := DetectPlatform(r)
+	if got.Bot != BotNoAccept || got.Device != DeviceBot {
+		t.Errorf("DetectPlatform() without Accept = %+v, want bot %q", got, BotNoAccept)
+	}
+	if got.Platform != "ios" || got.OS != "iOS" || got.Browser != "Safari" {
+		t.Errorf("DetectPlatform() without Accept lost the user agent's platform: %+v", got)
+	}
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+
+	"url-shortener/models"
+)
+
+// utmFields pairs each UTM query parameter with its field, in the order they are appended
+func utmFields(utm *models.UTMParams) []struct {
+	key   string
+	value *string
+} {
+	return []struct {
+		key   string
+		value *string
+	}{
+		{"utm_source", &utm.Source},
+		{"utm_medium", &utm.Medium},
+		{"utm_campaign", &utm.Campaign},
+		{"utm_term", &utm.Term},
+		{"utm_content", &utm.Content},
+	}
+}
+
+// ExtractUTM removes the utm_source, utm_medium, utm_campaign, utm_term and
+// utm_content parameters from rawURL and returns them. Other parameters keep
+// their order and encoding; URLs without UTM parameters are returned unchanged.
+func ExtractUTM(rawURL string) (string, models.UTMParams) {
+	var utm models.UTMParams
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.RawQuery == "" {
+		return rawURL, utm
+	}
+
+	fields := utmFields(&utm)
+	var kept []string
+	found := false
+	for _, pair := range strings.Split(parsed.RawQuery, "&") {
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(rawKey)
+		matched := false
+		if err == nil {
+			for _, field := range fields {
+				if strings.EqualFold(key, field.key) {
+					if value, err := url.QueryUnescape(rawValue); err == nil {
+						*field.value = strings.TrimSpace(value)
+						matched = true
+					}
+					break
+				}
+			}
+		}
+		if matched {
+			found = true
+		} else if pair != "" {
+			kept = append(kept, pair)
+		}
+	}
+	if !found {
+		return rawURL, utm
+	}
+
+	parsed.RawQuery = strings.Join(kept, "&")
+	parsed.ForceQuery = false
+	return parsed.String(), utm
+}
+
+// MergeUTM fills the empty fields of utm from fallback
+func MergeUTM(utm, fallback models.UTMParams) models.UTMParams {
+	fields, fallbacks := utmFields(&utm), utmFields(&fallback)
+	for i, field := range fields {
+		if *field.value == "" {
+			*field.value = *fallbacks[i].value
+		}
+	}
+	return utm
+}
+
+// AppendUTM adds the non-empty UTM tags to rawURL's query string, replacing any
+// parameters of the same name. Unparseable URLs are returned unchanged.
+func AppendUTM(rawURL string, utm models.UTMParams) string {
+	if utm == (models.UTMParams{}) {
+		return rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	fields := utmFields(&utm)
+	var query []string
+	if parsed.RawQuery != "" {
+		for _, pair := range strings.Split(parsed.RawQuery, "&") {
+			rawKey, _, _ := strings.Cut(pair, "=")
+			key, _ := url.QueryUnescape(rawKey)
+			replaced := false
+			for _, field := range fields {
+				if *field.value != "" && strings.EqualFold(key, field.key) {
+					replaced = true
+					break
+				}
+			}
+			if !replaced && pair != "" {
+				query = append(query, pair)
+			}
+		}
+	}
+	for _, field := range fields {
+		if *field.value != "" {
+			query = append(query, field.key+"="+url.QueryEscape(*field.value))
+		}
+	}
+
+	parsed.RawQuery = strings.Join(query, "&")
+	return parsed.String()
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"url-shortener/models"
+)
+
+var ErrInvalidVariants = errors.New("invalid variants")
+
+// ValidateVariants checks the names of a link's A/B variants and that there is
+// either none or at least two of them
+func ValidateVariants(variants []models.LinkVariant) error {
+	if len(variants) == 1 {
+		return fmt.Errorf("%w: a split needs at least 2 variants", ErrInvalidVariants)
+	}
+
+	names := make(map[string]bool, len(variants))
+	for _, variant := range variants {
+		if variant.Name == "" || len(variant.Name) > 32 {
+			return fmt.Errorf("%w: names must be between 1 and 32 characters", ErrInvalidVariants)
+		}
+		for _, ch := range variant.Name {
+			if !isCodeChar(ch) {
+				return fmt.Errorf("%w: names may only contain letters, digits, '-' and '_'", ErrInvalidVariants)
+			}
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("%w: %q is used twice", ErrInvalidVariants, variant.Name)
+		}
+		names[variant.Name] = true
+		if variant.Weight < 1 {
+			return fmt.Errorf("%w: weights must be positive", ErrInvalidVariants)
+		}
+	}
+	return nil
+}
+
+// PickVariant chooses a variant in proportion to the weights. The choice only
+// depends on key, so the same visitor key gets the same variant for as long as
+// the variants don't change.
+func PickVariant(variants []models.LinkVariant, key string) *models.LinkVariant {
+	total := 0
+	for _, variant := range variants {
+		total += variant.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	point := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for i := range variants {
+		if point < variants[i].Weight {
+			return &variants[i]
+		}
+		point -= variants[i].Weight
+	}
+	return nil
+}
+
+// FindVariant returns the variant with the given name, or nil
+func FindVariant(variants []models.LinkVariant, name string) *models.LinkVariant {
+	for i := range variants {
+		if variants[i].Name == name {
+			return &variants[i]
+		}
+	}
+	return nil
+}
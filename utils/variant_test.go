@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"url-shortener/models"
+)
+
+func TestValidateVariants(t *testing.T) {
+	a := models.LinkVariant{Name: "a", URL: "https://example.com/a", Weight: 1}
+	b := models.LinkVariant{Name: "b-2_x", URL: "https://example.com/b", Weight: 3}
+	tests := []struct {
+		name     string
+		variants []models.LinkVariant
+		valid    bool
+	}{
+		{"none", nil, true},
+		{"two", []models.LinkVariant{a, b}, true},
+		{"one", []models.LinkVariant{a}, false},
+		{"duplicate name", []models.LinkVariant{a, a}, false},
+		{"empty name", []models.LinkVariant{a, {URL: b.URL, Weight: 1}}, false},
+		{"long name", []models.LinkVariant{a, {Name: strings.Repeat("x", 33), URL: b.URL, Weight: 1}}, false},
+		{"name with space", []models.LinkVariant{a, {Name: "b c", URL: b.URL, Weight: 1}}, false},
+		{"zero weight", []models.LinkVariant{a, {Name: "b", URL: b.URL}}, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateVariants(tt.variants)
+		if tt.valid && err != nil {
+			t.Errorf("%s: ValidateVariants() error = %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidVariants) {
+			t.Errorf("%s: ValidateVariants() error = %v, want ErrInvalidVariants", tt.name, err)
+		}
+	}
+}
+
+func TestPickVariant(t *testing.T) {
+	variants := []models.LinkVariant{
+		{Name: "a", URL: "https://example.com/a", Weight: 1},
+		{Name: "b", URL: "https://example.com/b", Weight: 3},
+	}
+
+	// The same key always gets the same variant
+	first := PickVariant(variants, "192.0.2.1|Mozilla/5.0")
+	for i := 0; i < 10; i++ {
+		if got := PickVariant(variants, "192.0.2.1|Mozilla/5.0"); got != first {
+			t.Fatalf("PickVariant() = %v, then %v for the same key", first, got)
+		}
+	}
+
+	// Keys are split in proportion to the weights
+	picked := map[string]int{}
+	const keys = 10000
+	for i := 0; i < keys; i++ {
+		picked[PickVariant(variants, strconv.Itoa(i)).Name]++
+	}
+	if share := float64(picked["b"]) / keys; share < 0.72 || share > 0.78 {
+		t.Errorf("variant b got %.2f of keys, want about 0.75", share)
+	}
+
+	if got := PickVariant(nil, "key"); got != nil {
+		t.Errorf("PickVariant() without variants = %v, want nil", got)
+	}
+}
+
+func TestFindVariant(t *testing.T) {
+	variants := []models.LinkVariant{{Name: "a", Weight: 1}, {Name: "b", Weight: 1}}
+	if got := FindVariant(variants, "b"); got != &variants[1] {
+		t.Errorf("FindVariant(b) = %v, want the second variant", got)
+	}
+	if got := FindVariant(variants, "c"); got != nil {
+		t.Errorf("FindVariant(c) = %v, want nil", got)
+	}
+}